@@ -0,0 +1,170 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// Entities recorded in the audit trail.
+const (
+	auditRoom    = "room"
+	auditStream  = "stream"
+	auditDevice  = "device"
+	auditAPIKey  = "apikey"
+	auditWebhook = "webhook"
+	auditHold    = "hold"
+	// auditRecording is a recorded segment, deleted by retention.
+	auditRecording = "recording"
+)
+
+// auditRetention is the actor of recordings deleted by retention.
+const auditRetention = "retention"
+
+// auditEntry describes a change of an entity made by the request. before and after
+// are the entity around the change, nil if it didn't exist. The entry is passed to
+// the store along with the change, which records both or neither.
+func (s *Server) auditEntry(req *http.Request, action types.AuditAction, entity, entityID string, before, after interface{}, now time.Time) *types.AuditEntry {
+	return &types.AuditEntry{
+		ID:        types.NewID(),
+		Actor:     auditActor(req),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Before:    auditDoc(before),
+		After:     auditDoc(after),
+		SourceIP:  remoteHost(req),
+		RequestID: requestIDFrom(req),
+		CreatedAt: now,
+	}
+}
+
+// appendAudit records the entry in the audit trail, failures are logged and counted.
+func appendAudit(store storage.Adapter, e *types.AuditEntry) error {
+	if err := store.AuditAppend(e); err != nil {
+		stats.Inc("AuditFailures", 1)
+		logger.Errorf("audit: Failed to record %s of %s '%s' by %s, %v", e.Action, e.Entity, e.EntityID, e.Actor, err)
+		return err
+	}
+	return nil
+}
+
+// auditActor names the caller of the request, `anonymous` if authentication is disabled.
+func auditActor(req *http.Request) string {
+	if id := identityFrom(req); id != nil {
+		return id.Kind + ":" + id.Subject
+	}
+	if fromUnixSocket(req) {
+		return "unix"
+	}
+	return "anonymous"
+}
+
+// remoteHost returns the IP address of the caller, empty over a unix socket.
+func remoteHost(req *http.Request) string {
+	if fromUnixSocket(req) {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// auditDoc encodes the entity as it's returned by the API, so secrets are left out.
+func auditDoc(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// auditChange is a field of an entity changed by an audited call, either side is
+// missing if the field wasn't there.
+type auditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// auditResp is an audit entry with the fields which changed.
+type auditResp struct {
+	*types.AuditEntry
+	Diff map[string]auditChange `json:"diff"`
+}
+
+// auditDiff compares top level fields of JSON documents of an entity.
+func auditDiff(before, after json.RawMessage) map[string]auditChange {
+	var b, a map[string]json.RawMessage
+	if before != nil {
+		json.Unmarshal(before, &b)
+	}
+	if after != nil {
+		json.Unmarshal(after, &a)
+	}
+
+	diff := make(map[string]auditChange)
+	for name, v := range b {
+		if w, ok := a[name]; !ok || !bytes.Equal(v, w) {
+			diff[name] = auditChange{Before: v, After: w}
+		}
+	}
+	for name, w := range a {
+		if _, ok := b[name]; !ok {
+			diff[name] = auditChange{After: w}
+		}
+	}
+	return diff
+}
+
+// auditListSpec filters the audit trail, newest first by default.
+var auditListSpec = &listSpec{
+	filters: map[string]string{"entity": "entity", "entity_id": "entity_id", "actor": "actor", "action": "action"},
+	enums: map[string][]string{
+		"entity": {auditRoom, auditStream, auditDevice, auditAPIKey, auditWebhook, auditHold, auditRecording},
+		"action": {string(types.AuditCreate), string(types.AuditUpdate), string(types.AuditDelete)},
+	},
+	sorts:  []string{"created_at", "id"},
+	desc:   true,
+	window: "created_at",
+}
+
+// auditHandler serves `v0/audit`, the trail of changes. The trail is reserved to services.
+func (s *Server) auditHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	if !requireAPIKey(wrt, req, now) {
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeResp(wrt, ErrOperationNotAllowed(now))
+		return
+	}
+
+	q, err := auditListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	list, err := s.store.AuditList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(list), func(i int) types.Listed { return &list[i] })
+	resp := make([]auditResp, n)
+	for i := range resp {
+		resp[i] = auditResp{AuditEntry: &list[i], Diff: auditDiff(list[i].Before, list[i].After)}
+	}
+	writeResp(wrt, listResp(now, resp, next))
+}
@@ -0,0 +1,61 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+func TestAudit(t *testing.T) {
+	_, mux := newTestServer(t)
+	h := instrument(mux, mux)
+
+	if code, _ := doRequest(t, h, http.MethodPost, "/api/v0/rooms", map[string]string{"id": "room01", "name": "Room 01"}); code != http.StatusCreated {
+		t.Fatalf("create: got %d", code)
+	}
+	if code, _ := doRequest(t, h, http.MethodPut, "/api/v0/rooms/room01", map[string]string{"name": "Ultrasound"}); code != http.StatusOK {
+		t.Fatalf("update: got %d", code)
+	}
+	if code, _ := doRequest(t, h, http.MethodDelete, "/api/v0/rooms/room01", nil); code != http.StatusOK {
+		t.Fatalf("delete: got %d", code)
+	}
+
+	var trail []auditResp
+	code, data := doRequest(t, h, http.MethodGet, "/api/v0/audit?entity=room&entity_id=room01", nil)
+	if err := json.Unmarshal(data, &trail); err != nil || code != http.StatusOK || len(trail) != 3 {
+		t.Fatalf("audit: got %d, %s", code, data)
+	}
+	// calls within a millisecond are in no particular order.
+	byAction := make(map[types.AuditAction]auditResp)
+	for _, e := range trail {
+		byAction[e.Action] = e
+	}
+	update := byAction[types.AuditUpdate]
+	if update.AuditEntry == nil || update.Actor != "anonymous" || update.SourceIP != "192.0.2.1" || update.RequestID == "" {
+		t.Fatalf("update: got %+v", update.AuditEntry)
+	}
+	if name := update.Diff["name"]; string(name.Before) != `"Room 01"` || string(name.After) != `"Ultrasound"` {
+		t.Errorf("update diff: got %+v", update.Diff)
+	}
+	if _, ok := update.Diff["id"]; ok {
+		t.Error("unchanged field in diff")
+	}
+	if del := byAction[types.AuditDelete]; del.AuditEntry == nil || del.Before == nil || del.After != nil {
+		t.Errorf("delete: got %+v", del.AuditEntry)
+	}
+
+	// streams of the room are created and deleted with it.
+	code, data = doRequest(t, h, http.MethodGet, "/api/v0/audit?entity=stream", nil)
+	if err := json.Unmarshal(data, &trail); err != nil || code != http.StatusOK || len(trail) != 4 {
+		t.Errorf("stream audit: got %d, %s", code, data)
+	}
+
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/audit?limit=0", nil); code != http.StatusBadRequest {
+		t.Errorf("zero limit: got %d", code)
+	}
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/audit?from=tomorrow", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid window: got %d", code)
+	}
+}
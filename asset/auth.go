@@ -0,0 +1,439 @@
+package asset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const (
+	// defaultTokenTTL is the lifetime of bearer tokens unless requested otherwise.
+	defaultTokenTTL = time.Hour
+	// maxTokenTTL is the longest lifetime a bearer token may be issued for.
+	maxTokenTTL = 24 * time.Hour
+	// minTokenKeyLen is the minimum length of the token signing key in bytes.
+	minTokenKeyLen = 32
+
+	// apiKeyHeader carries API keys of services.
+	apiKeyHeader = "X-API-Key"
+	// apiKeySecretLen is the length of random API key secrets in bytes.
+	apiKeySecretLen = 32
+)
+
+// Kinds of authenticated callers.
+const (
+	authAPIKey = "apikey"
+	authToken  = "token"
+	// authDevice is a device presenting a client certificate issued for its serial.
+	authDevice = "device"
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errTokenExpired       = errors.New("token expired")
+)
+
+// authConfig configures authentication of API calls.
+type authConfig struct {
+	// Enabled requires every call to present an API key or a bearer token.
+	Enabled bool `yaml:"enabled"`
+	// TokenKey is the base64 encoded secret bearer tokens are signed with. Tokens are
+	// not accepted if not set.
+	TokenKey string `yaml:"token_key"`
+	// TokenTTL is the lifetime of issued tokens unless requested otherwise.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// identity is an authenticated caller.
+type identity struct {
+	Kind string `json:"kind"`
+	// Subject is the API key ID or the subject of the token.
+	Subject string `json:"subject"`
+}
+
+type identityKey struct{}
+
+// identityFrom returns the caller of the request, nil if authentication is disabled.
+func identityFrom(req *http.Request) *identity {
+	id, _ := req.Context().Value(identityKey{}).(*identity)
+	return id
+}
+
+// tokenClaims is the signed payload of a bearer token.
+type tokenClaims struct {
+	Subject string `json:"sub"`
+	// Stream limits the token to playing the stream with this key, see slsEventHandler.
+	Stream    string `json:"stream,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenSigner issues and verifies HMAC-SHA256 signed bearer tokens of the form
+// `base64url(claims).base64url(signature)`.
+type tokenSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+func newTokenSigner(cfg *authConfig) (*tokenSigner, error) {
+	if cfg.TokenKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.TokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth token_key, %v", err)
+	}
+	if len(key) < minTokenKeyLen {
+		return nil, fmt.Errorf("auth token_key must be at least %d bytes long", minTokenKeyLen)
+	}
+	ttl := cfg.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	return &tokenSigner{key: key, ttl: ttl}, nil
+}
+
+func (ts *tokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, ts.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue creates a token for the subject valid for ttl, or the default lifetime if zero.
+// A token with a stream key set is a play token of the stream and can't call the API.
+func (ts *tokenSigner) issue(subject, stream string, ttl time.Duration, now time.Time) (string, time.Time) {
+	if ttl <= 0 {
+		ttl = ts.ttl
+	}
+	if ttl > maxTokenTTL {
+		ttl = maxTokenTTL
+	}
+	exp := now.Add(ttl).Truncate(time.Second)
+	data, _ := json.Marshal(&tokenClaims{Subject: subject, Stream: stream, IssuedAt: now.Unix(), ExpiresAt: exp.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + ts.sign(payload), exp
+}
+
+// verify checks signature and expiration of the token.
+func (ts *tokenSigner) verify(token string, now time.Time) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(ts.sign(parts[0]))) {
+		return nil, errInvalidCredentials
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Subject == "" {
+		return nil, errInvalidCredentials
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	return &claims, nil
+}
+
+// hashSecret returns hex encoded SHA-256 of an API key secret.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey generates an API key and returns it together with the key to give to the
+// caller, `{id}.{secret}`. Only the hash of the secret is kept.
+func newAPIKey(name string, now time.Time) (*types.APIKey, string, error) {
+	b := make([]byte, apiKeySecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	key := &types.APIKey{ID: types.NewID(), Name: name, Hash: hashSecret(secret), CreatedAt: now}
+	return key, key.ID + "." + secret, nil
+}
+
+// checkAPIKey verifies an API key of the form `{id}.{secret}`.
+func (s *Server) checkAPIKey(value string) (*identity, error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !idPattern.MatchString(parts[0]) {
+		return nil, errInvalidCredentials
+	}
+	key, err := s.store.APIKeyGet(parts[0])
+	if err == types.ErrNotFound {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(parts[1]))) != 1 {
+		return nil, errInvalidCredentials
+	}
+	return &identity{Kind: authAPIKey, Subject: key.ID}, nil
+}
+
+// checkToken verifies a bearer token.
+func (s *Server) checkToken(token string, now time.Time) (*identity, error) {
+	if s.tokens == nil {
+		return nil, errInvalidCredentials
+	}
+	claims, err := s.tokens.verify(token, now)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Stream != "" {
+		return nil, errInvalidCredentials
+	}
+	return &identity{Kind: authToken, Subject: claims.Subject}, nil
+}
+
+// authenticate rejects calls without valid credentials when authentication is
+// enabled. Services present API keys in `X-API-Key` header, client apps present
+// bearer tokens in `Authorization` header. Devices may present client certificates
+// instead, which only allow heartbeats of the device named by the certificate.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if !s.cfg.Auth.Enabled {
+		return next
+	}
+	public := map[string]bool{
+		s.cfg.APIPath + "v0/index":        true,
+		s.cfg.APIPath + "v0/openapi.json": true,
+		// sls can't present credentials, callers are checked by address instead.
+		s.cfg.APIPath + "v0/sls/event": true,
+	}
+	// Browsers can't set headers of EventSource and WebSocket requests, so the feed
+	// also takes a bearer token as query parameter. Tokens are short-lived, unlike
+	// API keys which are never accepted in URLs.
+	feed := s.cfg.APIPath + "v0/events"
+
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		if public[req.URL.Path] {
+			next.ServeHTTP(wrt, req)
+			return
+		}
+
+		now := types.TimeNow()
+		var (
+			id  *identity
+			err = errInvalidCredentials
+		)
+		if key := req.Header.Get(apiKeyHeader); key != "" {
+			id, err = s.checkAPIKey(key)
+		} else if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			id, err = s.checkToken(strings.TrimPrefix(auth, "Bearer "), now)
+		} else if token := req.URL.Query().Get("access_token"); token != "" && req.URL.Path == feed {
+			id, err = s.checkToken(token, now)
+		} else if serial := deviceCertSubject(req); serial != "" {
+			if req.URL.Path != s.cfg.APIPath+"v0/devices/"+serial+"/heartbeat" {
+				writeResp(wrt, ErrPermissionDenied(now))
+				return
+			}
+			id, err = &identity{Kind: authDevice, Subject: serial}, nil
+		}
+
+		switch err {
+		case nil:
+			next.ServeHTTP(wrt, req.WithContext(context.WithValue(req.Context(), identityKey{}, id)))
+		case errInvalidCredentials, errTokenExpired:
+			logger.Warnf("auth: Rejected %s %s from %s, %v", req.Method, req.URL.Path, req.RemoteAddr, err)
+			wrt.Header().Set("WWW-Authenticate", `Bearer realm="asset-server"`)
+			writeResp(wrt, ErrUnauthorized(now).WithParam("reason", err.Error()))
+		default:
+			writeResp(wrt, decodeStoreError(err, now))
+		}
+	})
+}
+
+// requireAPIKey allows the call to services only. Everyone is allowed if
+// authentication is disabled.
+func requireAPIKey(wrt http.ResponseWriter, req *http.Request, now time.Time) bool {
+	if id := identityFrom(req); id != nil && id.Kind != authAPIKey {
+		writeResp(wrt, ErrPermissionDenied(now))
+		return false
+	}
+	return true
+}
+
+// apiKeyReq is the body of API key create requests.
+type apiKeyReq struct {
+	Name string `json:"name"`
+}
+
+// apiKeyResp is a newly created API key, the only time the key is revealed.
+type apiKeyResp struct {
+	*types.APIKey
+	Key string `json:"key"`
+}
+
+// tokenReq is the body of token requests.
+type tokenReq struct {
+	Subject string `json:"subject"`
+	// Stream is the key of the stream to issue a play token for, if set.
+	Stream string `json:"stream"`
+	// TTL is the requested lifetime of the token in seconds.
+	TTL int64 `json:"ttl"`
+}
+
+// tokenResp is an issued bearer token.
+type tokenResp struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// authHandler serves `v0/auth/keys` collection, `v0/auth/keys/{id}` items and
+// `v0/auth/tokens`. All of them are reserved to services.
+func (s *Server) authHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	if !requireAPIKey(wrt, req, now) {
+		return
+	}
+
+	rest := strings.TrimPrefix(req.URL.Path, s.cfg.APIPath+"v0/auth")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "keys":
+		switch req.Method {
+		case http.MethodGet:
+			s.apiKeyList(wrt, req, now)
+		case http.MethodPost:
+			s.apiKeyCreate(wrt, req, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 2 && parts[0] == "keys":
+		if req.Method != http.MethodDelete {
+			writeResp(wrt, ErrOperationNotAllowed(now))
+			return
+		}
+		s.apiKeyDelete(wrt, req, parts[1], now)
+
+	case len(parts) == 1 && parts[0] == "tokens":
+		if req.Method != http.MethodPost {
+			writeResp(wrt, ErrOperationNotAllowed(now))
+			return
+		}
+		s.tokenIssue(wrt, req, now)
+
+	default:
+		writeResp(wrt, ErrNotFound(now))
+	}
+}
+
+// apiKeyListSpec filters API keys by name.
+var apiKeyListSpec = &listSpec{
+	filters: map[string]string{"name": "name"},
+	sorts:   []string{"id", "name", "created_at"},
+	window:  "created_at",
+}
+
+func (s *Server) apiKeyList(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	q, err := apiKeyListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	keys, err := s.store.APIKeyList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(keys), func(i int) types.Listed { return &keys[i] })
+	writeResp(wrt, listResp(now, keys[:n], next))
+}
+
+func (s *Server) apiKeyCreate(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	var body apiKeyReq
+	if err := decodeBody(req, apiKeyCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	key, value, err := newAPIKey(body.Name, now)
+	if err != nil {
+		writeResp(wrt, ErrInternal(now))
+		return
+	}
+	if err := s.store.APIKeyCreate(key, s.auditEntry(req, types.AuditCreate, auditAPIKey, key.ID, nil, key, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("auth: API key '%s' (%s) created", key.ID, key.Name)
+	writeResp(wrt, NoErrCreated(now, apiKeyResp{APIKey: key, Key: value}))
+}
+
+func (s *Server) apiKeyDelete(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	key, err := s.store.APIKeyGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if err := s.store.APIKeyDelete(id, s.auditEntry(req, types.AuditDelete, auditAPIKey, id, key, nil, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("auth: API key '%s' revoked", id)
+	writeResp(wrt, NoErr(now, nil))
+}
+
+// tokenIssue signs a bearer token services hand out to client apps.
+func (s *Server) tokenIssue(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	if s.tokens == nil {
+		writeResp(wrt, ErrOperationNotAllowed(now).WithParam("reason", "tokens are disabled"))
+		return
+	}
+	var body tokenReq
+	if err := decodeBody(req, tokenIssueSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.Stream != "" {
+		if _, err := s.streamByKey(body.Stream); err != nil {
+			writeResp(wrt, decodeStoreError(err, now))
+			return
+		}
+	}
+	// Clamp before converting, large TTLs would overflow the duration.
+	ttl := maxTokenTTL
+	if body.TTL < int64(maxTokenTTL/time.Second) {
+		ttl = time.Duration(body.TTL) * time.Second
+	}
+	token, exp := s.tokens.issue(body.Subject, body.Stream, ttl, now)
+	writeResp(wrt, NoErrCreated(now, tokenResp{Token: token, ExpiresAt: exp}))
+}
+
+// NewAPIKey creates an API key named after the configured name and prints it, so
+// that the first service can be given access.
+func (s *Server) NewAPIKey() error {
+	if err := s.openStore(); err != nil {
+		return err
+	}
+	defer s.store.Close()
+
+	if err := storage.CheckDbVersion(s.store); err != nil {
+		return err
+	}
+	key, value, err := newAPIKey(s.cfg.NewAPIKey, types.TimeNow())
+	if err != nil {
+		return err
+	}
+	if err := s.store.APIKeyCreate(key); err != nil {
+		return fmt.Errorf("fail to store API key, %v", err)
+	}
+	logger.Infof("API key '%s' created, it cannot be shown again", key.ID)
+	fmt.Println(value)
+	return nil
+}
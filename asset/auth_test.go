@@ -0,0 +1,98 @@
+package asset
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// withHeader sets a request header before passing the request on.
+func withHeader(h http.Handler, key, value string) http.Handler {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		req.Header.Set(key, value)
+		h.ServeHTTP(wrt, req)
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Auth = authConfig{Enabled: true, TokenKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))}
+	tokens, err := newTokenSigner(&s.cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens = tokens
+	h := s.authenticate(mux)
+
+	key, value, err := newAPIKey("relay", types.TimeNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.APIKeyCreate(key); err != nil {
+		t.Fatal(err)
+	}
+	service := withHeader(h, apiKeyHeader, value)
+
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/index", nil); code != http.StatusOK {
+		t.Errorf("public path: got %d", code)
+	}
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/rooms", nil); code != http.StatusUnauthorized {
+		t.Errorf("no credentials: got %d", code)
+	}
+	if code, _ := doRequest(t, withHeader(h, apiKeyHeader, key.ID+".wrong"), http.MethodGet, "/api/v0/rooms", nil); code != http.StatusUnauthorized {
+		t.Errorf("wrong API key: got %d", code)
+	}
+	if code, _ := doRequest(t, service, http.MethodGet, "/api/v0/rooms", nil); code != http.StatusOK {
+		t.Errorf("API key: got %d", code)
+	}
+
+	code, data := doRequest(t, service, http.MethodPost, "/api/v0/auth/tokens", map[string]interface{}{"subject": "app01", "ttl": 60})
+	var tok tokenResp
+	if err := json.Unmarshal(data, &tok); err != nil || code != http.StatusCreated {
+		t.Fatalf("issue token: got %d, %s", code, data)
+	}
+	if code, _ := doRequest(t, service, http.MethodPost, "/api/v0/auth/tokens", map[string]interface{}{"subject": "app01", "ttl": int64(1) << 62}); code != http.StatusBadRequest {
+		t.Errorf("issue token beyond a day: got %d", code)
+	}
+	client := withHeader(h, "Authorization", "Bearer "+tok.Token)
+	if code, _ := doRequest(t, client, http.MethodGet, "/api/v0/rooms", nil); code != http.StatusOK {
+		t.Errorf("bearer token: got %d", code)
+	}
+	if code, _ := doRequest(t, client, http.MethodGet, "/api/v0/auth/keys", nil); code != http.StatusForbidden {
+		t.Errorf("key management with token: got %d", code)
+	}
+	// An invalid last_event_id makes the feed answer instead of streaming.
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/events?last_event_id=x&access_token="+tok.Token, nil); code != http.StatusBadRequest {
+		t.Errorf("feed with token in query: got %d", code)
+	}
+	if code, _ := doRequest(t, h, http.MethodGet, "/api/v0/rooms?access_token="+tok.Token, nil); code != http.StatusUnauthorized {
+		t.Errorf("API with token in query: got %d", code)
+	}
+	if code, _ := doRequest(t, withHeader(h, "Authorization", "Bearer "+tok.Token+"x"), http.MethodGet, "/api/v0/rooms", nil); code != http.StatusUnauthorized {
+		t.Errorf("tampered token: got %d", code)
+	}
+	expired, _ := s.tokens.issue("app01", "", time.Minute, types.TimeNow().Add(-time.Hour))
+	if code, _ := doRequest(t, withHeader(h, "Authorization", "Bearer "+expired), http.MethodGet, "/api/v0/rooms", nil); code != http.StatusUnauthorized {
+		t.Errorf("expired token: got %d", code)
+	}
+
+	code, data = doRequest(t, service, http.MethodPost, "/api/v0/auth/keys", map[string]string{"name": "recorder"})
+	var created apiKeyResp
+	if err := json.Unmarshal(data, &created); err != nil || code != http.StatusCreated || created.Key == "" {
+		t.Fatalf("create key: got %d, %s", code, data)
+	}
+	if strings.Contains(string(data), hashSecret(strings.SplitN(created.Key, ".", 2)[1])) {
+		t.Errorf("create key: hash revealed, %s", data)
+	}
+	if code, _ := doRequest(t, service, http.MethodDelete, "/api/v0/auth/keys/"+created.ID, nil); code != http.StatusOK {
+		t.Errorf("revoke key: got %d", code)
+	}
+	if code, _ := doRequest(t, withHeader(h, apiKeyHeader, created.Key), http.MethodGet, "/api/v0/rooms", nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got %d", code)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/hub"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -20,12 +23,85 @@ const (
 type Config struct {
 	*flag.FlagSet
 
-	PIDFile    string `yaml:"pid_file"`
+	PIDFile string `yaml:"pid_file"`
+	// ListenAddr is the address everything is served on, unless listeners are configured.
 	ListenAddr string `yaml:"listen"`
-	APIPath    string `yaml:"api_path"`
-	ExpvarPath string `yaml:"expvar_path"`
-	PProfFile  string `yaml:"pprof"`
-	PProfURL   string `yaml:"pprof_url"`
+	// Listeners serve route groups on separate addresses, e.g. an admin port or a unix socket.
+	Listeners  []listenerConfig `yaml:"listeners"`
+	APIPath    string           `yaml:"api_path"`
+	ExpvarPath string           `yaml:"expvar_path"`
+	// MetricsPath is the URL path where stats are exposed in Prometheus format.
+	MetricsPath string `yaml:"metrics_path"`
+	PProfFile   string `yaml:"pprof"`
+	PProfURL    string `yaml:"pprof_url"`
+	// PProfSnapshots saves profiles periodically, see snapshotConfig.
+	PProfSnapshots snapshotConfig `yaml:"pprof_snapshots"`
+
+	// DeviceTimeout is how long a device is considered online after its last heartbeat.
+	DeviceTimeout time.Duration `yaml:"device_timeout"`
+
+	Store     *storage.Config `yaml:"store"`
+	Hub       hubConfig       `yaml:"hub"`
+	Auth      authConfig      `yaml:"auth"`
+	TLS       tlsConfig       `yaml:"tls"`
+	RateLimit rateLimitConfig `yaml:"rate_limit"`
+	CORS      corsConfig      `yaml:"cors"`
+	Retention retentionConfig `yaml:"retention"`
+
+	// InitDB asks to initialize the database schema and exit.
+	InitDB bool `yaml:"-"`
+	// UpgradeDB asks to upgrade the database schema to the latest version and exit.
+	UpgradeDB bool `yaml:"-"`
+	// NewAPIKey asks to create an API key with the given name, print it and exit.
+	NewAPIKey string `yaml:"-"`
+}
+
+// hubConfig describes how clients reach the SRT hub.
+type hubConfig struct {
+	// Config is the path of srt-server config file. If set, SRT settings below are read from it.
+	Config string `yaml:"config"`
+	// Host is the host name or IP address clients use to reach the hub.
+	Host string `yaml:"host"`
+	// HLSURL is the base URL where the HLS path of the hub is served over HTTP.
+	HLSURL string `yaml:"hls_url"`
+
+	ListenOn int    `yaml:"listen"`
+	Domain   string `yaml:"domain"`
+	HLSPath  string `yaml:"hls_path"`
+
+	// StatURL is where sls serves stream statistics. If not set, it's derived from
+	// `stat_port` of srt-server config, polling is disabled otherwise.
+	StatURL string `yaml:"stat_url"`
+	// StatInterval is how often stream statistics are polled.
+	StatInterval time.Duration `yaml:"stat_interval"`
+
+	// PlayAuth requires players to present a play token issued for the stream.
+	PlayAuth bool `yaml:"play_auth"`
+	// EventFrom lists addresses or CIDR ranges sls may call the event endpoint from,
+	// loopback only if empty.
+	EventFrom []string `yaml:"event_from"`
+}
+
+// load reads SRT settings from srt-server config file, if one is set.
+func (hc *hubConfig) load() error {
+	if hc.Config == "" {
+		return nil
+	}
+	cfg, err := hub.LoadConfig(hc.Config)
+	if err != nil {
+		return fmt.Errorf("fail to load hub config, %v", err)
+	}
+	hc.ListenOn = cfg.SRTCfg.ListenOn
+	hc.Domain = cfg.SRTCfg.Domain
+	hc.HLSPath = cfg.SRTCfg.HLSPath
+	if hc.StatURL == "" && cfg.SRTCfg.StatPort > 0 {
+		// sls runs next to the asset server.
+		hc.StatURL = fmt.Sprintf("http://127.0.0.1:%d%s", cfg.SRTCfg.StatPort, statPath)
+	}
+	if cfg.SRTCfg.OnEventURL == "" {
+		logger.Warnf("Hub does not call on_event_url, publish and play sessions are not authorized")
+	}
+	return nil
 }
 
 // NewConfig creates an instance of UDP mutiplex configuration.
@@ -49,8 +125,12 @@ func (cfg *Config) Parse(args []string) error {
 	fs.StringVar(&cfg.ListenAddr, "listen", "", "Override addess and port to listen on for HTTP clients.")
 	fs.StringVar(&cfg.APIPath, "api_path", "", "Override the base URL path where API is served.")
 	fs.StringVar(&cfg.ExpvarPath, "expvar", "", "Override the URL path where runtime stats are exposed. Use '-' to disable.")
-	fs.StringVar(&cfg.PProfFile, "pprof", "", "File name to save profiling info to. Disable if not set.")
+	fs.StringVar(&cfg.MetricsPath, "metrics", "", "Override the URL path where Prometheus metrics are exposed. Use '-' to disable.")
+	fs.StringVar(&cfg.PProfFile, "pprof", "", "File name to save profiling info to, CPU is profiled for the whole run so on-demand CPU profiles are refused. Disable if not set.")
 	fs.StringVar(&cfg.PProfURL, "pprof_url", "", "Debugging only! URL path for exposing profiling info. Disable if not set.")
+	fs.BoolVar(&cfg.InitDB, "init-db", false, "Initialize database schema and exit.")
+	fs.BoolVar(&cfg.UpgradeDB, "upgrade-db", false, "Upgrade database schema to the latest version and exit.")
+	fs.StringVar(&cfg.NewAPIKey, "new-api-key", "", "Create an API key with the given name, print it and exit.")
 	fs.StringVar(&level, "level", "info", "Log level, supported level: debug, info, error, fatal.")
 	fs.BoolVar(&showVersion, "v", false, "Print version information.")
 	fs.BoolVar(&showUsage, "h", false, "Show help message.")
@@ -92,6 +172,10 @@ func (cfg *Config) Parse(args []string) error {
 		return fmt.Errorf("%q is not a valid flag", fs.Arg(0))
 	}
 
+	if cfg.InitDB && cfg.UpgradeDB {
+		return fmt.Errorf("-init-db and -upgrade-db are mutually exclusive")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,85 @@
+package asset
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// corsMethods are methods browser clients may use.
+	corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	// corsHeaders are request headers browser clients may send.
+	corsHeaders = strings.Join([]string{"Authorization", "Content-Type", apiKeyHeader, requestIDHeader, "Last-Event-ID"}, ", ")
+	// corsExposed are response headers browser clients may read.
+	corsExposed = strings.Join([]string{requestIDHeader, "Retry-After"}, ", ")
+)
+
+// corsConfig lets browser clients served from other origins call the API.
+type corsConfig struct {
+	// AllowedOrigins lists origins of browser clients, e.g. `https://app.example.com`,
+	// or `*` for any. Cross-origin calls are refused if empty.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// MaxAge is how long browsers may cache preflight replies.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// allowed reports if browser clients of the origin may call the API.
+func (cc *corsConfig) allowed(origin string) bool {
+	for _, o := range cc.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// cors adds CORS headers to replies to allowed origins and answers preflight
+// requests, which come without credentials, before authentication.
+func (s *Server) cors(next http.Handler) http.Handler {
+	cfg := &s.cfg.CORS
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(wrt, req)
+			return
+		}
+
+		h := wrt.Header()
+		h.Add("Vary", "Origin")
+		if !cfg.allowed(origin) {
+			// without CORS headers browsers don't let the client see the reply.
+			next.ServeHTTP(wrt, req)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+			wrt.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposed)
+		next.ServeHTTP(wrt, req)
+	})
+}
+
+// checkOrigin lets WebSocket clients connect from the same origin or from the
+// origins allowed by CORS.
+func (s *Server) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || s.cfg.CORS.allowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
@@ -0,0 +1,361 @@
+package asset
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// defaultDeviceTimeout is how long a device stays online after its last heartbeat.
+const defaultDeviceTimeout = time.Minute
+
+// Device states derived from heartbeats.
+const (
+	deviceOnline  = "online"
+	deviceOffline = "offline"
+)
+
+// deviceReq is the body of device create and update requests.
+type deviceReq struct {
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	RoomID   string `json:"room_id"`
+	StreamID string `json:"stream_id"`
+}
+
+// deviceUpdateReq is the body of device update requests. The assignment changes
+// only if `room_id` or `stream_id` is present, an empty one clears it.
+type deviceUpdateReq struct {
+	Serial   string  `json:"serial"`
+	Model    string  `json:"model"`
+	RoomID   *string `json:"room_id"`
+	StreamID *string `json:"stream_id"`
+}
+
+// deviceResp is a device with its derived state.
+type deviceResp struct {
+	*types.Device
+	State string `json:"state"`
+}
+
+// deviceMonitor tracks online/offline state of devices and reports transitions.
+type deviceMonitor struct {
+	store   storage.Adapter
+	timeout time.Duration
+	// notify is called when a device changes state, if set.
+	notify func(serial, state string)
+
+	mu sync.Mutex
+	// last known state of each device, true if online.
+	online map[string]bool
+}
+
+func newDeviceMonitor(store storage.Adapter, timeout time.Duration) *deviceMonitor {
+	if timeout <= 0 {
+		timeout = defaultDeviceTimeout
+	}
+	return &deviceMonitor{store: store, timeout: timeout, online: make(map[string]bool)}
+}
+
+// stateOf derives device state from its last heartbeat.
+func (m *deviceMonitor) stateOf(d *types.Device, now time.Time) string {
+	if d.LastSeen != nil && now.Sub(*d.LastSeen) <= m.timeout {
+		return deviceOnline
+	}
+	return deviceOffline
+}
+
+// stateRange selects devices in the state by their last heartbeat, the same way as
+// stateOf, so that the storage backend filters them.
+func (m *deviceMonitor) stateRange(state string, now time.Time) (types.Range, bool) {
+	cutoff := now.Add(-m.timeout)
+	switch state {
+	case deviceOnline:
+		return types.Range{Field: "last_seen", From: &cutoff}, true
+	case deviceOffline:
+		return types.Range{Field: "last_seen", To: &cutoff}, true
+	}
+	return types.Range{}, false
+}
+
+// observe records the current state of a device and reports if it changed.
+func (m *deviceMonitor) observe(serial, state string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	online := state == deviceOnline
+	prev, known := m.online[serial]
+	m.online[serial] = online
+	// a newly seen device is a transition only if it comes online.
+	return prev != online && (known || online)
+}
+
+// forget drops state of a deleted device.
+func (m *deviceMonitor) forget(serial string) {
+	m.mu.Lock()
+	delete(m.online, serial)
+	m.mu.Unlock()
+}
+
+// transition handles a state change of a device.
+func (m *deviceMonitor) transition(serial, state string) {
+	logger.Infof("devices: Device '%s' is %s", serial, state)
+	stats.Inc("DeviceStateChanges", 1)
+	if m.notify != nil {
+		m.notify(serial, state)
+	}
+}
+
+// sweep checks all devices for state changes, e.g. missed heartbeats.
+func (m *deviceMonitor) sweep(now time.Time) {
+	devices, err := m.store.DeviceList(nil)
+	if err != nil {
+		logger.Warnf("devices: Failed to list devices, %v", err)
+		return
+	}
+
+	online := 0
+	for i := range devices {
+		state := m.stateOf(&devices[i], now)
+		if state == deviceOnline {
+			online++
+		}
+		if m.observe(devices[i].Serial, state) {
+			m.transition(devices[i].Serial, state)
+		}
+	}
+	stats.Set("DevicesOnline", int64(online))
+	stats.Set("DevicesOffline", int64(len(devices)-online))
+}
+
+// run sweeps devices periodically until stop is closed.
+func (m *deviceMonitor) run(stop <-chan bool) {
+	ticker := time.NewTicker(m.timeout / 2)
+	defer ticker.Stop()
+
+	m.sweep(types.TimeNow())
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep(types.TimeNow())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Server) newDeviceResp(d *types.Device, now time.Time) deviceResp {
+	return deviceResp{Device: d, State: s.devices.stateOf(d, now)}
+}
+
+// devicesHandler serves `v0/devices` collection, `v0/devices/{serial}` items and
+// `v0/devices/{serial}/heartbeat`.
+func (s *Server) devicesHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+
+	rest := strings.TrimPrefix(req.URL.Path, s.cfg.APIPath+"v0/devices")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		switch req.Method {
+		case http.MethodGet:
+			s.deviceList(wrt, req, now)
+		case http.MethodPost:
+			s.deviceCreate(wrt, req, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 1:
+		switch req.Method {
+		case http.MethodGet:
+			s.deviceGet(wrt, parts[0], now)
+		case http.MethodPut, http.MethodPatch:
+			s.deviceUpdate(wrt, req, parts[0], now)
+		case http.MethodDelete:
+			s.deviceDelete(wrt, req, parts[0], now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 2 && parts[1] == "heartbeat":
+		if req.Method != http.MethodPost {
+			writeResp(wrt, ErrOperationNotAllowed(now))
+			return
+		}
+		s.deviceHeartbeat(wrt, parts[0], now)
+
+	default:
+		writeResp(wrt, ErrNotFound(now))
+	}
+}
+
+// deviceListSpec filters devices by assignment and model, deviceList adds `state`.
+var deviceListSpec = &listSpec{
+	filters: map[string]string{"room": "room_id", "stream": "stream_id", "model": "model"},
+	sorts:   []string{"id", "model", "created_at", "updated_at"},
+	window:  "created_at",
+}
+
+func (s *Server) deviceList(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	q, err := deviceListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if state := req.URL.Query().Get("state"); state != "" {
+		r, ok := s.devices.stateRange(state, now)
+		if !ok {
+			writeResp(wrt, errMalformed(validationError{{Field: "state", Reason: "must be online or offline"}}, now))
+			return
+		}
+		q.Ranges = append(q.Ranges, r)
+	}
+	devices, err := s.store.DeviceList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(devices), func(i int) types.Listed { return &devices[i] })
+	resp := make([]deviceResp, 0, n)
+	for i := range devices[:n] {
+		resp = append(resp, s.newDeviceResp(&devices[i], now))
+	}
+	writeResp(wrt, listResp(now, resp, next))
+}
+
+// assignDevice validates the room and stream a device is assigned to. The room
+// is implied by the stream if only the stream is given.
+func (s *Server) assignDevice(d *types.Device, roomID, streamID string) error {
+	d.RoomID, d.StreamID = roomID, streamID
+	if streamID == "" {
+		if roomID == "" {
+			return nil
+		}
+		_, err := s.store.RoomGet(roomID)
+		if err == types.ErrNotFound {
+			return types.ErrMalformed
+		}
+		return err
+	}
+	st, err := s.store.StreamGet(streamID)
+	if err == types.ErrNotFound {
+		return types.ErrMalformed
+	}
+	if err != nil {
+		return err
+	}
+	if roomID != "" && roomID != st.RoomID {
+		return types.ErrMalformed
+	}
+	d.RoomID = st.RoomID
+	return nil
+}
+
+func (s *Server) deviceCreate(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	var body deviceReq
+	if err := decodeBody(req, deviceCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+
+	d := &types.Device{Serial: body.Serial, Model: body.Model, CreatedAt: now, UpdatedAt: now}
+	if err := s.assignDevice(d, body.RoomID, body.StreamID); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if err := s.store.DeviceCreate(d, s.auditEntry(req, types.AuditCreate, auditDevice, d.Serial, nil, d, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("devices: Device '%s' registered", d.Serial)
+	writeResp(wrt, NoErrCreated(now, s.newDeviceResp(d, now)))
+}
+
+func (s *Server) deviceGet(wrt http.ResponseWriter, serial string, now time.Time) {
+	d, err := s.store.DeviceGet(serial)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, s.newDeviceResp(d, now)))
+}
+
+func (s *Server) deviceUpdate(wrt http.ResponseWriter, req *http.Request, serial string, now time.Time) {
+	var body deviceUpdateReq
+	if err := decodeBody(req, deviceUpdateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.Serial != "" && body.Serial != serial {
+		writeResp(wrt, ErrMalformed(now).WithParam("errors", []fieldError{{Field: "serial", Reason: "must be the serial of the device"}}))
+		return
+	}
+	d, err := s.store.DeviceGet(serial)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	before := *d
+	if body.Model != "" {
+		d.Model = body.Model
+	}
+	if body.RoomID != nil || body.StreamID != nil {
+		roomID, streamID := d.RoomID, d.StreamID
+		if body.RoomID != nil {
+			// the stream stays behind if the device moves to another room.
+			if *body.RoomID != roomID {
+				streamID = ""
+			}
+			roomID = *body.RoomID
+		}
+		if body.StreamID != nil {
+			streamID = *body.StreamID
+		}
+		if err := s.assignDevice(d, roomID, streamID); err != nil {
+			writeResp(wrt, decodeStoreError(err, now))
+			return
+		}
+	}
+	d.UpdatedAt = now
+	if err := s.store.DeviceUpdate(d, s.auditEntry(req, types.AuditUpdate, auditDevice, serial, &before, d, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, s.newDeviceResp(d, now)))
+}
+
+func (s *Server) deviceDelete(wrt http.ResponseWriter, req *http.Request, serial string, now time.Time) {
+	d, err := s.store.DeviceGet(serial)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if err := s.store.DeviceDelete(serial, s.auditEntry(req, types.AuditDelete, auditDevice, serial, d, nil, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	s.devices.forget(serial)
+	logger.Infof("devices: Device '%s' deleted", serial)
+	writeResp(wrt, NoErr(now, nil))
+}
+
+// deviceHeartbeat marks the device as seen now.
+func (s *Server) deviceHeartbeat(wrt http.ResponseWriter, serial string, now time.Time) {
+	if err := s.store.DeviceTouch(serial, now); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	stats.Inc("DeviceHeartbeats", 1)
+	if s.devices.observe(serial, deviceOnline) {
+		s.devices.transition(serial, deviceOnline)
+	}
+	writeResp(wrt, NoErr(now, map[string]string{"state": deviceOnline}))
+}
@@ -0,0 +1,80 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDevicesAPI(t *testing.T) {
+	s, mux := newTestServer(t)
+
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01"})
+	var room roomResp
+	if err := json.Unmarshal(data, &room); err != nil || code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+	camID := room.Streams[1].ID
+
+	code, data = doRequest(t, mux, http.MethodPost, "/api/v0/devices", map[string]string{"serial": "SN001", "model": "cam-x", "stream_id": camID})
+	var dev deviceResp
+	if err := json.Unmarshal(data, &dev); err != nil || code != http.StatusCreated {
+		t.Fatalf("create device: got %d, %s", code, data)
+	}
+	if dev.RoomID != "room01" || dev.State != deviceOffline {
+		t.Errorf("create device: got %s", data)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices", map[string]string{"serial": "SN002", "room_id": "room02", "stream_id": camID}); code != http.StatusBadRequest {
+		t.Errorf("create device with mismatching room: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices", map[string]string{"serial": "SN002", "room_id": "room02"}); code != http.StatusBadRequest {
+		t.Errorf("create device in unknown room: got %d", code)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices/SN001/heartbeat", nil); code != http.StatusOK {
+		t.Fatalf("heartbeat: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices/SN404/heartbeat", nil); code != http.StatusNotFound {
+		t.Errorf("heartbeat of unknown device: got %d", code)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/devices/SN001", nil)
+	if err := json.Unmarshal(data, &dev); err != nil || code != http.StatusOK {
+		t.Fatalf("get device: got %d, %s", code, data)
+	}
+	if dev.State != deviceOnline || dev.LastSeen == nil {
+		t.Errorf("device is not online after heartbeat: %s", data)
+	}
+
+	// the device goes offline once the heartbeat is older than the timeout.
+	later := dev.LastSeen.Add(2 * time.Minute)
+	if state := s.devices.stateOf(dev.Device, later); state != deviceOffline {
+		t.Errorf("state after timeout: got %s", state)
+	}
+	s.devices.sweep(later)
+	if s.devices.observe("SN001", deviceOffline) {
+		t.Error("sweep did not record offline transition")
+	}
+
+	// the assignment is kept unless it's in the update.
+	code, data = doRequest(t, mux, http.MethodPatch, "/api/v0/devices/SN001", map[string]string{"model": "cam-y"})
+	if err := json.Unmarshal(data, &dev); err != nil || code != http.StatusOK {
+		t.Fatalf("update model: got %d, %s", code, data)
+	}
+	if dev.Model != "cam-y" || dev.RoomID != "room01" || dev.StreamID != camID {
+		t.Errorf("update model: got %s", data)
+	}
+	code, data = doRequest(t, mux, http.MethodPatch, "/api/v0/devices/SN001", map[string]string{"room_id": ""})
+	dev = deviceResp{}
+	if err := json.Unmarshal(data, &dev); err != nil || code != http.StatusOK {
+		t.Fatalf("unassign device: got %d, %s", code, data)
+	}
+	if dev.Model != "cam-y" || dev.RoomID != "" || dev.StreamID != "" {
+		t.Errorf("unassign device: got %s", data)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodDelete, "/api/v0/devices/SN001", nil); code != http.StatusOK {
+		t.Errorf("delete device: got %d", code)
+	}
+}
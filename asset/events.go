@@ -0,0 +1,193 @@
+package asset
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// Types of lifecycle events.
+const (
+	eventStreamPublished   = "stream.published"
+	eventStreamUnpublished = "stream.unpublished"
+	eventRecordingFinished = "recording.finished"
+	eventDeviceOnline      = "device.online"
+	eventDeviceOffline     = "device.offline"
+	eventRoomLive          = "room.live"
+	eventRoomOffline       = "room.offline"
+)
+
+// eventTypes lists all event types clients may subscribe to.
+var eventTypes = map[string]bool{
+	eventStreamPublished:   true,
+	eventStreamUnpublished: true,
+	eventRecordingFinished: true,
+	eventDeviceOnline:      true,
+	eventDeviceOffline:     true,
+	eventRoomLive:          true,
+	eventRoomOffline:       true,
+}
+
+// event is something which happened to a stream or a device.
+type event struct {
+	ID string `json:"id"`
+	// Epoch identifies the run of the server, Seq orders events of the run. Together
+	// they are the event ID of the feed, see feedID.
+	Epoch string      `json:"epoch"`
+	Seq   uint64      `json:"seq"`
+	Type  string      `json:"type"`
+	Time  time.Time   `json:"ts"`
+	Data  interface{} `json:"data"`
+	// room is the room the event belongs to, if any.
+	room string
+}
+
+// streamEvent is the data of stream events.
+type streamEvent struct {
+	Key      string           `json:"key"`
+	RoomID   string           `json:"room_id,omitempty"`
+	StreamID string           `json:"stream_id,omitempty"`
+	Type     types.StreamType `json:"type,omitempty"`
+	// Since is when the stream started publishing.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// roomEvent is the data of room events.
+type roomEvent struct {
+	RoomID string `json:"room_id"`
+	Live   bool   `json:"live"`
+}
+
+// recordingEvent is the data of recording events.
+type recordingEvent struct {
+	RoomID string `json:"room_id"`
+	recordingResp
+}
+
+// eventBus fans events out to subscribers. Subscribers are called in order of
+// subscription on the goroutine of the publisher and must not block for long, e.g.
+// webhooks only store deliveries of the event. Concurrent
+// publishers call them concurrently, so a subscriber may see events slightly out
+// of order of their sequence numbers.
+type eventBus struct {
+	// epoch tells events of this run from those before a restart, as seq starts over.
+	epoch string
+	mu    sync.Mutex
+	seq   uint64
+	subs  []func(ev *event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{epoch: types.NewID()}
+}
+
+// subscribe registers fn to be called with every published event.
+func (b *eventBus) subscribe(fn func(ev *event)) {
+	b.mu.Lock()
+	b.subs = append(b.subs, fn)
+	b.mu.Unlock()
+}
+
+// publish creates an event and passes it to subscribers.
+func (b *eventBus) publish(typ string, data interface{}) {
+	if b == nil {
+		return
+	}
+	ev := &event{ID: types.NewID(), Epoch: b.epoch, Type: typ, Time: types.TimeNow(), Data: data, room: eventRoom(data)}
+
+	// subscribers are called without the lock, so a slow one doesn't hold up other publishers.
+	b.mu.Lock()
+	b.seq++
+	ev.Seq = b.seq
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// eventRoom returns the room of event data.
+func eventRoom(data interface{}) string {
+	switch d := data.(type) {
+	case streamEvent:
+		return d.RoomID
+	case roomEvent:
+		return d.RoomID
+	case recordingEvent:
+		return d.RoomID
+	case deviceResp:
+		if d.Device != nil {
+			return d.RoomID
+		}
+	}
+	return ""
+}
+
+// streamChanged publishes events of a stream which started or stopped publishing.
+func (s *Server) streamChanged(key string, online bool, since *time.Time) {
+	data := streamEvent{Key: key, Since: since}
+	st, err := s.streamByKey(key)
+	if err == nil {
+		data.RoomID, data.StreamID, data.Type = st.RoomID, st.ID, st.Type
+	}
+
+	if online {
+		s.events.publish(eventStreamPublished, data)
+	} else {
+		s.events.publish(eventStreamUnpublished, data)
+	}
+	if st != nil {
+		s.roomChanged(st.RoomID, key, online)
+	}
+	if online {
+		return
+	}
+
+	// recording of the session is complete once the stream stops.
+	if st == nil || since == nil || s.cfg.Hub.HLSPath == "" {
+		return
+	}
+	segments, err := s.findSegments(key, timeWindow{From: *since})
+	if err != nil || len(segments) == 0 {
+		return
+	}
+	s.events.publish(eventRecordingFinished, recordingEvent{RoomID: st.RoomID, recordingResp: newRecordingResp(st, segments)})
+}
+
+// roomChanged publishes room events when the first stream of a room starts
+// publishing or the last one stops.
+func (s *Server) roomChanged(roomID, key string, online bool) {
+	streams, err := s.store.StreamList(types.Where("room_id", roomID))
+	if err != nil {
+		return
+	}
+	for i := range streams {
+		if streams[i].Key == key {
+			continue
+		}
+		if ls := s.live.status(streams[i].Key); ls != nil && ls.Online {
+			// other streams keep the room live.
+			return
+		}
+	}
+	if online {
+		s.events.publish(eventRoomLive, roomEvent{RoomID: roomID, Live: true})
+	} else {
+		s.events.publish(eventRoomOffline, roomEvent{RoomID: roomID})
+	}
+}
+
+// deviceChanged publishes events of a device which came online or went offline.
+func (s *Server) deviceChanged(serial, state string) {
+	d, err := s.store.DeviceGet(serial)
+	if err != nil {
+		d = &types.Device{Serial: serial}
+	}
+	typ := eventDeviceOffline
+	if state == deviceOnline {
+		typ = eventDeviceOnline
+	}
+	s.events.publish(typ, deviceResp{Device: d, State: state})
+}
@@ -0,0 +1,351 @@
+package asset
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// feedBacklog is how many recent events are kept for clients resuming the feed.
+	feedBacklog = 1024
+	// feedClientBuffer is how many events may wait for a slow client before it is dropped.
+	feedClientBuffer = 64
+	// feedKeepAlive is how often idle connections are pinged.
+	feedKeepAlive = 15 * time.Second
+	// feedWriteTimeout limits writing a single WebSocket message.
+	feedWriteTimeout = 10 * time.Second
+	// feedRetry is the reconnection delay suggested to SSE clients, in milliseconds.
+	feedRetry = 3000
+
+	// eventFeedReset tells the client events were missed and state must be read again.
+	eventFeedReset = "feed.reset"
+)
+
+// feedClient is a connection following the feed.
+type feedClient struct {
+	// rooms limits events to the given rooms, all events if empty.
+	rooms map[string]bool
+	ch    chan *event
+	// gone is closed when the client is dropped.
+	gone chan struct{}
+}
+
+func (c *feedClient) wants(ev *event) bool {
+	return len(c.rooms) == 0 || c.rooms[ev.room]
+}
+
+// eventFeed keeps recent events and passes new ones to connected clients.
+type eventFeed struct {
+	// epoch of events of this run, clients resuming from another run are reset.
+	epoch   string
+	mu      sync.Mutex
+	backlog []*event
+	clients map[*feedClient]bool
+	closed  bool
+}
+
+func newEventFeed(epoch string) *eventFeed {
+	return &eventFeed{epoch: epoch, clients: make(map[*feedClient]bool)}
+}
+
+// feedID is the event ID of the feed, `{epoch}-{seq}`.
+func feedID(ev *event) string {
+	return ev.Epoch + "-" + strconv.FormatUint(ev.Seq, 10)
+}
+
+// parseFeedID splits an event ID of the feed into its epoch and sequence number.
+func parseFeedID(id string) (string, uint64, error) {
+	i := strings.LastIndexByte(id, '-')
+	if i < 0 {
+		return "", 0, types.ErrMalformed
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, types.ErrMalformed
+	}
+	return id[:i], seq, nil
+}
+
+// publish records the event and passes it to interested clients. Clients which
+// can't keep up are dropped, they may resume from the last event they have seen.
+func (f *eventFeed) publish(ev *event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.backlog) == feedBacklog {
+		copy(f.backlog, f.backlog[1:])
+		f.backlog = f.backlog[:len(f.backlog)-1]
+	}
+	// concurrent publishers may pass events out of order, the backlog is kept in order.
+	i := len(f.backlog)
+	for i > 0 && f.backlog[i-1].Seq > ev.Seq {
+		i--
+	}
+	f.backlog = append(f.backlog, nil)
+	copy(f.backlog[i+1:], f.backlog[i:])
+	f.backlog[i] = ev
+
+	for c := range f.clients {
+		if !c.wants(ev) {
+			continue
+		}
+		select {
+		case c.ch <- ev:
+		default:
+			f.drop(c)
+		}
+	}
+}
+
+// join adds a client, which receives every event after lastSeq of the epoch. If
+// events after lastSeq are no longer kept, or were sent before a restart, the
+// client first gets a reset event.
+func (f *eventFeed) join(rooms map[string]bool, epoch string, lastSeq uint64, resume bool) (*feedClient, []*event) {
+	c := &feedClient{rooms: rooms, ch: make(chan *event, feedClientBuffer), gone: make(chan struct{})}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(c.gone)
+		return c, nil
+	}
+	f.clients[c] = true
+
+	if !resume {
+		return c, nil
+	}
+	var latest uint64
+	if n := len(f.backlog); n > 0 {
+		latest = f.backlog[n-1].Seq
+	}
+	if epoch != f.epoch || lastSeq > latest || (len(f.backlog) > 0 && lastSeq+1 < f.backlog[0].Seq) {
+		return c, []*event{{Epoch: f.epoch, Seq: latest, Type: eventFeedReset, Time: types.TimeNow()}}
+	}
+	var missed []*event
+	for _, ev := range f.backlog {
+		if ev.Seq > lastSeq && c.wants(ev) {
+			missed = append(missed, ev)
+		}
+	}
+	return c, missed
+}
+
+// leave removes the client.
+func (f *eventFeed) leave(c *feedClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.clients[c] {
+		f.drop(c)
+	}
+}
+
+// drop disconnects the client, must be called with the lock held.
+func (f *eventFeed) drop(c *feedClient) {
+	delete(f.clients, c)
+	close(c.gone)
+}
+
+// close disconnects all clients and refuses new ones.
+func (f *eventFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for c := range f.clients {
+		f.drop(c)
+	}
+}
+
+// next waits for the next event of the client. It returns nil on keep-alive timeout
+// and false once the client is dropped or done is closed.
+func (c *feedClient) next(timeout <-chan time.Time, done <-chan struct{}) (*event, bool) {
+	select {
+	case ev := <-c.ch:
+		return ev, true
+	case <-timeout:
+		return nil, true
+	case <-done:
+		return nil, false
+	case <-c.gone:
+		// deliver what was queued before the client was dropped.
+		select {
+		case ev := <-c.ch:
+			return ev, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+var feedUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// eventsHandler serves `v0/events`, the feed of lifecycle events, as WebSocket if
+// the client asks for an upgrade and as Server-Sent Events otherwise. `?room=` limits
+// events to the given rooms. Clients resume with `Last-Event-ID` header or
+// `?last_event_id=`, the `{epoch}-{seq}` of the last event seen. Browsers, which
+// can't set headers, authenticate with a bearer token in `?access_token=`.
+func (s *Server) eventsHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	if req.Method != http.MethodGet {
+		writeResp(wrt, ErrOperationNotAllowed(now))
+		return
+	}
+
+	q := req.URL.Query()
+	rooms := make(map[string]bool)
+	for _, v := range q["room"] {
+		for _, id := range strings.Split(v, ",") {
+			if id != "" {
+				rooms[id] = true
+			}
+		}
+	}
+
+	var (
+		epoch   string
+		lastSeq uint64
+		resume  bool
+	)
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("last_event_id")
+	}
+	if last != "" {
+		var err error
+		if epoch, lastSeq, err = parseFeedID(last); err != nil {
+			writeResp(wrt, ErrMalformed(now).WithParam("last_event_id", last))
+			return
+		}
+		resume = true
+	}
+
+	if websocket.IsWebSocketUpgrade(req) {
+		s.serveWebSocket(wrt, req, rooms, epoch, lastSeq, resume)
+		return
+	}
+	s.serveSSE(wrt, req, rooms, epoch, lastSeq, resume)
+}
+
+// serveSSE streams events as `text/event-stream`.
+func (s *Server) serveSSE(wrt http.ResponseWriter, req *http.Request, rooms map[string]bool, epoch string, lastSeq uint64, resume bool) {
+	flusher, ok := wrt.(http.Flusher)
+	if !ok {
+		writeResp(wrt, ErrInternal(types.TimeNow()))
+		return
+	}
+
+	c, missed := s.feed.join(rooms, epoch, lastSeq, resume)
+	defer s.feed.leave(c)
+
+	h := wrt.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// keep reverse proxies from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	wrt.WriteHeader(http.StatusOK)
+	fmt.Fprintf(wrt, "retry: %d\n\n", feedRetry)
+
+	write := func(ev *event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(wrt, "id: %s\nevent: %s\ndata: %s\n\n", feedID(ev), ev.Type, data)
+		return err
+	}
+	for _, ev := range missed {
+		if err := write(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(feedKeepAlive)
+	defer ticker.Stop()
+	for {
+		ev, ok := c.next(ticker.C, req.Context().Done())
+		if !ok {
+			return
+		}
+		var err error
+		if ev == nil {
+			_, err = fmt.Fprint(wrt, ": ping\n\n")
+		} else {
+			err = write(ev)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWebSocket streams events as JSON text messages. Messages from the client are
+// ignored.
+func (s *Server) serveWebSocket(wrt http.ResponseWriter, req *http.Request, rooms map[string]bool, epoch string, lastSeq uint64, resume bool) {
+	upgrader := feedUpgrader
+	upgrader.CheckOrigin = s.checkOrigin
+	conn, err := upgrader.Upgrade(wrt, req, nil)
+	if err != nil {
+		// the upgrader has already replied.
+		logger.Warnf("events: WebSocket upgrade from %s failed, %v", req.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	c, missed := s.feed.join(rooms, epoch, lastSeq, resume)
+	defer s.feed.leave(c)
+
+	// read until the client goes away, so close and ping frames are handled.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(ev *event) error {
+		conn.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		return conn.WriteJSON(ev)
+	}
+	for _, ev := range missed {
+		if err := write(ev); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(feedKeepAlive)
+	defer ticker.Stop()
+	for {
+		ev, ok := c.next(ticker.C, closed)
+		if !ok {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(feedWriteTimeout))
+			return
+		}
+		if ev == nil {
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(feedWriteTimeout))
+		} else {
+			err = write(ev)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package asset
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readSSE reads the next event from a Server-Sent Events stream.
+func readSSE(t *testing.T, r *bufio.Reader) (id, typ string, ev event) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && typ != "":
+			return
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatalf("event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestEventFeed(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.events = newEventBus()
+	s.feed = newEventFeed(s.events.epoch)
+	s.events.subscribe(s.feed.publish)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer s.feed.close()
+
+	s.events.publish(eventRoomLive, roomEvent{RoomID: "room01", Live: true})
+	s.events.publish(eventRoomLive, roomEvent{RoomID: "room02", Live: true})
+
+	// resume after the first event, filtered to room02.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v0/events?room=room02", nil)
+	req.Header.Set("Last-Event-ID", s.events.epoch+"-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("SSE: got %d, %s", resp.StatusCode, ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if id, typ, ev := readSSE(t, r); id != s.events.epoch+"-2" || typ != eventRoomLive || ev.Seq != 2 {
+		t.Errorf("missed event: got id %s, %s, %+v", id, typ, ev)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v0/events?last_event_id="+s.events.epoch+"-99", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev event
+	if err := ws.ReadJSON(&ev); err != nil || ev.Type != eventFeedReset || ev.Seq != 2 {
+		t.Errorf("stale resume: got %+v, %v", ev, err)
+	}
+
+	s.events.publish(eventRoomOffline, roomEvent{RoomID: "room01"})
+	s.events.publish(eventRoomOffline, roomEvent{RoomID: "room02"})
+
+	if id, typ, _ := readSSE(t, r); id != s.events.epoch+"-4" || typ != eventRoomOffline {
+		t.Errorf("filtered event: got id %s, %s", id, typ)
+	}
+	for _, want := range []uint64{3, 4} {
+		if err := ws.ReadJSON(&ev); err != nil || ev.Seq != want {
+			t.Errorf("WebSocket event %d: got %+v, %v", want, ev, err)
+		}
+	}
+
+	// IDs of a previous run are stale even if the sequence number is still to come.
+	ws2, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v0/events?last_event_id=0123456789abcdef-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws2.Close()
+	ws2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := ws2.ReadJSON(&ev); err != nil || ev.Type != eventFeedReset || ev.Epoch != s.events.epoch || ev.Seq != 4 {
+		t.Errorf("resume after restart: got %+v, %v", ev, err)
+	}
+
+	code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/events?last_event_id=x", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("malformed event ID: got %d", code)
+	}
+}
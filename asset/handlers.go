@@ -2,23 +2,60 @@ package asset
 
 import (
 	"encoding/json"
-	"io"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
 )
 
+// maxBodySize limits the size of JSON request bodies.
+const maxBodySize = 1 << 20 // 1M
+
+// idPattern restricts user-assigned identifiers which become part of URLs and stream names.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 func index(wrt http.ResponseWriter, req *http.Request) {
-	now := time.Now().UTC().Round(time.Millisecond)
+	now := types.TimeNow()
 	if req.Method != http.MethodGet {
-		wrt.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(wrt).Encode(ErrOperationNotAllowed(now))
 		logger.Warnf("index: Invalid HTTP method %s", req.Method)
+		writeResp(wrt, ErrOperationNotAllowed(now).WithParam("method", req.Method))
 		return
 	}
+	writeResp(wrt, NoErr(now, map[string]string{"message": "hello"}))
+}
+
+// notFound replies to requests of unknown API paths.
+func notFound(wrt http.ResponseWriter, req *http.Request) {
+	writeResp(wrt, ErrNotFound(types.TimeNow()).WithParam("path", req.URL.Path))
+}
 
+// writeResp writes a response envelope using its control code as HTTP status. All
+// API replies go through it so clients can parse them the same way.
+func writeResp(wrt http.ResponseWriter, resp *ServerResp) {
 	wrt.Header().Set("X-Content-Type-Options", "nosniff")
-	wrt.Header().Set("Content-Type", "text/json; charset=utf-8")
-	io.WriteString(wrt, `{"message": "hello"}`)
+	wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+	wrt.WriteHeader(resp.Ctrl.Code)
+	if err := json.NewEncoder(wrt).Encode(resp); err != nil {
+		logger.Warnf("http: Failed to write response, %v", err)
+	}
+}
+
+// decodeStoreError converts a storage error into a response envelope.
+func decodeStoreError(err error, ts time.Time) *ServerResp {
+	switch err {
+	case types.ErrNotFound:
+		return ErrNotFound(ts)
+	case types.ErrDuplicate:
+		return ErrAlreadyExists(ts)
+	case types.ErrMalformed:
+		return ErrMalformed(ts)
+	case types.ErrNotOpen, types.ErrUnavailable:
+		logger.Warnf("storage: %v", err)
+		return ErrStorageUnavailable(ts)
+	default:
+		logger.Warnf("storage: %v", err)
+		return ErrInternal(ts)
+	}
 }
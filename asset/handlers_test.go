@@ -0,0 +1,28 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	s, mux := newTestServer(t)
+
+	code, data := doRequest(t, mux, http.MethodGet, "/api/v0/index", nil)
+	var hello map[string]string
+	if err := json.Unmarshal(data, &hello); err != nil || code != http.StatusOK || hello["message"] != "hello" {
+		t.Errorf("index: got %d, %s", code, data)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/index", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("post to index: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/nothing", nil); code != http.StatusNotFound {
+		t.Errorf("unknown path: got %d", code)
+	}
+
+	s.store.Close()
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/rooms", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("closed store: got %d", code)
+	}
+}
@@ -1,65 +1,251 @@
 package asset
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
 )
 
-func listenAndServe(addr string, mux *http.ServeMux, stop <-chan bool) error {
-	shuttingDown := false
+const (
+	// unixPrefix marks listen addresses of unix sockets.
+	unixPrefix = "unix:"
+
+	// requestIDHeader carries the ID of a request, taken from the caller if given.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLen limits request IDs given by callers.
+	maxRequestIDLen = 64
+)
+
+// httpLatencyBounds are buckets of the request latency histogram, in seconds.
+var httpLatencyBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 
-	httpdone := make(chan bool)
+// listener is an HTTP server on a TCP address or a unix socket.
+type listener struct {
+	addr    string
+	handler http.Handler
+	// tls is set to serve HTTPS.
+	tls *tls.Config
+}
 
-	server := &http.Server{
-		Handler: mux,
+// netListen listens on a TCP address, or on a unix socket if the address starts
+// with `unix:`. A socket left over by a previous run is replaced.
+func netListen(addr string) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if path == addr {
+		return net.Listen("tcp", addr)
 	}
 
-	go func() {
-		var err error
-		listenOn, err := net.Listen("tcp", addr)
-		if err == nil {
-			err = server.Serve(listenOn)
-		}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// local tools reach the socket through group membership.
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
 
+// listenAndServe serves all listeners until either a stop signal is received or
+// one of them fails.
+func listenAndServe(listeners []*listener, stop <-chan bool) error {
+	// bind all addresses first, so that a bad one fails the start.
+	netListeners := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := netListen(l.addr)
 		if err != nil {
-			if shuttingDown {
-				logger.Infof("HTTP server: stopped")
-			} else {
-				logger.Infof("HTTP server: failed", err)
+			for _, ln := range netListeners {
+				ln.Close()
 			}
+			return fmt.Errorf("fail to listen on '%s', %v", l.addr, err)
 		}
-		httpdone <- true
-	}()
+		netListeners = append(netListeners, ln)
+	}
+
+	shuttingDown := false
+
+	httpdone := make(chan error, len(listeners))
+
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		server := &http.Server{
+			Handler:   l.handler,
+			TLSConfig: l.tls,
+		}
+		servers[i] = server
+
+		go func(ln net.Listener, addr string) {
+			var err error
+			if server.TLSConfig != nil {
+				// certificates come from TLSConfig.
+				logger.Infof("HTTP server: serving HTTPS on '%s'", addr)
+				err = server.ServeTLS(ln, "", "")
+			} else {
+				logger.Infof("HTTP server: serving HTTP on '%s'", addr)
+				err = server.Serve(ln)
+			}
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			httpdone <- err
+		}(netListeners[i], l.addr)
+	}
 
 	// wait for either a termination signal or an error.
-Loop:
-	for {
-		select {
-		case <-stop:
-			// flip the flat that we are terminating and close the Accept-ing socket, so no new connections are possible.
-			shuttingDown = true
-			// give server 2 seconds to shut down.
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	var err error
+	pending := len(servers)
+	select {
+	case <-stop:
+		// flip the flat that we are terminating and close the Accept-ing sockets, so no new connections are possible.
+		shuttingDown = true
+		// give servers 2 seconds to shut down.
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		for _, server := range servers {
 			if err := server.Shutdown(ctx); err != nil {
 				logger.Warnf("HTTP server failed to terminate gracefully, %v", err)
 			}
+		}
+		cancel()
 
-			// wait for http server to stop Accept-ing connections.
-			<-httpdone
-			cancel()
+	case err = <-httpdone:
+		pending--
+		logger.Infof("HTTP server: failed, %v", err)
+		for _, server := range servers {
+			server.Close()
+		}
+	}
 
-			// Stop publishing statistics.
-			statsShutdown()
-			break Loop
+	// wait for http servers to stop Accept-ing connections.
+	for ; pending > 0; pending-- {
+		<-httpdone
+	}
+	if shuttingDown {
+		logger.Infof("HTTP server: stopped")
+	}
 
-		case <-httpdone:
-			break Loop
-		}
+	// Stop publishing statistics.
+	stats.Shutdown()
+
+	return err
+}
+
+// responseRecorder keeps the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Flush lets the event feed stream through the recorder.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
+}
+
+// Hijack lets the event feed upgrade to WebSocket through the recorder.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http: connection can't be hijacked")
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// registerHTTPStats registers per-route request stats.
+func registerHTTPStats() {
+	stats.RegisterCounterVec("HTTPRequests", "HTTP requests by route, method and status class.", "route", "method", "code")
+	stats.RegisterHistogramVec("HTTPRequestDuration", "HTTP request latency by route, in seconds.", httpLatencyBounds, "route")
+}
+
+type requestIDKey struct{}
 
-	return nil
+// requestIDFrom returns the ID instrument gave the request, empty if not instrumented.
+func requestIDFrom(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// instrument counts requests and their latency per route of the mux, and writes
+// an access log line for each request. Routes are mux patterns, so that metrics
+// don't grow with IDs in paths.
+func instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = types.NewID()
+		}
+		wrt.Header().Set(requestIDHeader, id)
+		req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+
+		rec := &responseRecorder{ResponseWriter: wrt}
+		next.ServeHTTP(rec, req)
+
+		elapsed := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		_, route := mux.Handler(req)
+		if route == "" {
+			route = "other"
+		}
+
+		stats.IncVec("HTTPRequests", 1, route, req.Method, strconv.Itoa(rec.status/100)+"xx")
+		stats.AddHistSampleVec("HTTPRequestDuration", elapsed.Seconds(), route)
+
+		logger.Infof("access: id=%s method=%s path=%q route=%s status=%d bytes=%d duration=%.3fms remote=%s",
+			id, req.Method, req.URL.Path, route, rec.status, rec.size,
+			float64(elapsed)/float64(time.Millisecond), req.RemoteAddr)
+	})
+}
+
+// validRequestID accepts request IDs of printable ASCII without spaces or quotes,
+// so that they can't break access log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c > '~' || c == '"' {
+			return false
+		}
+	}
+	return true
 }
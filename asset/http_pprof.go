@@ -2,16 +2,61 @@ package asset
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	httppprof "net/http/pprof"
+	"os"
 	"path"
+	"path/filepath"
+	"runtime"
 	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dantin/logger"
 )
 
+const (
+	// maxProfileSeconds limits on-demand CPU profiles and execution traces.
+	maxProfileSeconds = 300
+
+	// defaultSnapshotKeep is how many snapshots are kept unless configured.
+	defaultSnapshotKeep = 24
+	// snapshotLayout names snapshot directories, they sort by time.
+	snapshotLayout = "20060102T150405Z"
+)
+
 var pprofHTTPRoot string
 
+// cpuProfiler tells who profiles CPU, Go runs one CPU profile at a time. The
+// `-pprof` flag takes it for the whole run, so neither on-demand CPU profiles nor
+// snapshots get it then.
+var cpuProfiler struct {
+	sync.Mutex
+	owner string
+}
+
+// acquireCPUProfiler takes the CPU profiler for the owner, or returns who has it.
+func acquireCPUProfiler(owner string) (string, bool) {
+	cpuProfiler.Lock()
+	defer cpuProfiler.Unlock()
+
+	if cpuProfiler.owner != "" {
+		return cpuProfiler.owner, false
+	}
+	cpuProfiler.owner = owner
+	return "", true
+}
+
+func releaseCPUProfiler() {
+	cpuProfiler.Lock()
+	cpuProfiler.owner = ""
+	cpuProfiler.Unlock()
+}
+
 // Expose debug profiling at the given URL path.
 func servePprof(mux *http.ServeMux, serveAt string) {
 	if serveAt == "" || serveAt == "-" {
@@ -24,20 +69,92 @@ func servePprof(mux *http.ServeMux, serveAt string) {
 	logger.Infof("pprof: Profiling info expose at '%s'", pprofHTTPRoot)
 }
 
+// profileHandler serves profiles the way `go tool pprof` expects, e.g.
+//
+//	go tool pprof http://host/monitor/pprof/profile?seconds=30
+//	go tool pprof http://host/monitor/pprof/heap?debug=0
+//	curl -o trace.out http://host/monitor/pprof/trace?seconds=5
+//
+// Named profiles are written as text unless `debug=0` asks for the binary format.
+// CPU profiles are refused with 409 while someone else profiles CPU, e.g. the
+// `-pprof` flag, which does for the whole run.
 func profileHandler(wrt http.ResponseWriter, req *http.Request) {
 	wrt.Header().Set("X-Content-Type-Options", "nosniff")
-	wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	profileName := strings.TrimPrefix(req.URL.Path, pprofHTTPRoot)
 
+	switch profileName {
+	case "":
+		profileIndex(wrt)
+		return
+	case "profile", "trace":
+		if v := req.FormValue("seconds"); v != "" {
+			sec, err := strconv.ParseFloat(v, 64)
+			if err != nil || sec <= 0 || sec > maxProfileSeconds {
+				servePprofError(wrt, http.StatusBadRequest, fmt.Sprintf("seconds must be within (0, %d]", maxProfileSeconds))
+				return
+			}
+		}
+		if profileName == "profile" {
+			if owner, ok := acquireCPUProfiler("an on-demand profile"); !ok {
+				servePprofError(wrt, http.StatusConflict, "CPU is already profiled by "+owner+", try again later")
+				return
+			}
+			defer releaseCPUProfiler()
+			httppprof.Profile(wrt, req)
+		} else {
+			httppprof.Trace(wrt, req)
+		}
+		return
+	case "symbol":
+		httppprof.Symbol(wrt, req)
+		return
+	case "cmdline":
+		httppprof.Cmdline(wrt, req)
+		return
+	}
+
 	profile := pprof.Lookup(profileName)
 	if profile == nil {
 		servePprofError(wrt, http.StatusNotFound, "Unknown profile '"+profileName+"'")
 		return
 	}
 
+	debug := 2
+	if v := req.FormValue("debug"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			servePprofError(wrt, http.StatusBadRequest, "Invalid debug level '"+v+"'")
+			return
+		}
+		debug = n
+	}
+	if profileName == "heap" && req.FormValue("gc") != "" {
+		runtime.GC()
+	}
+
+	if debug == 0 {
+		wrt.Header().Set("Content-Type", "application/octet-stream")
+		wrt.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, profileName))
+	} else {
+		wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
 	// Respond with the requested profile.
-	profile.WriteTo(wrt, 2)
+	profile.WriteTo(wrt, debug)
+}
+
+// profileIndex lists available profiles.
+func profileIndex(wrt http.ResponseWriter) {
+	wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	fmt.Fprintln(wrt, "profile?seconds=N\tCPU profile, 30 seconds by default")
+	fmt.Fprintln(wrt, "trace?seconds=N\texecution trace, 1 second by default")
+	fmt.Fprintln(wrt, "symbol\tsymbolization for go tool pprof")
+	fmt.Fprintln(wrt, "cmdline\tcommand line of the process")
+	for _, p := range pprof.Profiles() {
+		fmt.Fprintf(wrt, "%s?debug=N\t%d\n", p.Name(), p.Count())
+	}
 }
 
 func servePprofError(wrt http.ResponseWriter, status int, txt string) {
@@ -47,3 +164,144 @@ func servePprofError(wrt http.ResponseWriter, status int, txt string) {
 	wrt.WriteHeader(status)
 	fmt.Fprintln(wrt, txt)
 }
+
+// snapshotConfig configures periodic profile snapshots, which catch intermittent
+// spikes no one was watching. Snapshots are disabled unless Dir and Interval are set.
+type snapshotConfig struct {
+	// Dir keeps a directory of profiles per snapshot, named by the time it was taken.
+	Dir      string        `yaml:"dir"`
+	Interval time.Duration `yaml:"interval"`
+	// CPUDuration is how long CPU is profiled for each snapshot, no CPU profile if zero
+	// or while CPU is profiled otherwise, e.g. by the `-pprof` flag.
+	CPUDuration time.Duration `yaml:"cpu_duration"`
+	// Profiles lists named profiles to save, e.g. `heap` and `goroutine`.
+	Profiles []string `yaml:"profiles"`
+	// Keep is how many of the latest snapshots are kept.
+	Keep int `yaml:"keep"`
+}
+
+func (sc *snapshotConfig) enabled() bool {
+	return sc.Dir != "" && sc.Interval > 0
+}
+
+// profileSnapshotter saves profiles periodically into a rotating directory.
+type profileSnapshotter struct {
+	cfg *snapshotConfig
+}
+
+func newProfileSnapshotter(cfg *snapshotConfig) (*profileSnapshotter, error) {
+	for _, name := range cfg.Profiles {
+		if pprof.Lookup(name) == nil {
+			return nil, fmt.Errorf("pprof: unknown profile '%s'", name)
+		}
+	}
+	if cfg.CPUDuration >= cfg.Interval {
+		return nil, fmt.Errorf("pprof: cpu_duration must be shorter than interval")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &profileSnapshotter{cfg: cfg}, nil
+}
+
+// run takes snapshots until stop is closed.
+func (ps *profileSnapshotter) run(stop <-chan bool) {
+	ticker := time.NewTicker(ps.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.snapshot(time.Now(), stop); err != nil {
+				logger.Warnf("pprof: Failed to take snapshot, %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// snapshot saves profiles taken now and drops the oldest snapshots over the limit.
+func (ps *profileSnapshotter) snapshot(now time.Time, stop <-chan bool) error {
+	dir := filepath.Join(ps.cfg.Dir, now.UTC().Format(snapshotLayout))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range ps.cfg.Profiles {
+		if err := writeProfile(filepath.Join(dir, name+".pb.gz"), func(f *os.File) error {
+			return pprof.Lookup(name).WriteTo(f, 0)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if ps.cfg.CPUDuration > 0 {
+		// other profiles are still useful without the CPU one.
+		if err := ps.profileCPU(filepath.Join(dir, "cpu.pb.gz"), stop); err != nil {
+			logger.Warnf("pprof: Snapshot without CPU profile, %v", err)
+		}
+	}
+
+	return ps.rotate()
+}
+
+// profileCPU saves a CPU profile unless someone else profiles CPU.
+func (ps *profileSnapshotter) profileCPU(name string, stop <-chan bool) error {
+	if owner, ok := acquireCPUProfiler("a snapshot"); !ok {
+		return fmt.Errorf("CPU is profiled by %s", owner)
+	}
+	defer releaseCPUProfiler()
+
+	return writeProfile(name, func(f *os.File) error {
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(ps.cfg.CPUDuration):
+		case <-stop:
+		}
+		pprof.StopCPUProfile()
+		return nil
+	})
+}
+
+func writeProfile(name string, write func(*os.File) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	return f.Close()
+}
+
+// rotate removes the oldest snapshots over the limit.
+func (ps *profileSnapshotter) rotate() error {
+	keep := ps.cfg.Keep
+	if keep <= 0 {
+		keep = defaultSnapshotKeep
+	}
+
+	entries, err := ioutil.ReadDir(ps.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, e := range entries {
+		if _, err := time.Parse(snapshotLayout, e.Name()); err == nil && e.IsDir() {
+			snapshots = append(snapshots, e.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		if err := os.RemoveAll(filepath.Join(ps.cfg.Dir, snapshots[0])); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package asset
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	servePprof(mux, "monitor/pprof")
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	if rec := get("/monitor/pprof/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine?debug=N") {
+		t.Errorf("index: got %d, %s", rec.Code, rec.Body)
+	}
+	if rec := get("/monitor/pprof/goroutine"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine ") {
+		t.Errorf("goroutine: got %d", rec.Code)
+	}
+	if rec := get("/monitor/pprof/heap?debug=0&gc=1"); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("binary heap: got %d, %v", rec.Code, rec.Header())
+	}
+	if rec := get("/monitor/pprof/profile?seconds=1"); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("CPU profile: got %d, %s", rec.Code, rec.Body)
+	}
+	if rec := get("/monitor/pprof/trace?seconds=0.1"); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("trace: got %d, %s", rec.Code, rec.Body)
+	}
+	if rec := get("/monitor/pprof/symbol"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "num_symbols") {
+		t.Errorf("symbol: got %d, %s", rec.Code, rec.Body)
+	}
+	if rec := get("/monitor/pprof/profile?seconds=3600"); rec.Code != http.StatusBadRequest {
+		t.Errorf("long CPU profile: got %d", rec.Code)
+	}
+	if rec := get("/monitor/pprof/nothing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown profile: got %d", rec.Code)
+	}
+
+	// only one CPU profile at a time, e.g. not while -pprof is set.
+	acquireCPUProfiler("the -pprof flag")
+	defer releaseCPUProfiler()
+	if rec := get("/monitor/pprof/profile?seconds=1"); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "-pprof") {
+		t.Errorf("CPU profile while profiled: got %d, %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProfileSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pprof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := newProfileSnapshotter(&snapshotConfig{Dir: dir, Interval: time.Minute, Profiles: []string{"nothing"}}); err == nil {
+		t.Error("unknown profile accepted")
+	}
+	cfg := &snapshotConfig{Dir: dir, Interval: time.Minute, CPUDuration: 10 * time.Millisecond, Profiles: []string{"heap", "goroutine"}, Keep: 2}
+	ps, err := newProfileSnapshotter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not a snapshot, must survive rotation.
+	os.Mkdir(filepath.Join(dir, "other"), 0755)
+
+	start := time.Date(2026, 10, 18, 2, 15, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := ps.snapshot(start.Add(time.Duration(i)*time.Minute), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "20261018T021600Z 20261018T021700Z other" {
+		t.Errorf("rotation: got %v", names)
+	}
+	for _, name := range []string{"heap.pb.gz", "goroutine.pb.gz", "cpu.pb.gz"} {
+		if fi, err := os.Stat(filepath.Join(dir, "20261018T021700Z", name)); err != nil || fi.Size() == 0 {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// the CPU profile is left out while someone else profiles CPU.
+	acquireCPUProfiler("the -pprof flag")
+	defer releaseCPUProfiler()
+	if err := ps.snapshot(start.Add(3*time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "20261018T021800Z", "cpu.pb.gz")); !os.IsNotExist(err) {
+		t.Errorf("CPU profile taken while profiled: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package asset
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	s, mux := newTestServer(t)
+	h := instrument(mux, s.authenticate(mux))
+
+	for header, keep := range map[string]bool{
+		"":          false,
+		"req-42":    true,
+		"has space": false,
+		`quo"te`:    false,
+		"0123456789abcdef-long-0123456789abcdef-0123456789abcdef-0123456789": false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v0/rooms", nil)
+		if header != "" {
+			req.Header.Set(requestIDHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: got status %d", header, rec.Code)
+		}
+		id := rec.Header().Get(requestIDHeader)
+		if keep && id != header || !keep && (id == "" || id == header) {
+			t.Errorf("%q: got request ID %q", header, id)
+		}
+	}
+
+	// the event feed needs to flush through the recorder.
+	var w http.ResponseWriter = &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	if _, ok := w.(http.Flusher); !ok {
+		t.Error("recorder is not a flusher")
+	}
+}
@@ -0,0 +1,206 @@
+package asset
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const (
+	// defaultListLimit and maxListLimit bound the size of list pages unless the list says otherwise.
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listSpec describes how a list endpoint is filtered, sorted and paged. Query
+// parameters are turned into a types.ListQuery, so that the storage backend
+// selects the page.
+type listSpec struct {
+	// filters map query parameters to fields they select by, e.g. `room` to `room_id`.
+	filters map[string]string
+	// enums restrict values of filters, e.g. to stream types.
+	enums map[string][]string
+	// sorts are fields the list may be sorted by, the first one is the default.
+	sorts []string
+	// desc orders the list descending by default, e.g. newest first.
+	desc bool
+	// window is the time field `from` and `to` select by, none if empty.
+	window string
+	// limits of the page size, defaultListLimit and maxListLimit if zero.
+	defaultLimit int
+	maxLimit     int
+}
+
+// listCursor is the `cursor` parameter of the next page. It carries the order of
+// the list, filters are repeated by the caller.
+type listCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	types.Cursor
+}
+
+func (c *listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(v string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (ls *listSpec) limits() (int, int) {
+	def, max := ls.defaultLimit, ls.maxLimit
+	if def == 0 {
+		def = defaultListLimit
+	}
+	if max == 0 {
+		max = maxListLimit
+	}
+	return def, max
+}
+
+func (ls *listSpec) sortable(field string) bool {
+	return contains(ls.sorts, field)
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parse reads the query of a list request: filters of the spec, `sort` by a field,
+// descending if prefixed with `-`, `cursor` of the page to continue with, `limit`
+// of the page and the `from` and `to` window. One more entity than the limit is
+// queried, which tells if there is a next page, see page. Invalid parameters are
+// reported as a validationError.
+func (ls *listSpec) parse(req *http.Request) (*types.ListQuery, error) {
+	params := req.URL.Query()
+	fail := func(param, reason string) error {
+		return validationError{{Field: param, Reason: reason}}
+	}
+
+	q := &types.ListQuery{Sort: ls.sorts[0], Desc: ls.desc}
+	for param, field := range ls.filters {
+		if v := params.Get(param); v != "" {
+			if enum := ls.enums[param]; enum != nil && !contains(enum, v) {
+				return nil, fail(param, "must be one of "+strings.Join(enum, ", "))
+			}
+			if q.Filters == nil {
+				q.Filters = make(map[string]string)
+			}
+			q.Filters[field] = v
+		}
+	}
+
+	limit, max := ls.limits()
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > max {
+			return nil, fail("limit", "must be within [1, "+strconv.Itoa(max)+"]")
+		}
+		limit = n
+	}
+	q.Limit = limit + 1
+
+	sortSet := params.Get("sort") != ""
+	if sortSet {
+		v := params.Get("sort")
+		q.Sort, q.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		if !ls.sortable(q.Sort) {
+			return nil, fail("sort", "must be one of "+strings.Join(ls.sorts, ", ")+", descending if prefixed with -")
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		c, err := decodeListCursor(v)
+		if err != nil || !ls.sortable(c.Sort) {
+			return nil, fail("cursor", "invalid cursor")
+		}
+		if sortSet && (c.Sort != q.Sort || c.Desc != q.Desc) {
+			return nil, fail("cursor", "cursor of another sort order")
+		}
+		q.Sort, q.Desc = c.Sort, c.Desc
+		q.After = &c.Cursor
+	}
+
+	if ls.window != "" {
+		w, err := parseWindow(req)
+		if err != nil {
+			return nil, fail("from", "invalid time window")
+		}
+		if !w.From.IsZero() || !w.To.IsZero() {
+			r := types.Range{Field: ls.window}
+			if !w.From.IsZero() {
+				r.From = &w.From
+			}
+			if !w.To.IsZero() {
+				r.To = &w.To
+			}
+			q.Ranges = append(q.Ranges, r)
+		}
+	}
+	return q, nil
+}
+
+// params describes query parameters of the list in the API document.
+func (ls *listSpec) params() []apiParam {
+	_, max := ls.limits()
+	var params []apiParam
+	for param, field := range ls.filters {
+		params = append(params, apiParam{param, "Select by `" + field + "`.", &schema{Type: "string", Enum: ls.enums[param]}})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].name < params[j].name })
+	sorts := make([]string, 0, 2*len(ls.sorts))
+	for _, name := range ls.sorts {
+		sorts = append(sorts, name, "-"+name)
+	}
+	params = append(params,
+		apiParam{"sort", "Field to order by, descending if prefixed with `-`.", &schema{Type: "string", Enum: sorts}},
+		apiParam{"cursor", "`next` of the previous page, with the same filters.", &schema{Type: "string"}},
+		apiParam{"limit", "How many entries to return.", &schema{Type: "integer", Minimum: bound(1), Maximum: bound(float64(max))}},
+	)
+	if ls.window != "" {
+		params = append(params,
+			apiParam{"from", "Start of the window of `" + ls.window + "`, Unix seconds or RFC 3339.", &schema{Type: "string"}},
+			apiParam{"to", "End of the window of `" + ls.window + "`, Unix seconds or RFC 3339.", &schema{Type: "string"}},
+		)
+	}
+	return params
+}
+
+// page returns how many of the n entities queried by parse are on the page, and
+// the cursor of the next page, empty on the last one.
+func page(q *types.ListQuery, n int, entity func(i int) types.Listed) (int, string) {
+	limit := q.Limit - 1
+	if n <= limit {
+		return n, ""
+	}
+	c := &listCursor{Sort: q.SortField(), Desc: q.Desc, Cursor: *q.CursorOf(entity(limit - 1))}
+	return limit, c.encode()
+}
+
+// listResp replies with a page of a list, `next` is the cursor of the next page if any.
+func listResp(now time.Time, data interface{}, next string) *ServerResp {
+	resp := NoErr(now, data)
+	if next != "" {
+		resp.WithParam("next", next)
+	}
+	return resp
+}
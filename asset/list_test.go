@@ -0,0 +1,90 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// listIDs gets a page of a list and returns IDs of its entries with the cursor of the next page.
+func listIDs(t *testing.T, h http.Handler, target, idField string) ([]string, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var resp struct {
+		Ctrl *ServerCtrlResp          `json:"ctrl"`
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: got %d, %s", target, rec.Code, rec.Body)
+	}
+	ids := make([]string, 0, len(resp.Data))
+	for _, e := range resp.Data {
+		ids = append(ids, e[idField].(string))
+	}
+	next, _ := resp.Ctrl.Params["next"].(string)
+	return ids, next
+}
+
+func TestListPaging(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for _, room := range []map[string]string{{"id": "r1", "name": "B"}, {"id": "r2", "name": "A"}, {"id": "r3", "name": "B"}} {
+		if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", room); code != http.StatusCreated {
+			t.Fatalf("create room: got %d", code)
+		}
+	}
+
+	var pages []string
+	target := "/api/v0/rooms?sort=-name&limit=2"
+	for {
+		ids, next := listIDs(t, mux, target, "id")
+		pages = append(pages, strings.Join(ids, ","))
+		if next == "" {
+			break
+		}
+		target = "/api/v0/rooms?limit=2&cursor=" + url.QueryEscape(next)
+	}
+	if got := strings.Join(pages, " "); got != "r3,r1 r2" {
+		t.Errorf("pages by name: got %s", got)
+	}
+	if ids, next := listIDs(t, mux, "/api/v0/rooms?name=B", "id"); strings.Join(ids, ",") != "r1,r3" || next != "" {
+		t.Errorf("filter by name: got %v, %q", ids, next)
+	}
+
+	_, next := listIDs(t, mux, "/api/v0/rooms?limit=1", "id")
+	for _, target := range []string{
+		"/api/v0/rooms?sort=hash",
+		"/api/v0/rooms?limit=1001",
+		"/api/v0/rooms?cursor=nonsense",
+		"/api/v0/rooms?sort=-id&cursor=" + url.QueryEscape(next),
+		"/api/v0/rooms/r1/streams?type=mic",
+		"/api/v0/devices?state=away",
+	} {
+		if code, _ := doRequest(t, mux, http.MethodGet, target, nil); code != http.StatusBadRequest {
+			t.Errorf("GET %s: got %d", target, code)
+		}
+	}
+
+	for _, dev := range []map[string]string{{"serial": "SN1", "room_id": "r1"}, {"serial": "SN2", "room_id": "r2"}} {
+		if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices", dev); code != http.StatusCreated {
+			t.Fatalf("create device: got %d", code)
+		}
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/devices/SN2/heartbeat", nil); code != http.StatusOK {
+		t.Fatalf("heartbeat: got %d", code)
+	}
+	if ids, _ := listIDs(t, mux, "/api/v0/devices?state=online", "serial"); strings.Join(ids, ",") != "SN2" {
+		t.Errorf("online devices: got %v", ids)
+	}
+	if ids, _ := listIDs(t, mux, "/api/v0/devices?state=offline", "serial"); strings.Join(ids, ",") != "SN1" {
+		t.Errorf("offline devices: got %v", ids)
+	}
+	if ids, _ := listIDs(t, mux, "/api/v0/devices?room=r2&state=offline", "serial"); len(ids) != 0 {
+		t.Errorf("offline devices of room: got %v", ids)
+	}
+}
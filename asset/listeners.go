@@ -0,0 +1,133 @@
+package asset
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// Groups of routes a listener may serve.
+const (
+	// routeAPI is the REST API under the API path.
+	routeAPI = "api"
+	// routeMetrics is runtime stats as expvar JSON and in Prometheus format.
+	routeMetrics = "metrics"
+	// routePprof is debug profiling.
+	routePprof = "pprof"
+)
+
+// allRoutes is what a listener serves if routes are not given.
+var allRoutes = []string{routeAPI, routeMetrics, routePprof}
+
+// listenerConfig configures one of the addresses the server listens on.
+type listenerConfig struct {
+	// Addr is a TCP address, or a path of unix socket prefixed with `unix:`.
+	Addr string `yaml:"addr"`
+	// Routes lists route groups served, `api`, `metrics` and `pprof`. All if empty.
+	Routes []string `yaml:"routes"`
+	// TLS serves HTTPS with certificates of the `tls` section.
+	TLS bool `yaml:"tls"`
+	// Trusted skips authentication, e.g. for a unix socket only local tools can reach.
+	Trusted bool `yaml:"trusted"`
+	// RequireClientCert refuses TLS connections without a valid device certificate,
+	// for a listener only devices call. It needs `client_ca` of the `tls` section.
+	RequireClientCert bool `yaml:"require_client_cert"`
+}
+
+type unixSocketKey struct{}
+
+// fromUnixSocket reports if the request came through a unix socket, i.e. from the
+// same host.
+func fromUnixSocket(req *http.Request) bool {
+	local, _ := req.Context().Value(unixSocketKey{}).(bool)
+	return local
+}
+
+// listenerConfigs returns configured listeners, or a single listener serving
+// everything on `listen` address if none is configured.
+func (cfg *Config) listenerConfigs() []listenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	return []listenerConfig{{Addr: cfg.ListenAddr, TLS: cfg.TLS.enabled()}}
+}
+
+// newMux registers handlers of the route groups.
+func (s *Server) newMux(routes []string) (*http.ServeMux, error) {
+	if len(routes) == 0 {
+		routes = allRoutes
+	}
+
+	// Must use non-default mux because of expvar.
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		switch route {
+		case routeAPI:
+			s.serveAPI(mux)
+		case routeMetrics:
+			stats.ServeExpvar(mux, s.cfg.ExpvarPath)
+			stats.ServeMetrics(mux, s.cfg.MetricsPath)
+		case routePprof:
+			servePprof(mux, s.cfg.PProfURL)
+		default:
+			return nil, fmt.Errorf("unknown route group '%s', expecting one of %s", route, strings.Join(allRoutes, ", "))
+		}
+	}
+	return mux, nil
+}
+
+// listeners builds listeners as configured. HTTPS listeners use certificates of
+// certs, and plain HTTP calls to the redirect address are sent to the first of them.
+func (s *Server) listeners(certs *certReloader) ([]*listener, error) {
+	var (
+		listeners []*listener
+		httpsAddr string
+	)
+	for _, lc := range s.cfg.listenerConfigs() {
+		if lc.Addr == "" {
+			return nil, fmt.Errorf("listener address is required")
+		}
+		mux, err := s.newMux(lc.Routes)
+		if err != nil {
+			return nil, err
+		}
+
+		handler := s.rateLimit(mux, mux, rateLimitCaller)
+		if !lc.Trusted {
+			handler = s.authenticate(handler)
+		}
+		handler = s.rateLimit(mux, handler, rateLimitAddress)
+		handler = s.cors(handler)
+		if strings.HasPrefix(lc.Addr, unixPrefix) {
+			next := handler
+			handler = http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+				next.ServeHTTP(wrt, req.WithContext(context.WithValue(req.Context(), unixSocketKey{}, true)))
+			})
+		}
+		l := &listener{addr: lc.Addr, handler: instrument(mux, handler)}
+		if lc.RequireClientCert && (!lc.TLS || s.cfg.TLS.ClientCA == "") {
+			return nil, fmt.Errorf("listener '%s' requires client certificates, it needs tls and client_ca", lc.Addr)
+		}
+		if lc.TLS {
+			if certs == nil {
+				return nil, fmt.Errorf("listener '%s' asks for TLS, but certificates are not configured", lc.Addr)
+			}
+			l.tls = certs.tlsConfig(lc.RequireClientCert)
+			if httpsAddr == "" && !strings.HasPrefix(lc.Addr, unixPrefix) {
+				httpsAddr = lc.Addr
+			}
+		}
+		listeners = append(listeners, l)
+	}
+
+	if s.cfg.TLS.RedirectHTTP != "" {
+		if httpsAddr == "" {
+			return nil, fmt.Errorf("tls: redirect_http needs a TCP listener serving HTTPS")
+		}
+		listeners = append(listeners, &listener{addr: s.cfg.TLS.RedirectHTTP, handler: redirectToHTTPS(httpsAddr)})
+	}
+	return listeners, nil
+}
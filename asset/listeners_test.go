@@ -0,0 +1,78 @@
+package asset
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListeners(t *testing.T) {
+	s, _ := newTestServer(t)
+	socket := filepath.Join(t.TempDir(), "asset.sock")
+	s.cfg.Auth.Enabled = true
+	s.cfg.MetricsPath = "/metrics"
+	s.cfg.Listeners = []listenerConfig{
+		{Addr: "127.0.0.1:0", Routes: []string{routeMetrics}, Trusted: true},
+		{Addr: unixPrefix + socket, Routes: []string{routeAPI}, Trusted: true},
+	}
+
+	listeners, err := s.listeners(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the admin listener has no API.
+	rec := httptest.NewRecorder()
+	listeners[0].handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v0/rooms", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("API on admin listener: got %d", rec.Code)
+	}
+
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() { done <- listenAndServe(listeners, stop) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		// wait for the socket to come up.
+		if resp, err = client.Get("http://asset/api/v0/rooms"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// trusted listeners don't ask for credentials.
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("API on unix socket: got %d", resp.StatusCode)
+	}
+	if resp, err = client.Get("http://asset/metrics"); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("metrics served on unix socket")
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("stop: %v", err)
+	}
+
+	s.cfg.Listeners = []listenerConfig{{Addr: ":0", Routes: []string{"admin"}}}
+	if _, err := s.listeners(nil); err == nil {
+		t.Error("unknown route group accepted")
+	}
+}
@@ -0,0 +1,266 @@
+package asset
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+const (
+	// defaultStatInterval is how often sls statistics are polled.
+	defaultStatInterval = 5 * time.Second
+
+	// statPath is where sls serves statistics on its HTTP port.
+	statPath = "/sls/stat"
+
+	// statTimeout limits a single statistics request.
+	statTimeout = 3 * time.Second
+)
+
+// Roles of sls clients reported in statistics.
+const (
+	slsRolePublisher = "publisher"
+	slsRolePlayer    = "player"
+)
+
+// slsValue accepts both JSON strings and numbers, sls reports numbers as strings.
+type slsValue string
+
+func (v *slsValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = slsValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*v = slsValue(n)
+	return nil
+}
+
+// slsStat is a single client connection reported by sls.
+type slsStat struct {
+	Port       slsValue `json:"port"`
+	Role       slsValue `json:"role"`
+	StreamName slsValue `json:"stream_name"`
+	URL        slsValue `json:"url"`
+	RemoteIP   slsValue `json:"remote_ip"`
+	RemotePort slsValue `json:"remote_port"`
+	StartTime  slsValue `json:"start_time"`
+	KBitrate   slsValue `json:"kbitrate"`
+}
+
+// slsStatResp is the body sls returns from its statistics endpoint.
+type slsStatResp struct {
+	Code int       `json:"code"`
+	Data []slsStat `json:"data"`
+	Msg  string    `json:"msg"`
+}
+
+// liveStatus is the live state of a stream.
+type liveStatus struct {
+	Online bool `json:"online"`
+	// Publisher is the address of the publishing client.
+	Publisher string `json:"publisher,omitempty"`
+	// KBitrate is the bitrate of the published stream in kbit/s.
+	KBitrate int64 `json:"kbitrate"`
+	Players  int   `json:"players"`
+	// Since is when the stream was first seen publishing.
+	Since *time.Time `json:"since,omitempty"`
+	// CheckedAt is when sls statistics were last read.
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// liveMonitor polls sls statistics and keeps live state of streams by stream key.
+type liveMonitor struct {
+	statURL  string
+	interval time.Duration
+	client   *http.Client
+	// notify is called when a stream starts or stops publishing, if set.
+	notify func(key string, online bool, since *time.Time)
+
+	mu        sync.RWMutex
+	available bool
+	// primed is set by the first successful poll, changes after it are reported
+	// even across failed polls, against the last known streams.
+	primed    bool
+	checkedAt time.Time
+	streams   map[string]*liveStatus
+}
+
+func newLiveMonitor(statURL string, interval time.Duration) *liveMonitor {
+	if interval <= 0 {
+		interval = defaultStatInterval
+	}
+	return &liveMonitor{
+		statURL:  statURL,
+		interval: interval,
+		client:   &http.Client{Timeout: statTimeout},
+		streams:  make(map[string]*liveStatus),
+	}
+}
+
+// status returns live state of the stream, or nil if sls statistics are unavailable.
+func (m *liveMonitor) status(key string) *liveStatus {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.available {
+		return nil
+	}
+	if st, ok := m.streams[key]; ok {
+		copied := *st
+		return &copied
+	}
+	return &liveStatus{CheckedAt: m.checkedAt}
+}
+
+// fetch reads current statistics from sls.
+func (m *liveMonitor) fetch() ([]slsStat, error) {
+	resp, err := m.client.Get(m.statURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var body slsStatResp
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Code != 0 {
+		return nil, fmt.Errorf("sls error %d, %s", body.Code, body.Msg)
+	}
+	return body.Data, nil
+}
+
+// aggregate folds client connections into live state per stream key.
+func aggregate(stats []slsStat, now time.Time) map[string]*liveStatus {
+	streams := make(map[string]*liveStatus)
+	for _, st := range stats {
+		key := string(st.StreamName)
+		if key == "" {
+			// older sls versions only report the full stream path.
+			parts := strings.Split(string(st.URL), "/")
+			key = parts[len(parts)-1]
+		}
+		if key == "" {
+			continue
+		}
+
+		ls, ok := streams[key]
+		if !ok {
+			ls = &liveStatus{CheckedAt: now}
+			streams[key] = ls
+		}
+		switch string(st.Role) {
+		case slsRolePublisher:
+			ls.Online = true
+			ls.Publisher = net.JoinHostPort(string(st.RemoteIP), string(st.RemotePort))
+			ls.KBitrate, _ = strconv.ParseInt(string(st.KBitrate), 10, 64)
+		case slsRolePlayer:
+			ls.Players++
+		}
+	}
+	return streams
+}
+
+// poll refreshes live state and reports streams that started or stopped publishing.
+func (m *liveMonitor) poll(now time.Time) {
+	list, err := m.fetch()
+	if err != nil {
+		logger.Warnf("live: Failed to read sls statistics, %v", err)
+		stats.Inc("StatPollErrors", 1)
+
+		m.mu.Lock()
+		m.available = false
+		m.mu.Unlock()
+		return
+	}
+	streams := aggregate(list, now)
+
+	m.mu.Lock()
+	prev, primed := m.streams, m.primed
+	for key, st := range streams {
+		if !st.Online {
+			continue
+		}
+		if old, ok := prev[key]; ok && old.Online {
+			st.Since = old.Since
+		} else {
+			since := now
+			st.Since = &since
+		}
+	}
+	m.streams, m.available, m.primed, m.checkedAt = streams, true, true, now
+	m.mu.Unlock()
+
+	live := 0
+	for key, st := range streams {
+		if !st.Online {
+			continue
+		}
+		live++
+		if old, ok := prev[key]; !ok || !old.Online {
+			m.transition(key, true, st.Since, primed)
+		}
+	}
+	for key, old := range prev {
+		if st, ok := streams[key]; old.Online && (!ok || !st.Online) {
+			m.transition(key, false, old.Since, primed)
+		}
+	}
+	stats.Set("StreamsLive", int64(live))
+}
+
+// transition handles a stream starting or stopping publishing. Changes seen by the
+// first successful poll may predate the asset server and are only logged.
+func (m *liveMonitor) transition(key string, online bool, since *time.Time, observed bool) {
+	if online {
+		logger.Infof("live: Stream '%s' is publishing", key)
+	} else {
+		logger.Infof("live: Stream '%s' stopped publishing", key)
+	}
+	if !observed {
+		return
+	}
+	if online {
+		stats.Inc("StreamPublishes", 1)
+	}
+	if m.notify != nil {
+		m.notify(key, online, since)
+	}
+}
+
+// run polls sls periodically until stop is closed.
+func (m *liveMonitor) run(stop <-chan bool) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.poll(types.TimeNow())
+	for {
+		select {
+		case <-ticker.C:
+			m.poll(types.TimeNow())
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package asset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStats is a stand-in for the sls statistics endpoint.
+const fakeStats = `{"code": 0, "msg": "success", "data": [
+	{"port": "8080", "role": "publisher", "pub_domain_app": "uplive.example.com/live", "stream_name": "room01_dev",
+	 "url": "uplive.example.com/live/room01_dev", "remote_ip": "10.0.0.7", "remote_port": "50123",
+	 "start_time": "2020-11-20 10:00:00", "kbitrate": "2048"},
+	{"port": "8080", "role": "player", "stream_name": "room01_dev", "remote_ip": "10.0.0.8", "remote_port": "50200"},
+	{"port": "8080", "role": "player", "url": "live.example.com/live/room01_dev", "remote_ip": "10.0.0.9", "remote_port": 50201}
+]}`
+
+func TestLiveStatus(t *testing.T) {
+	stats := fakeStats
+	sls := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != statPath {
+			http.NotFound(wrt, req)
+			return
+		}
+		io.WriteString(wrt, stats)
+	}))
+	defer sls.Close()
+
+	s, mux := newTestServer(t)
+	s.live = newLiveMonitor(sls.URL+statPath, time.Second)
+
+	if code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01"}); code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+
+	s.live.poll(time.Now())
+	code, data := doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room01", nil)
+	var room roomResp
+	if err := json.Unmarshal(data, &room); err != nil || code != http.StatusOK {
+		t.Fatalf("get room: got %d, %s", code, data)
+	}
+	if !room.Live {
+		t.Errorf("room is not live: %s", data)
+	}
+	for _, st := range room.Streams {
+		if st.Status == nil {
+			t.Fatalf("stream %s has no status", st.Key)
+		}
+		switch st.Key {
+		case "room01_dev":
+			want := liveStatus{Online: true, Publisher: "10.0.0.7:50123", KBitrate: 2048, Players: 2}
+			got := *st.Status
+			if got.Since == nil {
+				t.Errorf("dev status has no publish time: %+v", got)
+			}
+			got.CheckedAt, got.Since = time.Time{}, nil
+			if got != want {
+				t.Errorf("dev status: got %+v, want %+v", got, want)
+			}
+		case "room01_cam":
+			if st.Status.Online {
+				t.Errorf("cam is online: %+v", st.Status)
+			}
+		}
+	}
+
+	// statistics going away must not leave stale state behind.
+	stats = `{"code": -1, "msg": "failed"}`
+	s.live.poll(time.Now())
+	if st := s.live.status("room01_dev"); st != nil {
+		t.Errorf("status while sls is unavailable: got %+v", st)
+	}
+}
+
+func TestLiveRecovery(t *testing.T) {
+	stats := fakeStats
+	sls := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		io.WriteString(wrt, stats)
+	}))
+	defer sls.Close()
+
+	m := newLiveMonitor(sls.URL+statPath, time.Second)
+	var changes []string
+	m.notify = func(key string, online bool, since *time.Time) {
+		changes = append(changes, fmt.Sprintf("%s:%v", key, online))
+	}
+
+	// streams publishing before the first poll are not reported.
+	m.poll(time.Now())
+	if len(changes) != 0 {
+		t.Fatalf("first poll: got %v", changes)
+	}
+
+	// changes during an outage are reported once sls is back.
+	stats = `{"code": -1, "msg": "failed"}`
+	m.poll(time.Now())
+	stats = `{"code": 0, "msg": "success", "data": [
+		{"role": "publisher", "stream_name": "room01_cam", "remote_ip": "10.0.0.6", "remote_port": "50100"}
+	]}`
+	m.poll(time.Now())
+	sort.Strings(changes)
+	if got := strings.Join(changes, " "); got != "room01_cam:true room01_dev:false" {
+		t.Errorf("after recovery: got %s", got)
+	}
+}
@@ -0,0 +1,397 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// openAPIVersion is the version of OpenAPI specification the document follows.
+const openAPIVersion = "3.0.3"
+
+// Who may call an operation.
+const (
+	// accessPublic needs no credentials.
+	accessPublic = iota
+	// accessAny needs an API key or a bearer token.
+	accessAny
+	// accessService needs an API key.
+	accessService
+)
+
+func stringSchema(description string) *schema {
+	return &schema{Type: "string", Description: description}
+}
+
+var (
+	streamTypeSchema = &schema{
+		Type: "string",
+		Enum: []string{string(types.StreamDevice), string(types.StreamCamera)},
+	}
+	eventTypeSchema = &schema{Type: "string", Enum: sortedEventTypes()}
+)
+
+// Schemas of request bodies.
+var (
+	roomCreateSchema = &schema{
+		Type:     "object",
+		Required: []string{"id"},
+		Properties: map[string]*schema{
+			"id":   {Type: "string", Pattern: idPattern.String()},
+			"name": stringSchema("Display name of the room."),
+			"streams": {
+				Type:        "array",
+				Description: "Types of streams to create along with the room, a device and a camera stream if not set.",
+				Items:       streamTypeSchema,
+			},
+		},
+	}
+	roomUpdateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"id":   {Type: "string", Description: "Must be the ID of the room if set.", Pattern: idPattern.String()},
+			"name": stringSchema("Display name of the room, kept if absent."),
+		},
+	}
+	streamCreateSchema = &schema{
+		Type:     "object",
+		Required: []string{"type"},
+		Properties: map[string]*schema{
+			"type": streamTypeSchema,
+			"key":  {Type: "string", Description: "Stream name in SRT stream IDs, `{room}_{type}` if not set.", Pattern: keyPattern.String()},
+		},
+	}
+	streamUpdateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"type": streamTypeSchema,
+			"key":  {Type: "string", Description: "Stream name in SRT stream IDs.", Pattern: keyPattern.String()},
+		},
+	}
+	deviceCreateSchema = &schema{
+		Type:     "object",
+		Required: []string{"serial"},
+		Properties: map[string]*schema{
+			"serial":    {Type: "string", Pattern: idPattern.String()},
+			"model":     stringSchema(""),
+			"room_id":   stringSchema("Room the device is placed in."),
+			"stream_id": stringSchema("Stream the device publishes to, it must belong to the room if both are set."),
+		},
+	}
+	deviceUpdateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"serial":    {Type: "string", Description: "Must be the serial of the device if set.", Pattern: idPattern.String()},
+			"model":     stringSchema(""),
+			"room_id":   stringSchema("Room the device is placed in, kept if absent and cleared if empty. Moving the device to another room clears its stream unless set."),
+			"stream_id": stringSchema("Stream the device publishes to, kept if absent and cleared if empty. It must belong to the room."),
+		},
+	}
+	apiKeyCreateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"name": stringSchema("What the key is for."),
+		},
+	}
+	tokenIssueSchema = &schema{
+		Type:     "object",
+		Required: []string{"subject"},
+		Properties: map[string]*schema{
+			"subject": {Type: "string", Description: "Who the token is issued to.", MinLength: 1},
+			"stream":  {Type: "string", Description: "Key of the stream to issue a play token for.", Pattern: keyPattern.String()},
+			"ttl": {
+				Type:        "integer",
+				Description: "Lifetime of the token in seconds, the configured one if zero, at most a day.",
+				Minimum:     bound(0),
+				Maximum:     bound(maxTokenTTL.Seconds()),
+			},
+		},
+	}
+	webhookCreateSchema = &schema{
+		Type:     "object",
+		Required: []string{"url"},
+		Properties: map[string]*schema{
+			"url":    {Type: "string", Format: "uri", Description: "HTTP or HTTPS URL events are posted to."},
+			"secret": stringSchema("Key deliveries are signed with, generated if not set."),
+			"events": {Type: "array", Description: "Event types to deliver, all if empty.", Items: eventTypeSchema},
+		},
+	}
+	webhookUpdateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"url":    {Type: "string", Format: "uri", Description: "HTTP or HTTPS URL events are posted to."},
+			"secret": stringSchema("Key deliveries are signed with."),
+			"events": {Type: "array", Description: "Event types to deliver, all if empty.", Items: eventTypeSchema},
+		},
+	}
+	holdCreateSchema = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"from":   {Type: "string", Format: "date-time", Nullable: true, Description: "Start of held recordings, the beginning if not set."},
+			"to":     {Type: "string", Format: "date-time", Nullable: true, Description: "End of held recordings, open ended if not set."},
+			"reason": stringSchema(""),
+		},
+	}
+)
+
+func sortedEventTypes() []string {
+	list := make([]string, 0, len(eventTypes))
+	for typ := range eventTypes {
+		list = append(list, typ)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// apiParam is a query parameter of an operation.
+type apiParam struct {
+	name        string
+	description string
+	schema      *schema
+}
+
+var timeWindowParams = []apiParam{
+	{"from", "Start of the window, Unix seconds or RFC 3339.", &schema{Type: "string"}},
+	{"to", "End of the window, Unix seconds or RFC 3339.", &schema{Type: "string"}},
+}
+
+// apiOperation describes a route of the API.
+type apiOperation struct {
+	method string
+	// path is relative to the API path, with `{name}` path parameters.
+	path    string
+	summary string
+	access  int
+	query   []apiParam
+	// body names the request body schema in components.
+	body string
+	// status of a successful reply, 200 if not set.
+	status int
+	// data is a value of the type replied in `data` of the envelope, nil if none.
+	data interface{}
+	// raw is the content type of replies which don't use the envelope.
+	raw string
+}
+
+// requestSchemas are request bodies by component name.
+var requestSchemas = map[string]*schema{
+	"RoomCreate":    roomCreateSchema,
+	"RoomUpdate":    roomUpdateSchema,
+	"StreamCreate":  streamCreateSchema,
+	"StreamUpdate":  streamUpdateSchema,
+	"DeviceCreate":  deviceCreateSchema,
+	"DeviceUpdate":  deviceUpdateSchema,
+	"APIKeyCreate":  apiKeyCreateSchema,
+	"TokenIssue":    tokenIssueSchema,
+	"WebhookCreate": webhookCreateSchema,
+	"WebhookUpdate": webhookUpdateSchema,
+	"HoldCreate":    holdCreateSchema,
+}
+
+// apiOperations lists every route under the API path.
+var apiOperations = []apiOperation{
+	{method: http.MethodGet, path: "v0/index", summary: "Check the server is up", access: accessPublic, data: map[string]string{}},
+	{method: http.MethodGet, path: "v0/openapi.json", summary: "Describe the API", access: accessPublic, raw: "application/json"},
+
+	{method: http.MethodGet, path: "v0/rooms", summary: "List rooms with their streams", access: accessAny, query: roomListSpec.params(), data: []roomResp{}},
+	{method: http.MethodPost, path: "v0/rooms", summary: "Create a room and its streams", access: accessAny, body: "RoomCreate", status: http.StatusCreated, data: roomResp{}},
+	{method: http.MethodGet, path: "v0/rooms/{id}", summary: "Get a room with its streams", access: accessAny, data: roomResp{}},
+	{method: http.MethodPut, path: "v0/rooms/{id}", summary: "Update a room", access: accessAny, body: "RoomUpdate", data: types.Room{}},
+	{method: http.MethodPatch, path: "v0/rooms/{id}", summary: "Update a room", access: accessAny, body: "RoomUpdate", data: types.Room{}},
+	{method: http.MethodDelete, path: "v0/rooms/{id}", summary: "Delete a room and its streams", access: accessAny},
+	{method: http.MethodGet, path: "v0/rooms/{id}/recordings", summary: "Summarize recordings of all streams of a room", access: accessAny, query: timeWindowParams, data: []recordingResp{}},
+
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams", summary: "List streams of a room", access: accessAny, query: streamListSpec.params(), data: []streamResp{}},
+	{method: http.MethodPost, path: "v0/rooms/{id}/streams", summary: "Add a stream to a room", access: accessAny, body: "StreamCreate", status: http.StatusCreated, data: streamResp{}},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}", summary: "Get a stream", access: accessAny, data: streamResp{}},
+	{method: http.MethodPut, path: "v0/rooms/{id}/streams/{sid}", summary: "Update a stream", access: accessAny, body: "StreamUpdate", data: streamResp{}},
+	{method: http.MethodPatch, path: "v0/rooms/{id}/streams/{sid}", summary: "Update a stream", access: accessAny, body: "StreamUpdate", data: streamResp{}},
+	{method: http.MethodDelete, path: "v0/rooms/{id}/streams/{sid}", summary: "Delete a stream", access: accessAny},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}/recordings", summary: "List recorded segments of a stream", access: accessAny, query: append(segmentListSpec.params(), timeWindowParams...), data: streamRecordingsResp{}},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}/recordings.m3u8", summary: "Play recordings of a stream as HLS VOD", access: accessAny, query: timeWindowParams, raw: mimeM3U8},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}/recordings/{name}", summary: "Download a recorded segment", access: accessAny, raw: "video/mp2t"},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}/holds", summary: "List legal holds on recordings of a stream", access: accessService, query: holdListSpec.params(), data: []types.Hold{}},
+	{method: http.MethodPost, path: "v0/rooms/{id}/streams/{sid}/holds", summary: "Keep recordings of a stream from retention", access: accessService, body: "HoldCreate", status: http.StatusCreated, data: types.Hold{}},
+	{method: http.MethodGet, path: "v0/rooms/{id}/streams/{sid}/holds/{hid}", summary: "Get a legal hold", access: accessService, data: types.Hold{}},
+	{method: http.MethodDelete, path: "v0/rooms/{id}/streams/{sid}/holds/{hid}", summary: "Release a legal hold", access: accessService},
+
+	{method: http.MethodGet, path: "v0/devices", summary: "List devices with their state", access: accessAny, query: append(deviceListSpec.params(),
+		apiParam{"state", "Select by state derived from heartbeats.", &schema{Type: "string", Enum: []string{deviceOnline, deviceOffline}}}), data: []deviceResp{}},
+	{method: http.MethodPost, path: "v0/devices", summary: "Register a device", access: accessAny, body: "DeviceCreate", status: http.StatusCreated, data: deviceResp{}},
+	{method: http.MethodGet, path: "v0/devices/{serial}", summary: "Get a device", access: accessAny, data: deviceResp{}},
+	{method: http.MethodPut, path: "v0/devices/{serial}", summary: "Update or reassign a device", access: accessAny, body: "DeviceUpdate", data: deviceResp{}},
+	{method: http.MethodPatch, path: "v0/devices/{serial}", summary: "Update or reassign a device", access: accessAny, body: "DeviceUpdate", data: deviceResp{}},
+	{method: http.MethodDelete, path: "v0/devices/{serial}", summary: "Delete a device", access: accessAny},
+	{method: http.MethodPost, path: "v0/devices/{serial}/heartbeat", summary: "Report a device is alive, devices may use client certificates", access: accessAny, data: map[string]string{}},
+
+	{method: http.MethodGet, path: "v0/auth/keys", summary: "List API keys", access: accessService, query: apiKeyListSpec.params(), data: []types.APIKey{}},
+	{method: http.MethodPost, path: "v0/auth/keys", summary: "Create an API key, the key is only shown once", access: accessService, body: "APIKeyCreate", status: http.StatusCreated, data: apiKeyResp{}},
+	{method: http.MethodDelete, path: "v0/auth/keys/{id}", summary: "Revoke an API key", access: accessService},
+	{method: http.MethodPost, path: "v0/auth/tokens", summary: "Issue a bearer token for a client app", access: accessService, body: "TokenIssue", status: http.StatusCreated, data: tokenResp{}},
+
+	{method: http.MethodGet, path: "v0/events", summary: "Follow lifecycle events as Server-Sent Events or over WebSocket", access: accessAny, query: []apiParam{
+		{"room", "Rooms to follow events of, comma separated, all if not set.", &schema{Type: "string"}},
+		{"last_event_id", "ID of the last event seen, `{epoch}-{seq}`, to resume from. `Last-Event-ID` header takes precedence.", &schema{Type: "string"}},
+		{"access_token", "Bearer token for clients which can't set the `Authorization` header, e.g. browsers.", &schema{Type: "string"}},
+	}, raw: "text/event-stream"},
+
+	{method: http.MethodGet, path: "v0/webhooks", summary: "List webhooks", access: accessService, query: webhookListSpec.params(), data: []types.Webhook{}},
+	{method: http.MethodPost, path: "v0/webhooks", summary: "Create a webhook, the secret is only shown once", access: accessService, body: "WebhookCreate", status: http.StatusCreated, data: webhookResp{}},
+	{method: http.MethodGet, path: "v0/webhooks/{id}", summary: "Get a webhook", access: accessService, data: types.Webhook{}},
+	{method: http.MethodPut, path: "v0/webhooks/{id}", summary: "Update a webhook", access: accessService, body: "WebhookUpdate", data: types.Webhook{}},
+	{method: http.MethodPatch, path: "v0/webhooks/{id}", summary: "Update a webhook", access: accessService, body: "WebhookUpdate", data: types.Webhook{}},
+	{method: http.MethodDelete, path: "v0/webhooks/{id}", summary: "Delete a webhook", access: accessService},
+	{method: http.MethodGet, path: "v0/webhooks/{id}/deliveries", summary: "List deliveries of a webhook, newest first", access: accessService, query: deliveryListSpec.params(), data: []types.Delivery{}},
+
+	{method: http.MethodGet, path: "v0/audit", summary: "List changes made through the API, newest first", access: accessService, query: auditListSpec.params(), data: []auditResp{}},
+
+	{method: http.MethodGet, path: "v0/sls/event", summary: "Authorize SRT sessions, the on_event_url of sls", access: accessPublic, query: slsEventParams},
+	{method: http.MethodPost, path: "v0/sls/event", summary: "Authorize SRT sessions, the on_event_url of sls", access: accessPublic, query: slsEventParams},
+}
+
+var slsEventParams = []apiParam{
+	{"method", "", &schema{Type: "string", Enum: []string{slsEventConnect, slsEventClose}}},
+	{"role_name", "", &schema{Type: "string", Enum: []string{"publisher", "player"}}},
+	{"srt_url", "Stream ID of the session.", &schema{Type: "string"}},
+	{"remote_ip", "", &schema{Type: "string"}},
+	{"remote_port", "", &schema{Type: "string"}},
+}
+
+// openAPIDocument describes the API served under apiPath.
+func openAPIDocument(apiPath string) map[string]interface{} {
+	ref := func(name string) *schema {
+		return &schema{Ref: "#/components/schemas/" + name}
+	}
+	schemas := map[string]*schema{
+		"Ctrl":       schemaOf(reflect.TypeOf(ServerCtrlResp{})),
+		"FieldError": schemaOf(reflect.TypeOf(fieldError{})),
+	}
+	for name, sc := range requestSchemas {
+		schemas[name] = sc
+	}
+
+	security := map[int][]map[string][]string{
+		accessPublic:  {},
+		accessAny:     {{"apiKey": {}}, {"bearer": {}}},
+		accessService: {{"apiKey": {}}},
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		var params []map[string]interface{}
+		for _, part := range strings.Split(op.path, "/") {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				params = append(params, map[string]interface{}{
+					"name": strings.Trim(part, "{}"), "in": "path", "required": true, "schema": &schema{Type: "string"},
+				})
+			}
+		}
+		for _, p := range op.query {
+			param := map[string]interface{}{"name": p.name, "in": "query", "schema": p.schema}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			params = append(params, param)
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		var content map[string]interface{}
+		if op.raw != "" {
+			content = map[string]interface{}{op.raw: map[string]interface{}{}}
+		} else {
+			envelope := &schema{Type: "object", Properties: map[string]*schema{"ctrl": ref("Ctrl")}}
+			if op.data != nil {
+				envelope.Properties["data"] = schemaOf(reflect.TypeOf(op.data))
+			}
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": envelope}}
+		}
+
+		operation := map[string]interface{}{
+			"summary":  op.summary,
+			"tags":     []string{strings.TrimSuffix(strings.Split(op.path, "/")[1], ".json")},
+			"security": security[op.access],
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]interface{}{
+					"description": http.StatusText(status),
+					"content":     content,
+				},
+				"default": map[string]interface{}{"$ref": "#/components/responses/Error"},
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.body != "" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": ref(op.body)},
+				},
+			}
+		}
+
+		path := "/" + op.path
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	server := strings.TrimSuffix(apiPath, "/")
+	if server == "" {
+		server = "/"
+	}
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "asset-server",
+			"version":     version,
+			"description": "Rooms, streams, devices and recordings of the media hub.",
+		},
+		"servers": []map[string]string{{"url": server}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error, `ctrl.params.errors` lists invalid fields of malformed request bodies.",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": &schema{Type: "object", Properties: map[string]*schema{"ctrl": ref("Ctrl")}},
+						},
+					},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]string{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearer": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves `v0/openapi.json`, the OpenAPI document of the API.
+func (s *Server) openAPIHandler(wrt http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeResp(wrt, ErrOperationNotAllowed(types.TimeNow()))
+		return
+	}
+	wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if req.Method == http.MethodHead {
+		return
+	}
+	enc := json.NewEncoder(wrt)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(openAPIDocument(s.cfg.APIPath)); err != nil {
+		logger.Warnf("http: Failed to write OpenAPI document, %v", err)
+	}
+}
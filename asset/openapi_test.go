@@ -0,0 +1,122 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	s, mux := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v0/openapi.json", nil))
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Servers []map[string]string                   `json:"servers"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get document: got %d, %v", rec.Code, err)
+	}
+	if doc.OpenAPI != openAPIVersion || doc.Servers[0]["url"] != "/api" {
+		t.Errorf("document: got %s at %v", doc.OpenAPI, doc.Servers)
+	}
+	if _, ok := doc.Paths["/v0/rooms/{id}/streams/{sid}/holds"]["post"]; !ok {
+		t.Error("holds are not described")
+	}
+
+	// every operation is routed to a handler other than the catch-all.
+	params := regexp.MustCompile(`\{[a-z]+\}`)
+	for _, op := range apiOperations {
+		if op.body != "" && requestSchemas[op.body] == nil {
+			t.Errorf("%s %s: unknown body %s", op.method, op.path, op.body)
+		}
+		req := httptest.NewRequest(op.method, s.cfg.APIPath+params.ReplaceAllString(op.path, "x"), nil)
+		if _, pattern := mux.Handler(req); pattern == s.cfg.APIPath {
+			t.Errorf("%s %s is not routed", op.method, op.path)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	errorsOf := func(data json.RawMessage) []fieldError {
+		var resp struct {
+			Ctrl struct {
+				Params struct {
+					Errors []fieldError `json:"errors"`
+				} `json:"params"`
+			} `json:"ctrl"`
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v0/rooms", bytes.NewReader(data)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", data, rec.Code)
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Ctrl.Params.Errors
+	}
+
+	got := errorsOf(json.RawMessage(`{"id": "bad id", "name": 3, "streams": ["dev", "tv"]}`))
+	want := []fieldError{
+		{"id", "must match " + idPattern.String()},
+		{"name", "must be a string"},
+		{"streams[1]", "must be one of dev, cam"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid fields: got %+v", got)
+	}
+	if got := errorsOf(json.RawMessage(`{"name": "Room"}`)); len(got) != 1 || got[0].Field != "id" || got[0].Reason != "is required" {
+		t.Errorf("missing field: got %+v", got)
+	}
+	if got := errorsOf(json.RawMessage(`[1]`)); len(got) != 1 || got[0].Reason != "must be an object" {
+		t.Errorf("not an object: got %+v", got)
+	}
+
+	var errs validationError
+	holdCreateSchema.validate(map[string]interface{}{"from": nil, "to": "tomorrow"}, "", &errs)
+	if len(errs) != 1 || errs[0].Field != "to" {
+		t.Errorf("hold: got %+v", errs)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Auth.Enabled = true
+	s.cfg.CORS = corsConfig{AllowedOrigins: []string{"https://app.example.com"}}
+	h := s.cors(s.authenticate(mux))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v0/rooms", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight: got %d, %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v0/index", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("unknown origin allowed")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://asset.example.com/api/v0/events", nil)
+	req.Header.Set("Origin", "http://asset.example.com")
+	if !s.checkOrigin(req) {
+		t.Error("same origin WebSocket refused")
+	}
+	req.Header.Set("Origin", "https://evil.example.com")
+	if s.checkOrigin(req) {
+		t.Error("unknown origin WebSocket allowed")
+	}
+}
@@ -7,14 +7,62 @@ import (
 
 // ServerCtrlResp is a server control response {ctrl}.
 type ServerCtrlResp struct {
-	Code      int       `json:"code"`
-	Text      string    `json:"text,omitempty"`
-	Timestamp time.Time `json:"ts"`
+	Code int    `json:"code"`
+	Text string `json:"text,omitempty"`
+	// Params carries details of the reply, e.g. which request parameter is invalid.
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Timestamp time.Time              `json:"ts"`
 }
 
 // ServerResp is a wrapper for server side response.
 type ServerResp struct {
 	Ctrl *ServerCtrlResp `json:"ctrl,omitempty"`
+	Data interface{}     `json:"data,omitempty"`
+}
+
+// WithParam adds a parameter to the control response.
+func (r *ServerResp) WithParam(key string, value interface{}) *ServerResp {
+	if r.Ctrl.Params == nil {
+		r.Ctrl.Params = make(map[string]interface{})
+	}
+	r.Ctrl.Params[key] = value
+	return r
+}
+
+// NoErr indicates successful completion (200).
+func NoErr(ts time.Time, data interface{}) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusOK, // 200
+		Text:      "ok",
+		Timestamp: ts,
+	}, Data: data}
+}
+
+// NoErrCreated indicates a new entity was created (201).
+func NoErrCreated(ts time.Time, data interface{}) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusCreated, // 201
+		Text:      "created",
+		Timestamp: ts,
+	}, Data: data}
+}
+
+// ErrMalformed request malformed (400).
+func ErrMalformed(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusBadRequest, // 400
+		Text:      "malformed",
+		Timestamp: ts,
+	}}
+}
+
+// ErrNotFound entity not found (404).
+func ErrNotFound(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusNotFound, // 404
+		Text:      "not found",
+		Timestamp: ts,
+	}}
 }
 
 // ErrOperationNotAllowed a valid operation is not permitted in this context (405).
@@ -25,3 +73,57 @@ func ErrOperationNotAllowed(ts time.Time) *ServerResp {
 		Timestamp: ts,
 	}}
 }
+
+// ErrAlreadyExists the entity being created already exists (409).
+func ErrAlreadyExists(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusConflict, // 409
+		Text:      "already exists",
+		Timestamp: ts,
+	}}
+}
+
+// ErrUnauthorized authentication required or failed (401).
+func ErrUnauthorized(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusUnauthorized, // 401
+		Text:      "authentication required",
+		Timestamp: ts,
+	}}
+}
+
+// ErrPermissionDenied caller is authenticated but not allowed to perform the operation (403).
+func ErrPermissionDenied(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusForbidden, // 403
+		Text:      "permission denied",
+		Timestamp: ts,
+	}}
+}
+
+// ErrTooManyRequests rate limit exceeded (429).
+func ErrTooManyRequests(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusTooManyRequests, // 429
+		Text:      "too many requests",
+		Timestamp: ts,
+	}}
+}
+
+// ErrInternal database or other server error (500).
+func ErrInternal(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusInternalServerError, // 500
+		Text:      "internal error",
+		Timestamp: ts,
+	}}
+}
+
+// ErrStorageUnavailable storage backend cannot be reached (503).
+func ErrStorageUnavailable(ts time.Time) *ServerResp {
+	return &ServerResp{Ctrl: &ServerCtrlResp{
+		Code:      http.StatusServiceUnavailable, // 503
+		Text:      "storage unavailable",
+		Timestamp: ts,
+	}}
+}
@@ -0,0 +1,193 @@
+package asset
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// rateLimitSweepInterval is how often idle buckets are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimit is a token bucket refilled at Rate requests per second up to Burst.
+type rateLimit struct {
+	// Rate is requests per second, unlimited if zero.
+	Rate float64 `yaml:"rate"`
+	// Burst is how many requests may be made at once, at least one.
+	Burst int `yaml:"burst"`
+}
+
+func (rl rateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return math.Max(1, math.Ceil(rl.Rate))
+}
+
+// rateLimitConfig limits requests of each client address, and of each authenticated
+// caller, an API key, a token subject or a device. Route groups are the first path element after `v0/`, e.g. `rooms`
+// or `auth`, and inherit the default limit unless set in Routes.
+type rateLimitConfig struct {
+	rateLimit `yaml:",inline"`
+	Routes    map[string]rateLimit `yaml:"routes"`
+}
+
+func (rc *rateLimitConfig) enabled() bool {
+	if rc.Rate > 0 {
+		return true
+	}
+	for _, rl := range rc.Routes {
+		if rl.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *rateLimitConfig) limitOf(group string) rateLimit {
+	if rl, ok := rc.Routes[group]; ok {
+		return rl
+	}
+	return rc.rateLimit
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket refills completely, so that it can be dropped.
+	full time.Time
+}
+
+// rateLimiter keeps a token bucket per caller and route group.
+type rateLimiter struct {
+	cfg *rateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(cfg *rateLimitConfig) *rateLimiter {
+	return &rateLimiter{cfg: cfg, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of the caller, or tells how long to wait for one.
+func (l *rateLimiter) allow(group, caller string, now time.Time) (bool, time.Duration) {
+	rl := l.cfg.limitOf(group)
+	if rl.Rate <= 0 {
+		return true, 0
+	}
+	burst := rl.burst()
+	key := group + " " + caller
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rl.Rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.Rate * float64(time.Second))
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((burst - b.tokens) / rl.Rate * float64(time.Second)))
+	return true, 0
+}
+
+// sweep drops buckets which are full again, they are the same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// run drops idle buckets until stop is closed.
+func (l *rateLimiter) run(stop <-chan bool) {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.sweep(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// routeGroup returns the group of a mux pattern, empty for routes outside of the API.
+// Patterns rather than paths keep the number of groups bounded.
+func (s *Server) routeGroup(pattern string) string {
+	group := strings.TrimPrefix(pattern, s.cfg.APIPath+"v0/")
+	if group == pattern {
+		return ""
+	}
+	return strings.TrimSuffix(group, "/")
+}
+
+// rateLimitAddress identifies the client by its address, local callers share one.
+func rateLimitAddress(req *http.Request) string {
+	if fromUnixSocket(req) {
+		return "unix"
+	}
+	return "ip:" + remoteHost(req)
+}
+
+// rateLimitCaller identifies the caller by its credentials, empty if not
+// authenticated, such calls are limited by address only.
+func rateLimitCaller(req *http.Request) string {
+	if id := identityFrom(req); id != nil {
+		return id.Kind + ":" + id.Subject
+	}
+	return ""
+}
+
+// rateLimit rejects calls to routes of the mux over the limit of the caller with 429,
+// next serves the rest. Listeners limit each address before authentication, so that
+// calls with bad or no credentials are limited too, and each caller after it, so that
+// callers sharing an address don't spend each other's limits.
+func (s *Server) rateLimit(mux *http.ServeMux, next http.Handler, caller func(*http.Request) string) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		key := caller(req)
+		if key == "" {
+			next.ServeHTTP(wrt, req)
+			return
+		}
+		_, pattern := mux.Handler(req)
+		group := s.routeGroup(pattern)
+		ok, wait := s.limiter.allow(group, key, time.Now())
+		if ok {
+			next.ServeHTTP(wrt, req)
+			return
+		}
+
+		if group == "" {
+			group = "other"
+		}
+		stats.IncVec("RateLimited", 1, group)
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		wrt.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeResp(wrt, ErrTooManyRequests(types.TimeNow()).WithParam("retry_after", retryAfter))
+	})
+}
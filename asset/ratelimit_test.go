@@ -0,0 +1,109 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var cfg rateLimitConfig
+	if err := yaml.Unmarshal([]byte("rate: 1\nburst: 2\nroutes:\n  sls:\n    rate: 0\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.enabled() || cfg.limitOf("rooms").Burst != 2 || cfg.limitOf("sls").Rate != 0 {
+		t.Fatalf("config: got %+v", cfg)
+	}
+
+	l := newRateLimiter(&cfg)
+	now := time.Unix(1600000000, 0)
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("rooms", "ip:10.0.0.1", now); ok != want {
+			t.Errorf("request %d: got %v", i, ok)
+		}
+	}
+	// callers and groups have buckets of their own, unlimited groups have none.
+	if ok, _ := l.allow("rooms", "ip:10.0.0.2", now); !ok {
+		t.Error("other caller limited")
+	}
+	if ok, _ := l.allow("devices", "ip:10.0.0.1", now); !ok {
+		t.Error("other group limited")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("sls", "ip:10.0.0.1", now); !ok {
+			t.Fatal("unlimited group limited")
+		}
+	}
+
+	if ok, wait := l.allow("rooms", "ip:10.0.0.1", now.Add(500*time.Millisecond)); ok || wait != 500*time.Millisecond {
+		t.Errorf("half a token: got %v, wait %v", ok, wait)
+	}
+	if ok, _ := l.allow("rooms", "ip:10.0.0.1", now.Add(time.Second)); !ok {
+		t.Error("refilled token refused")
+	}
+
+	// only the bucket just drained is kept.
+	l.sweep(now.Add(time.Second))
+	if len(l.buckets) != 1 {
+		t.Errorf("buckets after sweep: got %d", len(l.buckets))
+	}
+	l.sweep(now.Add(time.Minute))
+	if len(l.buckets) != 0 {
+		t.Errorf("buckets after idle: got %d", len(l.buckets))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.limiter = newRateLimiter(&rateLimitConfig{rateLimit: rateLimit{Rate: 0.5, Burst: 1}})
+	h := s.rateLimit(mux, mux, rateLimitAddress)
+
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v0/rooms", nil))
+		return rec
+	}
+	if rec := do(); rec.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", rec.Code)
+	}
+	rec := do()
+	var resp ServerResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" ||
+		resp.Ctrl.Code != http.StatusTooManyRequests || resp.Ctrl.Params["retry_after"] != 2.0 {
+		t.Errorf("over limit: got %d, Retry-After %q, %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+}
+
+func TestRateLimitUnauthenticated(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.cfg.Auth.Enabled = true
+	s.cfg.Listeners = []listenerConfig{{Addr: "127.0.0.1:0", Routes: []string{routeAPI}}}
+	s.limiter = newRateLimiter(&rateLimitConfig{rateLimit: rateLimit{Rate: 0.5, Burst: 1}})
+	listeners, err := s.listeners(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// calls without credentials spend the bucket of their address.
+	for i, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		listeners[0].handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v0/rooms", nil))
+		if rec.Code != want {
+			t.Errorf("request %d: got %d, want %d", i, rec.Code, want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v0/rooms", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	listeners[0].handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("other address: got %d", rec.Code)
+	}
+}
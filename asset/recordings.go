@@ -0,0 +1,393 @@
+package asset
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const (
+	// defaultSegmentDuration is the duration of HLS segments sls records,
+	// see `record_hls_segment_duration` of the hub.
+	defaultSegmentDuration = 10 * time.Second
+
+	// maxSegmentGap is the largest gap between segments still played without a discontinuity.
+	maxSegmentGap = time.Second
+
+	// segmentExt is the file extension of recorded HLS segments.
+	segmentExt = ".ts"
+
+	// mimeM3U8 is the content type of HLS playlists.
+	mimeM3U8 = "application/vnd.apple.mpegurl"
+)
+
+// segment is a recorded HLS segment of a stream.
+type segment struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"` // seconds
+	Size     int64     `json:"size"`
+	// URL is where the segment is served over HTTP by the hub, if known.
+	URL string `json:"url,omitempty"`
+}
+
+// Field returns the value of a field segments are listed by, the name is the ID.
+func (sg *segment) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return sg.Name, true
+	case "start":
+		return types.FormatFieldTime(sg.Start), true
+	}
+	return "", false
+}
+
+// end returns when the segment ends.
+func (sg *segment) end() time.Time {
+	return sg.Start.Add(time.Duration(sg.Duration * float64(time.Second)))
+}
+
+// recordingResp summarizes recordings of a stream in a time window.
+type recordingResp struct {
+	StreamID string           `json:"stream_id"`
+	Key      string           `json:"key"`
+	Type     types.StreamType `json:"type"`
+	Start    *time.Time       `json:"start,omitempty"`
+	End      *time.Time       `json:"end,omitempty"`
+	Duration float64          `json:"duration"` // seconds
+	Segments int              `json:"segments"`
+}
+
+// streamRecordingsResp lists recorded segments of a stream.
+type streamRecordingsResp struct {
+	Recording recordingResp `json:"recording"`
+	Segments  []segment     `json:"segments"`
+}
+
+// timeWindow is a time range [From, To), either end is open if zero.
+type timeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseWindow reads `from` and `to` query parameters, either RFC 3339 time or Unix seconds.
+func parseWindow(req *http.Request) (timeWindow, error) {
+	var w timeWindow
+	var err error
+	q := req.URL.Query()
+	if w.From, err = parseTimeParam(q.Get("from")); err != nil {
+		return w, err
+	}
+	if w.To, err = parseTimeParam(q.Get("to")); err != nil {
+		return w, err
+	}
+	if !w.From.IsZero() && !w.To.IsZero() && !w.From.Before(w.To) {
+		return w, types.ErrMalformed
+	}
+	return w, nil
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, types.ErrMalformed
+	}
+	return t.UTC(), nil
+}
+
+// overlaps checks if the segment has any part inside the window.
+func (w timeWindow) overlaps(sg *segment) bool {
+	return (w.From.IsZero() || sg.end().After(w.From)) && (w.To.IsZero() || sg.Start.Before(w.To))
+}
+
+// recordPath is the directory sls records HLS of the stream into.
+func (hc *hubConfig) recordPath(key string) string {
+	return filepath.Join(hc.HLSPath, filepath.FromSlash(hc.publishPath(key)))
+}
+
+// segmentURL is where the hub serves a recorded segment, if known.
+func (hc *hubConfig) segmentURL(key, name string) string {
+	if hc.HLSURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(hc.HLSURL, "/") + "/" + path.Join(hc.publishPath(key), name)
+}
+
+// segmentStart derives start time from the segment name, sls names segments by
+// Unix time in seconds or milliseconds.
+func segmentStart(name string) (time.Time, bool) {
+	ts, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+	if err != nil || ts <= 0 {
+		return time.Time{}, false
+	}
+	if ts >= 1e12 {
+		return time.Unix(0, ts*int64(time.Millisecond)).UTC(), true
+	}
+	return time.Unix(ts, 0).UTC(), true
+}
+
+// readDurations collects segment durations from playlists in the directory.
+func readDurations(dir string, files []os.FileInfo) map[string]float64 {
+	durations := make(map[string]float64)
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".m3u8" {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			logger.Warnf("recordings: Failed to read playlist, %v", err)
+			continue
+		}
+		var extinf float64
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case strings.HasPrefix(line, "#EXTINF:"):
+				v := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+				extinf, _ = strconv.ParseFloat(v, 64)
+			case line != "" && !strings.HasPrefix(line, "#"):
+				if extinf > 0 {
+					durations[path.Base(line)] = extinf
+				}
+				extinf = 0
+			}
+		}
+		f.Close()
+	}
+	return durations
+}
+
+// scanSegments indexes recorded segments of the stream in order of time.
+func (hc *hubConfig) scanSegments(key string) ([]segment, error) {
+	dir := hc.recordPath(key)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	durations := readDurations(dir, files)
+	var segments []segment
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != segmentExt {
+			continue
+		}
+		sg := segment{Name: fi.Name(), Size: fi.Size(), URL: hc.segmentURL(key, fi.Name())}
+		if d, ok := durations[sg.Name]; ok {
+			sg.Duration = d
+		}
+		if start, ok := segmentStart(sg.Name); ok {
+			sg.Start = start
+		} else {
+			// segment files are last written when they are complete.
+			d := time.Duration(sg.Duration * float64(time.Second))
+			if d == 0 {
+				d = defaultSegmentDuration
+			}
+			sg.Start = fi.ModTime().Add(-d).UTC()
+		}
+		segments = append(segments, sg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start.Before(segments[j].Start) })
+
+	// segments not found in any playlist last until the next one starts.
+	for i := range segments {
+		if segments[i].Duration > 0 {
+			continue
+		}
+		d := defaultSegmentDuration
+		if i+1 < len(segments) {
+			if gap := segments[i+1].Start.Sub(segments[i].Start); gap > 0 && gap < d {
+				d = gap
+			}
+		}
+		segments[i].Duration = d.Seconds()
+	}
+	return segments, nil
+}
+
+// findSegments returns recorded segments of the stream inside the window.
+func (s *Server) findSegments(key string, w timeWindow) ([]segment, error) {
+	all, err := s.cfg.Hub.scanSegments(key)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]segment, 0, len(all))
+	for i := range all {
+		if w.overlaps(&all[i]) {
+			segments = append(segments, all[i])
+		}
+	}
+	return segments, nil
+}
+
+func newRecordingResp(st *types.Stream, segments []segment) recordingResp {
+	resp := recordingResp{StreamID: st.ID, Key: st.Key, Type: st.Type, Segments: len(segments)}
+	if len(segments) == 0 {
+		return resp
+	}
+	start, end := segments[0].Start, segments[len(segments)-1].end()
+	resp.Start, resp.End = &start, &end
+	for _, sg := range segments {
+		resp.Duration += sg.Duration
+	}
+	return resp
+}
+
+// vodPlaylist renders a VOD playlist of the segments, with segment URIs relative to the playlist.
+func vodPlaylist(segments []segment) string {
+	target := 0.0
+	for _, sg := range segments {
+		target = math.Max(target, sg.Duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for i, sg := range segments {
+		if i > 0 && sg.Start.Sub(segments[i-1].end()) > maxSegmentGap {
+			// recording was interrupted, e.g. the publisher reconnected.
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", sg.Start.Format("2006-01-02T15:04:05.000Z07:00"))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", sg.Duration)
+		fmt.Fprintf(&b, "recordings/%s\n", sg.Name)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// roomRecordings summarizes recordings of all streams of the room.
+func (s *Server) roomRecordings(wrt http.ResponseWriter, req *http.Request, roomID string, now time.Time) {
+	if req.Method != http.MethodGet {
+		writeResp(wrt, ErrOperationNotAllowed(now))
+		return
+	}
+	w, err := parseWindow(req)
+	if err != nil {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	if _, err := s.store.RoomGet(roomID); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	streams, err := s.store.StreamList(types.Where("room_id", roomID))
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+
+	resp := make([]recordingResp, 0, len(streams))
+	for i := range streams {
+		segments, err := s.findSegments(streams[i].Key, w)
+		if err != nil {
+			logger.Warnf("recordings: Failed to index stream '%s', %v", streams[i].Key, err)
+			writeResp(wrt, ErrInternal(now))
+			return
+		}
+		resp = append(resp, newRecordingResp(&streams[i], segments))
+	}
+	writeResp(wrt, NoErr(now, resp))
+}
+
+// segmentListSpec pages recorded segments of a stream. Segments are files of the
+// hub rather than entities of the store, `from` and `to` select the recording window.
+var segmentListSpec = &listSpec{sorts: []string{"start", "id"}}
+
+// streamRecordings serves `recordings` segment list, `recordings.m3u8` VOD playlist
+// and `recordings/{name}` segment files of a stream.
+func (s *Server) streamRecordings(wrt http.ResponseWriter, req *http.Request, roomID, id string, parts []string, now time.Time) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeResp(wrt, ErrOperationNotAllowed(now))
+		return
+	}
+	st, err := s.roomStream(roomID, id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+
+	if parts[0] == "recordings" && len(parts) == 2 {
+		name := parts[1]
+		if filepath.Ext(name) != segmentExt || filepath.Base(name) != name {
+			writeResp(wrt, ErrNotFound(now))
+			return
+		}
+		file := filepath.Join(s.cfg.Hub.recordPath(st.Key), name)
+		if _, err := os.Stat(file); err != nil {
+			writeResp(wrt, ErrNotFound(now))
+			return
+		}
+		http.ServeFile(wrt, req, file)
+		return
+	}
+
+	w, err := parseWindow(req)
+	if err != nil {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	var q *types.ListQuery
+	if parts[0] == "recordings" {
+		if q, err = segmentListSpec.parse(req); err != nil {
+			writeResp(wrt, errMalformed(err, now))
+			return
+		}
+	}
+	segments, err := s.findSegments(st.Key, w)
+	if err != nil {
+		logger.Warnf("recordings: Failed to index stream '%s', %v", st.Key, err)
+		writeResp(wrt, ErrInternal(now))
+		return
+	}
+
+	if q != nil {
+		// the summary covers the whole window, segments are paged.
+		listed := make([]segment, 0)
+		for _, i := range q.Select(len(segments), func(i int) types.Listed { return &segments[i] }) {
+			listed = append(listed, segments[i])
+		}
+		n, next := page(q, len(listed), func(i int) types.Listed { return &listed[i] })
+		writeResp(wrt, listResp(now, streamRecordingsResp{
+			Recording: newRecordingResp(st, segments),
+			Segments:  listed[:n],
+		}, next))
+		return
+	}
+
+	if len(segments) == 0 {
+		writeResp(wrt, ErrNotFound(now))
+		return
+	}
+	wrt.Header().Set("Content-Type", mimeM3U8)
+	wrt.Header().Set("Cache-Control", "no-cache")
+	wrt.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		fmt.Fprint(wrt, vodPlaylist(segments))
+	}
+}
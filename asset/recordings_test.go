@@ -0,0 +1,98 @@
+package asset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordingsAPI(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Hub = hubConfig{Domain: "live.example.com", HLSPath: t.TempDir()}
+
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01", "streams": []string{"dev"}})
+	if code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+	var room roomResp
+	if err := json.Unmarshal(data, &room); err != nil {
+		t.Fatal(err)
+	}
+	streamURL := "/api/v0/rooms/room01/streams/" + room.Streams[0].ID
+
+	// three segments, recording stops for 20s after the second one.
+	dir := s.cfg.Hub.recordPath("room01_dev")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"1600000000.ts":   "seg0",
+		"1600000010.ts":   "seg1",
+		"1600000040.ts":   "seg2",
+		"room01_dev.m3u8": "#EXTM3U\n#EXTINF:10.000,\n1600000000.ts\n#EXTINF:9.500,\n1600000010.ts\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room01/recordings", nil)
+	var recs []recordingResp
+	if err := json.Unmarshal(data, &recs); err != nil || code != http.StatusOK {
+		t.Fatalf("room recordings: got %d, %s", code, data)
+	}
+	if len(recs) != 1 || recs[0].Segments != 3 || recs[0].Duration != 29.5 || recs[0].Start.Unix() != 1600000000 {
+		t.Errorf("room recordings: got %s", data)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, streamURL+"/recordings?from=1600000015&to=2020-09-13T12:27:25Z", nil)
+	var list struct {
+		Segments []segment `json:"segments"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil || code != http.StatusOK {
+		t.Fatalf("stream recordings: got %d, %s", code, data)
+	}
+	if len(list.Segments) != 2 || list.Segments[0].Name != "1600000010.ts" || list.Segments[1].Duration != 10 {
+		t.Errorf("stream recordings: got %s", data)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, streamURL+"/recordings?from=yesterday", nil); code != http.StatusBadRequest {
+		t.Errorf("bad window: got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, streamURL+"/recordings.m3u8?from=1600000005", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mimeM3U8 {
+		t.Fatalf("playlist: got %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	want := []string{
+		"#EXT-X-PLAYLIST-TYPE:VOD",
+		"#EXT-X-TARGETDURATION:10",
+		"#EXTINF:10.000,\nrecordings/1600000000.ts",
+		"#EXTINF:9.500,\nrecordings/1600000010.ts",
+		"#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2020-09-13T12:27:20.000Z",
+		"#EXT-X-ENDLIST",
+	}
+	for _, w := range want {
+		if !strings.Contains(rec.Body.String(), w) {
+			t.Errorf("playlist is missing %q:\n%s", w, rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, streamURL+"/recordings/1600000040.ts", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "seg2" {
+		t.Errorf("segment: got %d, %q", rec.Code, rec.Body.String())
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, streamURL+"/recordings/room01_dev.m3u8", nil); code != http.StatusNotFound {
+		t.Errorf("non-segment file: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, streamURL+"/recordings.m3u8?from=1700000000", nil); code != http.StatusNotFound {
+		t.Errorf("empty window: got %d", code)
+	}
+}
@@ -0,0 +1,380 @@
+package asset
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// defaultRetentionInterval is how often recordings are checked if not configured.
+const defaultRetentionInterval = 10 * time.Minute
+
+// retentionStreamBatch is how many streams a sweep reads from storage at once.
+const retentionStreamBatch = 200
+
+// byteSize is an amount of bytes, written in YAML as a number with an optional
+// K, M, G or T suffix, e.g. "500G".
+type byteSize int64
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (b *byteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v string
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = n
+	return nil
+}
+
+func parseByteSize(v string) (byteSize, error) {
+	v = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "B")
+	if v == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	switch v[len(v)-1] {
+	case 'K':
+		unit = 1 << 10
+	case 'M':
+		unit = 1 << 20
+	case 'G':
+		unit = 1 << 30
+	case 'T':
+		unit = 1 << 40
+	}
+	if unit > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return byteSize(n * unit), nil
+}
+
+// retentionConfig limits how long recordings are kept and how much disk they take.
+// Recordings under a legal hold are never deleted.
+type retentionConfig struct {
+	// Interval is how often recordings are checked.
+	Interval time.Duration `yaml:"interval"`
+	// MaxAge is how long recordings are kept, forever if zero.
+	MaxAge time.Duration `yaml:"max_age"`
+	// Rooms overrides MaxAge by room ID, zero keeps recordings of the room forever.
+	Rooms map[string]time.Duration `yaml:"rooms"`
+	// Quota limits disk space taken by all recordings, oldest are deleted first
+	// to stay below it. Unlimited if zero.
+	Quota byteSize `yaml:"quota"`
+}
+
+// enabled checks if any limit is set.
+func (rc *retentionConfig) enabled() bool {
+	return rc.MaxAge > 0 || len(rc.Rooms) > 0 || rc.Quota > 0
+}
+
+// maxAge returns how long recordings of the room are kept, forever if zero.
+func (rc *retentionConfig) maxAge(roomID string) time.Duration {
+	if age, ok := rc.Rooms[roomID]; ok {
+		return age
+	}
+	return rc.MaxAge
+}
+
+// retentionManager deletes recordings which are too old or don't fit the quota.
+// Only recordings of known streams are managed.
+type retentionManager struct {
+	cfg   *retentionConfig
+	hub   *hubConfig
+	store storage.Adapter
+}
+
+func newRetentionManager(cfg *retentionConfig, hub *hubConfig, store storage.Adapter) *retentionManager {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRetentionInterval
+	}
+	return &retentionManager{cfg: cfg, hub: hub, store: store}
+}
+
+// deletedSegment is the audit record of a segment deleted by retention.
+type deletedSegment struct {
+	StreamID string    `json:"stream_id"`
+	Key      string    `json:"key"`
+	Path     string    `json:"path"`
+	Start    time.Time `json:"start"`
+	Size     int64     `json:"size"`
+	Reason   string    `json:"reason"`
+}
+
+// recordedSegment is a segment file considered for deletion.
+type recordedSegment struct {
+	segment
+	stream *types.Stream
+}
+
+// sweepResult sums up one pass over the recordings.
+type sweepResult struct {
+	Deleted      int   `json:"deleted"`
+	DeletedBytes int64 `json:"deleted_bytes"`
+	Held         int   `json:"held"`
+	UsedBytes    int64 `json:"used_bytes"`
+}
+
+// held checks if any of the holds covers the segment.
+func held(holds []types.Hold, sg *segment) bool {
+	for i := range holds {
+		w := timeWindow{}
+		if holds[i].From != nil {
+			w.From = *holds[i].From
+		}
+		if holds[i].To != nil {
+			w.To = *holds[i].To
+		}
+		if w.overlaps(sg) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepStream deletes expired recordings of the stream and returns the segments
+// which may be deleted to fit into the quota.
+func (m *retentionManager) sweepStream(st *types.Stream, holds []types.Hold, now time.Time, res *sweepResult) []recordedSegment {
+	segments, err := m.hub.scanSegments(st.Key)
+	if err != nil {
+		logger.Warnf("retention: Failed to index stream '%s', %v", st.Key, err)
+		return nil
+	}
+	var evictable []recordedSegment
+	maxAge := m.cfg.maxAge(st.RoomID)
+	for j := range segments {
+		sg := recordedSegment{segment: segments[j], stream: st}
+		if j == len(segments)-1 {
+			res.UsedBytes += sg.Size
+			continue
+		}
+		if held(holds, &sg.segment) {
+			res.Held++
+			res.UsedBytes += sg.Size
+			continue
+		}
+		if maxAge > 0 && sg.end().Before(now.Add(-maxAge)) {
+			m.delete(&sg, "expired", res)
+			continue
+		}
+		res.UsedBytes += sg.Size
+		evictable = append(evictable, sg)
+	}
+	return evictable
+}
+
+// sweep deletes recordings older than the maximum age of their room, then the
+// oldest ones until all fit into the quota. The latest segment of every stream
+// may still be written and is always kept.
+func (m *retentionManager) sweep(now time.Time) (*sweepResult, error) {
+	res := &sweepResult{}
+	var evictable []recordedSegment
+
+	// streams are read in batches with their holds, the quota needs segments of all
+	// of them, which are on disk rather than in storage.
+	q := &types.ListQuery{Limit: retentionStreamBatch}
+	for {
+		streams, err := m.store.StreamList(q)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(streams))
+		for i := range streams {
+			ids[i] = streams[i].ID
+		}
+		list, err := m.store.HoldList(&types.ListQuery{In: map[string][]string{"stream_id": ids}})
+		if err != nil {
+			return nil, err
+		}
+		holds := make(map[string][]types.Hold)
+		for _, h := range list {
+			holds[h.StreamID] = append(holds[h.StreamID], h)
+		}
+
+		for i := range streams {
+			evictable = append(evictable, m.sweepStream(&streams[i], holds[streams[i].ID], now, res)...)
+		}
+		if len(streams) < q.Limit {
+			break
+		}
+		q.After = q.CursorOf(&streams[len(streams)-1])
+	}
+
+	if quota := int64(m.cfg.Quota); quota > 0 && res.UsedBytes > quota {
+		sort.Slice(evictable, func(i, j int) bool { return evictable[i].Start.Before(evictable[j].Start) })
+		for i := 0; i < len(evictable) && res.UsedBytes > quota; i++ {
+			if m.delete(&evictable[i], "over quota", res) {
+				res.UsedBytes -= evictable[i].Size
+			}
+		}
+		if res.UsedBytes > quota {
+			logger.Warnf("retention: Recordings take %d bytes over quota of %d, the rest is held or being written",
+				res.UsedBytes, quota)
+		}
+	}
+
+	stats.Set("RecordingBytes", res.UsedBytes)
+	if res.Deleted > 0 {
+		logger.Infof("retention: Deleted %d segments, %d bytes, %d bytes left", res.Deleted, res.DeletedBytes, res.UsedBytes)
+	}
+	return res, nil
+}
+
+// delete removes the segment file and accounts for it.
+func (m *retentionManager) delete(sg *recordedSegment, reason string, res *sweepResult) bool {
+	file := filepath.Join(m.hub.recordPath(sg.stream.Key), sg.Name)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("retention: Failed to delete '%s', %v", file, err)
+		return false
+	}
+	res.Deleted++
+	res.DeletedBytes += sg.Size
+	stats.Inc("RecordingsDeleted", 1)
+	stats.Inc("RecordingBytesDeleted", int(sg.Size))
+	logger.Infof("retention: Deleted segment %s of '%s' started at %s, %s",
+		sg.Name, sg.stream.Key, sg.Start.Format(time.RFC3339), reason)
+	appendAudit(m.store, &types.AuditEntry{
+		ID:       types.NewID(),
+		Actor:    auditRetention,
+		Action:   types.AuditDelete,
+		Entity:   auditRecording,
+		EntityID: sg.stream.ID,
+		Before: auditDoc(&deletedSegment{StreamID: sg.stream.ID, Key: sg.stream.Key, Path: file,
+			Start: sg.Start, Size: sg.Size, Reason: reason}),
+		CreatedAt: types.TimeNow(),
+	})
+	return true
+}
+
+// run sweeps recordings periodically until stop is closed.
+func (m *retentionManager) run(stop <-chan bool) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := m.sweep(types.TimeNow()); err != nil {
+			logger.Warnf("retention: Sweep failed, %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// holdReq is the body of hold create requests, either end of the window is open if not set.
+type holdReq struct {
+	From   *time.Time `json:"from"`
+	To     *time.Time `json:"to"`
+	Reason string     `json:"reason"`
+}
+
+// streamHolds serves `holds` collection and `holds/{hid}` items of a stream. Only
+// services may place or release holds.
+func (s *Server) streamHolds(wrt http.ResponseWriter, req *http.Request, roomID, id string, parts []string, now time.Time) {
+	if !requireAPIKey(wrt, req, now) {
+		return
+	}
+	st, err := s.roomStream(roomID, id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch req.Method {
+		case http.MethodGet:
+			s.holdList(wrt, req, st, now)
+		case http.MethodPost:
+			s.holdCreate(wrt, req, st, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+		return
+	}
+
+	hold, err := s.store.HoldGet(parts[1])
+	if err == nil && hold.StreamID != st.ID {
+		err = types.ErrNotFound
+	}
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeResp(wrt, NoErr(now, hold))
+	case http.MethodDelete:
+		if err := s.store.HoldDelete(hold.ID, s.auditEntry(req, types.AuditDelete, auditHold, hold.ID, hold, nil, now)); err != nil {
+			writeResp(wrt, decodeStoreError(err, now))
+			return
+		}
+		logger.Infof("retention: Hold '%s' on '%s' released", hold.ID, st.Key)
+		writeResp(wrt, NoErr(now, nil))
+	default:
+		writeResp(wrt, ErrOperationNotAllowed(now))
+	}
+}
+
+// holdListSpec pages holds of a stream.
+var holdListSpec = &listSpec{
+	sorts:  []string{"id", "created_at"},
+	window: "created_at",
+}
+
+func (s *Server) holdList(wrt http.ResponseWriter, req *http.Request, st *types.Stream, now time.Time) {
+	q, err := holdListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	q.Filters = map[string]string{"stream_id": st.ID}
+	holds, err := s.store.HoldList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(holds), func(i int) types.Listed { return &holds[i] })
+	writeResp(wrt, listResp(now, holds[:n], next))
+}
+
+func (s *Server) holdCreate(wrt http.ResponseWriter, req *http.Request, st *types.Stream, now time.Time) {
+	var body holdReq
+	if err := decodeBody(req, holdCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	hold := &types.Hold{ID: types.NewID(), StreamID: st.ID, Reason: body.Reason, CreatedAt: now}
+	if body.From != nil {
+		from := body.From.UTC()
+		hold.From = &from
+	}
+	if body.To != nil {
+		to := body.To.UTC()
+		hold.To = &to
+	}
+	if err := s.store.HoldCreate(hold, s.auditEntry(req, types.AuditCreate, auditHold, hold.ID, nil, hold, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("retention: Hold '%s' placed on '%s'", hold.ID, st.Key)
+	writeResp(wrt, NoErrCreated(now, hold))
+}
@@ -0,0 +1,106 @@
+package asset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRetention(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Hub = hubConfig{Domain: "live.example.com", HLSPath: t.TempDir()}
+
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01", "streams": []string{"dev"}})
+	var room roomResp
+	if err := json.Unmarshal(data, &room); err != nil || code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+	holdsURL := "/api/v0/rooms/room01/streams/" + room.Streams[0].ID + "/holds"
+
+	// ten segments of 100 bytes, one every 10s.
+	dir := s.cfg.Hub.recordPath("room01_dev")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const base = 1600000000
+	for i := 0; i < 10; i++ {
+		name := strconv.Itoa(base+i*10) + segmentExt
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", 100)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the hold covers the second and third segments.
+	code, data = doRequest(t, mux, http.MethodPost, holdsURL, map[string]interface{}{
+		"from": time.Unix(base+15, 0), "to": time.Unix(base+25, 0), "reason": "case 42"})
+	if code != http.StatusCreated {
+		t.Fatalf("create hold: got %d, %s", code, data)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, holdsURL, map[string]interface{}{
+		"from": time.Unix(base+25, 0), "to": time.Unix(base+15, 0)}); code != http.StatusBadRequest {
+		t.Errorf("create hold with empty window: got %d", code)
+	}
+
+	// recordings older than a minute expire, the quota keeps 500 bytes.
+	cfg := &retentionConfig{MaxAge: time.Minute, Quota: 500}
+	m := newRetentionManager(cfg, &s.cfg.Hub, s.store)
+	res, err := m.sweep(time.Unix(base+110, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// expired: 0, 30; over quota: 40, 50, 60; held: 10, 20; kept: 70, 80, 90.
+	if res.Deleted != 5 || res.Held != 2 || res.UsedBytes != 500 {
+		t.Errorf("sweep: got %+v", res)
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(left) != 5 || filepath.Base(left[0]) != "1600000010.ts" || filepath.Base(left[2]) != "1600000070.ts" {
+		t.Errorf("left after sweep: %v", left)
+	}
+
+	// every deletion is in the audit trail.
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/audit?entity=recording&actor=retention", nil)
+	var trail []auditResp
+	if err := json.Unmarshal(data, &trail); err != nil || code != http.StatusOK || len(trail) != 5 {
+		t.Fatalf("audit of sweep: got %d, %s", code, data)
+	}
+	reasons := make(map[string]int)
+	for _, e := range trail {
+		var doc deletedSegment
+		if err := json.Unmarshal(e.Before, &doc); err != nil || e.EntityID != room.Streams[0].ID || e.Action != "delete" ||
+			!strings.HasPrefix(doc.Path, dir) || doc.Start.IsZero() {
+			t.Errorf("audit entry: got %+v, %s", e.AuditEntry, e.Before)
+		}
+		reasons[doc.Reason]++
+	}
+	if reasons["expired"] != 2 || reasons["over quota"] != 3 {
+		t.Errorf("audit reasons: got %v", reasons)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, holdsURL, nil)
+	var holds []json.RawMessage
+	if err := json.Unmarshal(data, &holds); err != nil || code != http.StatusOK || len(holds) != 1 {
+		t.Fatalf("list holds: got %d, %s", code, data)
+	}
+}
+
+func TestRetentionConfig(t *testing.T) {
+	var cfg retentionConfig
+	err := yaml.Unmarshal([]byte("max_age: 720h\nrooms:\n  room01: 24h\n  room02: 0s\nquota: 50G\n"), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Quota != 50<<30 || cfg.maxAge("room01") != 24*time.Hour || cfg.maxAge("room02") != 0 || cfg.maxAge("room03") != 720*time.Hour {
+		t.Errorf("config: got %+v", cfg)
+	}
+	if err := yaml.Unmarshal([]byte("quota: lots\n"), &cfg); err == nil {
+		t.Error("invalid quota accepted")
+	}
+}
@@ -0,0 +1,243 @@
+package asset
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// defaultStreamTypes are the streams every clinic room has unless told otherwise.
+var defaultStreamTypes = []types.StreamType{types.StreamDevice, types.StreamCamera}
+
+// roomReq is the body of room create and update requests.
+type roomReq struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Streams lists types of streams to create along with the room.
+	Streams []types.StreamType `json:"streams"`
+}
+
+// roomUpdateReq is the body of room update requests, the name is kept if absent.
+type roomUpdateReq struct {
+	ID   string  `json:"id"`
+	Name *string `json:"name"`
+}
+
+// roomResp is a room with its streams.
+type roomResp struct {
+	*types.Room
+	// Live is set if any stream of the room is publishing.
+	Live    bool         `json:"live"`
+	Streams []streamResp `json:"streams"`
+}
+
+func newRoomResp(room *types.Room, streams []streamResp) roomResp {
+	resp := roomResp{Room: room, Streams: streams}
+	for _, st := range streams {
+		if st.Status != nil && st.Status.Online {
+			resp.Live = true
+		}
+	}
+	return resp
+}
+
+// defaultStreamKey builds stream name following `{room}_{type}` convention of mock streams.
+func defaultStreamKey(roomID string, typ types.StreamType) string {
+	return roomID + "_" + string(typ)
+}
+
+// roomsHandler serves `v0/rooms` collection, `v0/rooms/{id}` items and
+// `v0/rooms/{id}/recordings`, and passes `v0/rooms/{id}/streams` on to streamsHandler.
+func (s *Server) roomsHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+
+	rest := strings.TrimPrefix(req.URL.Path, s.cfg.APIPath+"v0/rooms")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		switch req.Method {
+		case http.MethodGet:
+			s.roomList(wrt, req, now)
+		case http.MethodPost:
+			s.roomCreate(wrt, req, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 1:
+		switch req.Method {
+		case http.MethodGet:
+			s.roomGet(wrt, parts[0], now)
+		case http.MethodPut, http.MethodPatch:
+			s.roomUpdate(wrt, req, parts[0], now)
+		case http.MethodDelete:
+			s.roomDelete(wrt, req, parts[0], now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case parts[1] == "streams":
+		s.streamsHandler(wrt, req, parts[0], parts[2:], now)
+
+	case len(parts) == 2 && parts[1] == "recordings":
+		s.roomRecordings(wrt, req, parts[0], now)
+
+	default:
+		writeResp(wrt, ErrNotFound(now))
+	}
+}
+
+// roomListSpec filters rooms by name and creation time.
+var roomListSpec = &listSpec{
+	filters: map[string]string{"name": "name"},
+	sorts:   []string{"id", "name", "created_at", "updated_at"},
+	window:  "created_at",
+}
+
+func (s *Server) roomList(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	q, err := roomListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	rooms, err := s.store.RoomList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(rooms), func(i int) types.Listed { return &rooms[i] })
+	rooms = rooms[:n]
+	ids := make([]string, len(rooms))
+	for i := range rooms {
+		ids[i] = rooms[i].ID
+	}
+	streams, err := s.store.StreamList(&types.ListQuery{In: map[string][]string{"room_id": ids}})
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+
+	byRoom := make(map[string][]types.Stream)
+	for _, st := range streams {
+		byRoom[st.RoomID] = append(byRoom[st.RoomID], st)
+	}
+	resp := make([]roomResp, 0, len(rooms))
+	for i := range rooms {
+		resp = append(resp, newRoomResp(&rooms[i], s.newStreamsResp(byRoom[rooms[i].ID])))
+	}
+	writeResp(wrt, listResp(now, resp, next))
+}
+
+func (s *Server) roomCreate(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	var body roomReq
+	if err := decodeBody(req, roomCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.Streams == nil {
+		body.Streams = defaultStreamTypes
+	}
+
+	room := &types.Room{ID: body.ID, Name: body.Name, CreatedAt: now, UpdatedAt: now}
+	var streams []*types.Stream
+	for _, typ := range body.Streams {
+		if !typ.IsValid() {
+			writeResp(wrt, ErrMalformed(now))
+			return
+		}
+		streams = append(streams, &types.Stream{
+			ID:        types.NewID(),
+			RoomID:    room.ID,
+			Type:      typ,
+			Key:       defaultStreamKey(room.ID, typ),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	audit := []*types.AuditEntry{s.auditEntry(req, types.AuditCreate, auditRoom, room.ID, nil, room, now)}
+	for _, st := range streams {
+		audit = append(audit, s.auditEntry(req, types.AuditCreate, auditStream, st.ID, nil, st, now))
+	}
+	if err := s.store.RoomCreate(room, streams, audit...); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("rooms: Room '%s' created with %d stream(s)", room.ID, len(streams))
+
+	resp := make([]streamResp, 0, len(streams))
+	for _, st := range streams {
+		resp = append(resp, s.newStreamResp(st))
+	}
+	writeResp(wrt, NoErrCreated(now, newRoomResp(room, resp)))
+}
+
+func (s *Server) roomGet(wrt http.ResponseWriter, id string, now time.Time) {
+	room, err := s.store.RoomGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	streams, err := s.store.StreamList(types.Where("room_id", id))
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, newRoomResp(room, s.newStreamsResp(streams))))
+}
+
+func (s *Server) roomUpdate(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	var body roomUpdateReq
+	if err := decodeBody(req, roomUpdateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.ID != "" && body.ID != id {
+		writeResp(wrt, ErrMalformed(now).WithParam("errors", []fieldError{{Field: "id", Reason: "must be the ID of the room"}}))
+		return
+	}
+
+	room, err := s.store.RoomGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	before := *room
+	if body.Name != nil {
+		room.Name = *body.Name
+	}
+	room.UpdatedAt = now
+	if err := s.store.RoomUpdate(room, s.auditEntry(req, types.AuditUpdate, auditRoom, id, &before, room, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, room))
+}
+
+func (s *Server) roomDelete(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	room, err := s.store.RoomGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	streams, err := s.store.StreamList(types.Where("room_id", id))
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	// streams are deleted along with the room.
+	audit := []*types.AuditEntry{s.auditEntry(req, types.AuditDelete, auditRoom, id, room, nil, now)}
+	for i := range streams {
+		audit = append(audit, s.auditEntry(req, types.AuditDelete, auditStream, streams[i].ID, &streams[i], nil, now))
+	}
+	if err := s.store.RoomDelete(id, audit...); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("rooms: Room '%s' deleted", id)
+	writeResp(wrt, NoErr(now, nil))
+}
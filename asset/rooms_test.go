@@ -0,0 +1,106 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/memory"
+)
+
+// newTestServer returns a server backed by in-memory storage together with its API mux.
+func newTestServer(t *testing.T) (*Server, *http.ServeMux) {
+	store := memory.NewAdapter()
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	s := &Server{cfg: &Config{APIPath: "/api/"}, store: store}
+	s.devices = newDeviceMonitor(store, time.Minute)
+	mux := http.NewServeMux()
+	s.serveAPI(mux)
+	return s, mux
+}
+
+// doRequest performs an API call and decodes the response envelope, with data left raw.
+func doRequest(t *testing.T, h http.Handler, method, url string, body interface{}) (int, json.RawMessage) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, url, &buf)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp struct {
+		Ctrl *ServerCtrlResp `json:"ctrl"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: invalid response %q, %v", method, url, rec.Body.String(), err)
+	}
+	if resp.Ctrl == nil || resp.Ctrl.Code != rec.Code {
+		t.Fatalf("%s %s: ctrl %+v does not match status %d", method, url, resp.Ctrl, rec.Code)
+	}
+	return rec.Code, resp.Data
+}
+
+func TestRoomsAPI(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01", "name": "Room 01"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: got %d, %s", code, data)
+	}
+	var room roomResp
+	if err := json.Unmarshal(data, &room); err != nil {
+		t.Fatal(err)
+	}
+	if room.ID != "room01" || len(room.Streams) != 2 {
+		t.Errorf("create: got %s", data)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]string{"id": "room01"}); code != http.StatusConflict {
+		t.Errorf("duplicate create: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]string{"id": "bad id"}); code != http.StatusBadRequest {
+		t.Errorf("create with bad id: got %d", code)
+	}
+
+	code, data = doRequest(t, mux, http.MethodPut, "/api/v0/rooms/room01", map[string]string{"name": "Exam"})
+	if code != http.StatusOK {
+		t.Fatalf("update: got %d, %s", code, data)
+	}
+	// the name is kept if the update leaves it out.
+	code, data = doRequest(t, mux, http.MethodPatch, "/api/v0/rooms/room01", map[string]string{"id": "room01"})
+	if code != http.StatusOK {
+		t.Fatalf("update without name: got %d, %s", code, data)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/rooms", nil)
+	var rooms []roomResp
+	if err := json.Unmarshal(data, &rooms); err != nil || code != http.StatusOK {
+		t.Fatalf("list: got %d, %s", code, data)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "Exam" || len(rooms[0].Streams) != 2 {
+		t.Errorf("list: got %s", data)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodDelete, "/api/v0/rooms/room01", nil); code != http.StatusOK {
+		t.Errorf("delete: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room01", nil); code != http.StatusNotFound {
+		t.Errorf("get deleted: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms/room01", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("post to item: got %d", code)
+	}
+}
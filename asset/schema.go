@@ -0,0 +1,318 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// schema is the subset of OpenAPI schema objects the API needs. Request bodies are
+// validated against the same schemas the OpenAPI document describes.
+type schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	MinLength   int                `json:"minLength,omitempty"`
+	MaxLength   int                `json:"maxLength,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	Items       *schema            `json:"items,omitempty"`
+	Properties  map[string]*schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+
+	once    sync.Once
+	pattern *regexp.Regexp
+}
+
+// fieldError tells what is wrong with a field of a request body.
+type fieldError struct {
+	// Field is the path of the field, e.g. `streams[1]`, empty for the whole body.
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// validationError is a request body which does not match its schema.
+type validationError []fieldError
+
+func (ve validationError) Error() string {
+	reasons := make([]string, len(ve))
+	for i, fe := range ve {
+		reasons[i] = strings.TrimSpace(fe.Field + " " + fe.Reason)
+	}
+	return "invalid request body: " + strings.Join(reasons, ", ")
+}
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+// decodeBody reads a JSON request body into v. The body is validated against the
+// schema first, if given, a validationError lists the fields which don't match.
+func decodeBody(req *http.Request, sc *schema, v interface{}) error {
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	if sc != nil {
+		var raw interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return validationError{{Reason: "must be a JSON document"}}
+		}
+		var errs validationError
+		sc.validate(raw, "", &errs)
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// errMalformed replies to a request body which failed to decode, with field
+// errors if the body didn't match its schema.
+func errMalformed(err error, ts time.Time) *ServerResp {
+	resp := ErrMalformed(ts)
+	if ve, ok := err.(validationError); ok {
+		resp.WithParam("errors", []fieldError(ve))
+	}
+	return resp
+}
+
+func fieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// validate appends errors of the value decoded with json.Number to errs.
+func (sc *schema) validate(v interface{}, field string, errs *validationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, fieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+	if v == nil {
+		if !sc.Nullable {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch sc.Type {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		sc.validateString(s, fail)
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := n.Float64()
+		if sc.Type == "integer" {
+			if _, err = strconv.ParseInt(n.String(), 10, 64); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if sc.Minimum != nil && f < *sc.Minimum {
+			fail("must be at least %v", *sc.Minimum)
+		}
+		if sc.Maximum != nil && f > *sc.Maximum {
+			fail("must be at most %v", *sc.Maximum)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be a boolean")
+		}
+
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if sc.Items != nil {
+			for i, item := range list {
+				sc.Items.validate(item, fmt.Sprintf("%s[%d]", field, i), errs)
+			}
+		}
+
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				*errs = append(*errs, fieldError{Field: fieldPath(field, name), Reason: "is required"})
+			}
+		}
+		// properties are checked by name, so that errors come in a stable order.
+		for _, name := range sortedKeys(sc.Properties) {
+			value, ok := obj[name]
+			if !ok || value == nil && !sc.isRequired(name) {
+				continue
+			}
+			sc.Properties[name].validate(value, fieldPath(field, name), errs)
+		}
+	}
+}
+
+func (sc *schema) validateString(s string, fail func(string, ...interface{})) {
+	n := utf8.RuneCountInString(s)
+	if sc.MinLength > 0 && n < sc.MinLength {
+		fail("must be at least %d characters", sc.MinLength)
+	}
+	if sc.MaxLength > 0 && n > sc.MaxLength {
+		fail("must be at most %d characters", sc.MaxLength)
+	}
+	if sc.Pattern != "" {
+		sc.once.Do(func() { sc.pattern = regexp.MustCompile(sc.Pattern) })
+		if !sc.pattern.MatchString(s) {
+			fail("must match %s", sc.Pattern)
+		}
+	}
+	if len(sc.Enum) > 0 {
+		found := false
+		for _, e := range sc.Enum {
+			found = found || e == s
+		}
+		if !found {
+			fail("must be one of %s", strings.Join(sc.Enum, ", "))
+		}
+	}
+	switch sc.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			fail("must be an RFC 3339 date-time")
+		}
+	case "uri":
+		if u, err := url.Parse(s); err != nil || !u.IsAbs() || u.Host == "" {
+			fail("must be an absolute URI")
+		}
+	}
+}
+
+func (sc *schema) isRequired(name string) bool {
+	for _, r := range sc.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(props map[string]*schema) []string {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf describes a response type from its JSON encoding. Embedded structs are
+// flattened the way encoding/json does.
+func schemaOf(t reflect.Type) *schema {
+	if t == rawMessageType {
+		// any JSON document.
+		return &schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		sc := schemaOf(t.Elem())
+		sc.Nullable = true
+		return sc
+	case reflect.Struct:
+		if t == timeType {
+			return &schema{Type: "string", Format: "date-time"}
+		}
+		sc := &schema{Type: "object", Properties: make(map[string]*schema)}
+		addFields(sc, t)
+		return sc
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	}
+	// anything goes, e.g. interface{}.
+	return &schema{}
+}
+
+func addFields(sc *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(sc, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported.
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		sc.Properties[name] = schemaOf(ft)
+		if !strings.Contains(opts, "omitempty") {
+			sc.Required = append(sc.Required, name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package asset
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -8,14 +9,31 @@ import (
 	"strings"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/pkg/stats"
 	"github.com/dantin/media-hub/pkg/utils"
 )
 
-const defaultAPIPath = "/"
+const (
+	defaultAPIPath = "/"
+
+	// statsNamespace prefixes metric names of the asset server.
+	statsNamespace = "asset"
+)
 
 // Server encapsulates a HTTP server which provide asset related information.
 type Server struct {
-	cfg *Config
+	cfg     *Config
+	store   storage.Adapter
+	devices *deviceMonitor
+	live    *liveMonitor
+	// tokens signs bearer tokens, nil if tokens are disabled.
+	tokens *tokenSigner
+	events *eventBus
+	hooks  *webhookDispatcher
+	feed   *eventFeed
+	// limiter limits request rates of callers, nil if rates are not limited.
+	limiter *rateLimiter
 }
 
 // NewServer returns a runnable HTTP server using the given configuration.
@@ -25,6 +43,32 @@ func NewServer(cfg *Config) *Server {
 
 	cfg.PProfFile = utils.ToAbsolutePath(rootpath, cfg.PProfFile)
 	cfg.PIDFile = utils.ToAbsolutePath(rootpath, cfg.PIDFile)
+	if cfg.PProfSnapshots.Dir != "" {
+		cfg.PProfSnapshots.Dir = utils.ToAbsolutePath(rootpath, cfg.PProfSnapshots.Dir)
+	}
+
+	if cfg.Store == nil {
+		logger.Warnf("No storage configured, falling back to in-memory storage")
+		cfg.Store = &storage.Config{Type: storage.Memory}
+	}
+	if cfg.Hub.Config != "" {
+		cfg.Hub.Config = utils.ToAbsolutePath(rootpath, cfg.Hub.Config)
+	}
+	for i := range cfg.Listeners {
+		if path := strings.TrimPrefix(cfg.Listeners[i].Addr, unixPrefix); path != cfg.Listeners[i].Addr {
+			cfg.Listeners[i].Addr = unixPrefix + utils.ToAbsolutePath(rootpath, path)
+		}
+	}
+	if cfg.TLS.enabled() {
+		cfg.TLS.CertFile = utils.ToAbsolutePath(rootpath, cfg.TLS.CertFile)
+		cfg.TLS.KeyFile = utils.ToAbsolutePath(rootpath, cfg.TLS.KeyFile)
+		if cfg.TLS.ClientCA != "" {
+			cfg.TLS.ClientCA = utils.ToAbsolutePath(rootpath, cfg.TLS.ClientCA)
+		}
+	}
+	if cfg.Store.File != nil {
+		cfg.Store.File.Path = utils.ToAbsolutePath(rootpath, cfg.Store.File.Path)
+	}
 
 	// normalize API path.
 	if cfg.APIPath == "" {
@@ -47,14 +91,50 @@ func (s *Server) Run() error {
 		return err
 	}
 
-	// set up HTTP server. Must use non-default mux because of expvar.
-	mux := http.NewServeMux()
+	// follow SRT settings of the hub when building stream URLs.
+	if err := s.cfg.Hub.load(); err != nil {
+		return err
+	}
 
-	// exposing values for statistics and monitoring.
-	statsInit(mux, s.cfg.ExpvarPath)
+	tokens, err := newTokenSigner(&s.cfg.Auth)
+	if err != nil {
+		return err
+	}
+	s.tokens = tokens
+	if !s.cfg.Auth.Enabled {
+		logger.Warnf("Authentication is disabled, API is open to everyone")
+	}
 
-	// initialize serving debug profiles (optional).
-	servePprof(mux, s.cfg.PProfURL)
+	// open storage and make sure its schema is current.
+	if err := s.openStore(); err != nil {
+		return err
+	}
+	defer s.store.Close()
+
+	if err := storage.CheckDbVersion(s.store); err != nil {
+		return err
+	}
+
+	// collecting values for statistics and monitoring.
+	stats.Init(statsNamespace)
+	stats.RegisterGauge("DevicesOnline", "Devices which sent a heartbeat recently.")
+	stats.RegisterGauge("DevicesOffline", "Registered devices which are offline.")
+	stats.RegisterCounter("DeviceStateChanges", "Devices going online or offline.")
+	stats.RegisterCounter("DeviceHeartbeats", "Heartbeats received from devices.")
+	stats.RegisterGauge("StreamsLive", "Streams which are publishing.")
+	stats.RegisterCounter("StreamPublishes", "Streams starting to publish.")
+	stats.RegisterCounter("StatPollErrors", "Failed reads of sls statistics.")
+	stats.RegisterCounter("SessionsAllowed", "SRT sessions authorized.")
+	stats.RegisterCounter("SessionsRejected", "SRT sessions refused.")
+	stats.RegisterCounter("WebhookAttempts", "Webhook delivery attempts.")
+	stats.RegisterCounter("WebhookFailures", "Webhook deliveries given up after all attempts.")
+	stats.RegisterCounter("WebhookEventsDropped", "Events which failed to be queued for webhooks.")
+	stats.RegisterGauge("RecordingBytes", "Disk space taken by recordings.")
+	stats.RegisterCounter("RecordingsDeleted", "Recorded segments deleted by retention.")
+	stats.RegisterCounter("RecordingBytesDeleted", "Bytes of recordings deleted by retention.")
+	stats.RegisterCounter("AuditFailures", "Changes which failed to be recorded in the audit trail.")
+	registerHTTPStats()
+	stats.RegisterCounterVec("RateLimited", "Requests rejected over the rate limit by route group.", "group")
 
 	if s.cfg.PProfFile != "" {
 		cpuf, err := os.Create(s.cfg.PProfFile + ".cpu")
@@ -69,6 +149,9 @@ func (s *Server) Run() error {
 		}
 		defer memf.Close()
 
+		// the profiler is taken for the whole run, on-demand CPU profiles are refused.
+		acquireCPUProfiler("the -pprof flag")
+		defer releaseCPUProfiler()
 		pprof.StartCPUProfile(cpuf)
 		defer pprof.StopCPUProfile()
 		defer pprof.WriteHeapProfile(memf)
@@ -76,9 +159,138 @@ func (s *Server) Run() error {
 		logger.Infof("Profiling info saved to '%s.(cpu|mem)'", s.cfg.PProfFile)
 	}
 
+	done := make(chan bool)
+	defer close(done)
+
+	if s.cfg.PProfSnapshots.enabled() {
+		snapshots, err := newProfileSnapshotter(&s.cfg.PProfSnapshots)
+		if err != nil {
+			return err
+		}
+		go snapshots.run(done)
+		logger.Infof("pprof: Snapshots saved to '%s' every %v", s.cfg.PProfSnapshots.Dir, s.cfg.PProfSnapshots.Interval)
+	}
+
+	// deliver lifecycle events to webhooks and feed clients.
+	s.events = newEventBus()
+	s.hooks = newWebhookDispatcher(s.store)
+	s.events.subscribe(s.hooks.enqueue)
+	go s.hooks.run(done)
+	s.feed = newEventFeed(s.events.epoch)
+	s.events.subscribe(s.feed.publish)
+	defer s.feed.close()
+
+	// track device state in background until the server stops.
+	s.devices = newDeviceMonitor(s.store, s.cfg.DeviceTimeout)
+	s.devices.notify = s.deviceChanged
+	go s.devices.run(done)
+
+	// follow live state of streams if sls statistics are available.
+	if s.cfg.Hub.StatURL != "" {
+		s.live = newLiveMonitor(s.cfg.Hub.StatURL, s.cfg.Hub.StatInterval)
+		s.live.notify = s.streamChanged
+		go s.live.run(done)
+		logger.Infof("Polling stream statistics from '%s'", s.cfg.Hub.StatURL)
+	}
+
+	// keep recordings within retention limits.
+	if s.cfg.Retention.enabled() {
+		if s.cfg.Hub.HLSPath == "" {
+			logger.Warnf("Retention is configured but hub HLS path is unknown, recordings are kept forever")
+		} else {
+			go newRetentionManager(&s.cfg.Retention, &s.cfg.Hub, s.store).run(done)
+			logger.Infof("Enforcing retention of recordings in '%s'", s.cfg.Hub.HLSPath)
+		}
+	}
+
+	// serve HTTPS if certificates are configured.
+	var certs *certReloader
+	if s.cfg.TLS.enabled() {
+		var err error
+		if certs, err = newCertReloader(&s.cfg.TLS); err != nil {
+			return err
+		}
+		go certs.run(done)
+		logger.Infof("HTTPS uses certificate '%s'", s.cfg.TLS.CertFile)
+	} else if s.cfg.TLS.CertFile != "" || s.cfg.TLS.KeyFile != "" {
+		return fmt.Errorf("tls: both cert_file and key_file are required")
+	}
+
+	// limit request rates of callers.
+	if s.cfg.RateLimit.enabled() {
+		s.limiter = newRateLimiter(&s.cfg.RateLimit)
+		go s.limiter.run(done)
+	}
+
 	// configure root path for serving API calls.
 	logger.Infof("API served from root URL path '%s'", s.cfg.APIPath)
+	listeners, err := s.listeners(certs)
+	if err != nil {
+		return err
+	}
+
+	return listenAndServe(listeners, utils.SignalHandler())
+}
+
+// serveAPI registers API handlers under the configured API path.
+func (s *Server) serveAPI(mux *http.ServeMux) {
+	mux.HandleFunc(s.cfg.APIPath, notFound)
 	mux.HandleFunc(s.cfg.APIPath+"v0/index", index)
+	mux.HandleFunc(s.cfg.APIPath+"v0/openapi.json", s.openAPIHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/rooms", s.roomsHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/rooms/", s.roomsHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/devices", s.devicesHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/devices/", s.devicesHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/auth/", s.authHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/events", s.eventsHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/webhooks", s.webhooksHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/webhooks/", s.webhooksHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/sls/event", s.slsEventHandler)
+	mux.HandleFunc(s.cfg.APIPath+"v0/audit", s.auditHandler)
+}
+
+// InitDB initializes the database schema at the latest version.
+func (s *Server) InitDB() error {
+	if err := s.openStore(); err != nil {
+		return err
+	}
+	defer s.store.Close()
+
+	if err := s.store.CreateDb(false); err != nil {
+		return fmt.Errorf("fail to initialize %s database, %v", s.store.Name(), err)
+	}
+	logger.Infof("Database %s initialized at version %d", s.store.Name(), s.store.Version())
+	return nil
+}
+
+// UpgradeDB upgrades the database schema to the latest version.
+func (s *Server) UpgradeDB() error {
+	if err := s.openStore(); err != nil {
+		return err
+	}
+	defer s.store.Close()
+
+	from, err := s.store.GetDbVersion()
+	if err != nil {
+		return fmt.Errorf("fail to read %s database version, %v", s.store.Name(), err)
+	}
+	if err := s.store.UpgradeDb(); err != nil {
+		return fmt.Errorf("fail to upgrade %s database, %v", s.store.Name(), err)
+	}
+	logger.Infof("Database %s upgraded from version %d to %d", s.store.Name(), from, s.store.Version())
+	return nil
+}
+
+func (s *Server) openStore() error {
+	store, err := storage.NewAdapter(s.cfg.Store)
+	if err != nil {
+		return err
+	}
+	if err := store.Open(); err != nil {
+		return fmt.Errorf("fail to open %s storage, %v", store.Name(), err)
+	}
+	s.store = store
 
-	return listenAndServe(s.cfg.ListenAddr, mux, utils.SignalHandler())
+	logger.Infof("Storage %s opened", store.Name())
+	return nil
 }
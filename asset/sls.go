@@ -0,0 +1,169 @@
+package asset
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+// Events sls reports to `on_event_url`.
+const (
+	slsEventConnect = "on_connect"
+	slsEventClose   = "on_close"
+)
+
+// srtSession is a publish or play session sls asks to authorize.
+type srtSession struct {
+	Role   string
+	Domain string
+	App    string
+	Key    string
+	// Token is the play token passed as `?token=` in the stream ID.
+	Token string
+}
+
+// parseSRTURL splits the SRT stream ID, `{domain}/{app}/{key}[?token=...]`.
+func parseSRTURL(role, srtURL string) (*srtSession, error) {
+	streamID := srtURL
+	var query url.Values
+	if i := strings.IndexByte(srtURL, '?'); i >= 0 {
+		var err error
+		if query, err = url.ParseQuery(srtURL[i+1:]); err != nil {
+			return nil, types.ErrMalformed
+		}
+		streamID = srtURL[:i]
+	}
+	parts := strings.Split(streamID, "/")
+	if len(parts) != 3 || !keyPattern.MatchString(parts[2]) {
+		return nil, types.ErrMalformed
+	}
+	return &srtSession{Role: role, Domain: parts[0], App: parts[1], Key: parts[2], Token: query.Get("token")}, nil
+}
+
+// streamByKey finds the stream with the given key, keys are unique.
+func (s *Server) streamByKey(key string) (*types.Stream, error) {
+	streams, err := s.store.StreamList(&types.ListQuery{Filters: map[string]string{"key": key}, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, types.ErrNotFound
+	}
+	return &streams[0], nil
+}
+
+// eventAllowed checks if the caller of the event endpoint is allowed by `event_from`.
+func (hc *hubConfig) eventAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if len(hc.EventFrom) == 0 {
+		return ip.IsLoopback()
+	}
+	for _, from := range hc.EventFrom {
+		if _, ipnet, err := net.ParseCIDR(from); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(from)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize decides if the session may start. Publishers must use the key of a known
+// stream. Players must ask for a known stream, with a play token if `play_auth` is set.
+func (s *Server) authorize(sess *srtSession, now time.Time) *ServerResp {
+	if sess.App != srtApp {
+		return ErrNotFound(now).WithParam("reason", "unknown app")
+	}
+
+	switch sess.Role {
+	case slsRolePublisher:
+		if s.cfg.Hub.Domain != "" && sess.Domain != "up"+s.cfg.Hub.Domain {
+			return ErrNotFound(now).WithParam("reason", "unknown domain")
+		}
+	case slsRolePlayer:
+		if s.cfg.Hub.Domain != "" && sess.Domain != s.cfg.Hub.Domain {
+			return ErrNotFound(now).WithParam("reason", "unknown domain")
+		}
+	default:
+		return ErrPermissionDenied(now).WithParam("reason", "role not allowed")
+	}
+
+	if _, err := s.streamByKey(sess.Key); err != nil {
+		return decodeStoreError(err, now)
+	}
+
+	if sess.Role == slsRolePlayer && s.cfg.Hub.PlayAuth {
+		if s.tokens == nil || sess.Token == "" {
+			return ErrUnauthorized(now)
+		}
+		claims, err := s.tokens.verify(sess.Token, now)
+		if err != nil {
+			return ErrUnauthorized(now).WithParam("reason", err.Error())
+		}
+		if claims.Stream != sess.Key {
+			return ErrPermissionDenied(now).WithParam("reason", "token is not valid for the stream")
+		}
+	}
+	return NoErr(now, nil)
+}
+
+// slsEventHandler serves `v0/sls/event`, the `on_event_url` callback of sls. sls lets a
+// session start only if the reply is 200.
+func (s *Server) slsEventHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		writeResp(wrt, ErrOperationNotAllowed(now))
+		return
+	}
+	if !fromUnixSocket(req) && !s.cfg.Hub.eventAllowed(req.RemoteAddr) {
+		logger.Warnf("sls: Event from unexpected address %s", req.RemoteAddr)
+		writeResp(wrt, ErrPermissionDenied(now))
+		return
+	}
+
+	q := req.URL.Query()
+	method, role, srtURL := q.Get("method"), q.Get("role_name"), q.Get("srt_url")
+	remote := net.JoinHostPort(q.Get("remote_ip"), q.Get("remote_port"))
+
+	switch method {
+	case slsEventConnect:
+		sess, err := parseSRTURL(role, srtURL)
+		if err != nil {
+			stats.Inc("SessionsRejected", 1)
+			logger.Warnf("sls: Rejected %s from %s, malformed stream ID '%s'", role, remote, srtURL)
+			writeResp(wrt, ErrMalformed(now))
+			return
+		}
+		resp := s.authorize(sess, now)
+		if resp.Ctrl.Code != http.StatusOK {
+			stats.Inc("SessionsRejected", 1)
+			logger.Warnf("sls: Rejected %s of '%s' from %s, %s", role, sess.Key, remote, resp.Ctrl.Text)
+		} else {
+			stats.Inc("SessionsAllowed", 1)
+			logger.Infof("sls: Allowed %s of '%s' from %s", role, sess.Key, remote)
+		}
+		writeResp(wrt, resp)
+
+	case slsEventClose:
+		logger.Infof("sls: Session of %s '%s' from %s closed", role, srtURL, remote)
+		writeResp(wrt, NoErr(now, nil))
+
+	default:
+		writeResp(wrt, ErrMalformed(now).WithParam("method", method))
+	}
+}
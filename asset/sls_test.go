@@ -0,0 +1,77 @@
+package asset
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+func TestSLSEvent(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Hub = hubConfig{Domain: "live.example.com", PlayAuth: true}
+	s.cfg.Auth = authConfig{TokenKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))}
+	tokens, err := newTokenSigner(&s.cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens = tokens
+
+	if code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01"}); code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+
+	event := func(method, role, srtURL string) string {
+		q := url.Values{"method": {method}, "role_name": {role}, "srt_url": {srtURL}, "remote_ip": {"10.0.0.7"}, "remote_port": {"5000"}}
+		return "/api/v0/sls/event?" + q.Encode()
+	}
+	// sls runs next to the asset server.
+	local := http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		req.RemoteAddr = "127.0.0.1:40000"
+		mux.ServeHTTP(wrt, req)
+	})
+
+	now := types.TimeNow()
+	play, _ := s.tokens.issue("app01", "room01_dev", time.Minute, now)
+	other, _ := s.tokens.issue("app01", "room01_cam", time.Minute, now)
+	apiToken, _ := s.tokens.issue("app01", "", time.Minute, now)
+
+	cases := []struct {
+		name   string
+		method string
+		role   string
+		srtURL string
+		want   int
+	}{
+		{"publish known key", slsEventConnect, slsRolePublisher, "uplive.example.com/live/room01_dev", http.StatusOK},
+		{"publish unknown key", slsEventConnect, slsRolePublisher, "uplive.example.com/live/room02_dev", http.StatusNotFound},
+		{"publish to player domain", slsEventConnect, slsRolePublisher, "live.example.com/live/room01_dev", http.StatusNotFound},
+		{"publish to other app", slsEventConnect, slsRolePublisher, "uplive.example.com/vod/room01_dev", http.StatusNotFound},
+		{"malformed stream ID", slsEventConnect, slsRolePublisher, "room01_dev", http.StatusBadRequest},
+		{"play without token", slsEventConnect, slsRolePlayer, "live.example.com/live/room01_dev", http.StatusUnauthorized},
+		{"play with token", slsEventConnect, slsRolePlayer, "live.example.com/live/room01_dev?token=" + play, http.StatusOK},
+		{"play with token of other stream", slsEventConnect, slsRolePlayer, "live.example.com/live/room01_dev?token=" + other, http.StatusForbidden},
+		{"play with API token", slsEventConnect, slsRolePlayer, "live.example.com/live/room01_dev?token=" + apiToken, http.StatusForbidden},
+		{"play with bad token", slsEventConnect, slsRolePlayer, "live.example.com/live/room01_dev?token=" + play + "x", http.StatusUnauthorized},
+		{"relay", slsEventConnect, "puller", "live.example.com/live/room01_dev", http.StatusForbidden},
+		{"close", slsEventClose, slsRolePlayer, "live.example.com/live/room01_dev", http.StatusOK},
+		{"unknown event", "on_play", slsRolePlayer, "live.example.com/live/room01_dev", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if code, data := doRequest(t, local, http.MethodGet, event(c.method, c.role, c.srtURL), nil); code != c.want {
+			t.Errorf("%s: got %d, want %d, %s", c.name, code, c.want, data)
+		}
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodGet, event(slsEventConnect, slsRolePublisher, "uplive.example.com/live/room01_dev"), nil); code != http.StatusForbidden {
+		t.Errorf("event from remote address: got %d", code)
+	}
+	s.cfg.Hub.EventFrom = []string{"192.0.2.0/24"}
+	if code, _ := doRequest(t, mux, http.MethodGet, event(slsEventConnect, slsRolePublisher, "uplive.example.com/live/room01_dev"), nil); code != http.StatusOK {
+		t.Errorf("event from allowed range: got %d", code)
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/file"
+	"github.com/dantin/media-hub/asset/storage/memory"
+	"github.com/dantin/media-hub/asset/storage/mysql"
+	"github.com/dantin/media-hub/asset/storage/postgres"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// Adapter is the interface that every storage backend must implement. Lists are
+// filtered, ordered and paged by the backend, see types.ListQuery. Changes made by
+// callers take audit entries, which are appended to the audit trail in the same
+// operation, so that a change is never saved without its entry or the other way round.
+type Adapter interface {
+	// Open initializes the adapter, e.g. connects to database.
+	Open() error
+	// Close releases resources held by the adapter.
+	Close() error
+	// IsOpen checks if the adapter is ready for use.
+	IsOpen() bool
+	// Name returns the name of the adapter.
+	Name() string
+	// Ping checks that the underlying storage is reachable.
+	Ping() error
+
+	// Version returns the schema version supported by the adapter.
+	Version() int
+	// GetDbVersion returns the schema version recorded in the storage, or
+	// types.ErrNotFound if the storage is not initialized.
+	GetDbVersion() (int, error)
+	// CreateDb initializes the storage at the latest schema version, wiping existing data if reset is true.
+	CreateDb(reset bool) error
+	// UpgradeDb migrates the storage to the latest schema version.
+	UpgradeDb() error
+
+	// RoomCreate creates a room together with its initial streams in one operation.
+	RoomCreate(room *types.Room, streams []*types.Stream, audit ...*types.AuditEntry) error
+	// RoomGet returns the room with the given ID.
+	RoomGet(id string) (*types.Room, error)
+	// RoomList returns rooms selected by the query.
+	RoomList(q *types.ListQuery) ([]types.Room, error)
+	// RoomUpdate updates a room.
+	RoomUpdate(room *types.Room, audit ...*types.AuditEntry) error
+	// RoomDelete deletes a room, its streams and unassigns its devices.
+	RoomDelete(id string, audit ...*types.AuditEntry) error
+
+	// StreamCreate creates a stream in an existing room.
+	StreamCreate(stream *types.Stream, audit ...*types.AuditEntry) error
+	// StreamGet returns the stream with the given ID.
+	StreamGet(id string) (*types.Stream, error)
+	// StreamList returns streams selected by the query.
+	StreamList(q *types.ListQuery) ([]types.Stream, error)
+	// StreamUpdate updates a stream.
+	StreamUpdate(stream *types.Stream, audit ...*types.AuditEntry) error
+	// StreamDelete deletes a stream and unassigns its devices.
+	StreamDelete(id string, audit ...*types.AuditEntry) error
+
+	// DeviceCreate registers a device.
+	DeviceCreate(dev *types.Device, audit ...*types.AuditEntry) error
+	// DeviceGet returns the device with the given serial number.
+	DeviceGet(serial string) (*types.Device, error)
+	// DeviceList returns devices selected by the query.
+	DeviceList(q *types.ListQuery) ([]types.Device, error)
+	// DeviceUpdate updates a device, except its last seen time.
+	DeviceUpdate(dev *types.Device, audit ...*types.AuditEntry) error
+	// DeviceTouch records the time a device was last seen.
+	DeviceTouch(serial string, ts time.Time) error
+	// DeviceDelete deletes a device.
+	DeviceDelete(serial string, audit ...*types.AuditEntry) error
+
+	// APIKeyCreate stores a new API key.
+	APIKeyCreate(key *types.APIKey, audit ...*types.AuditEntry) error
+	// APIKeyGet returns the API key with the given ID.
+	APIKeyGet(id string) (*types.APIKey, error)
+	// APIKeyList returns API keys selected by the query.
+	APIKeyList(q *types.ListQuery) ([]types.APIKey, error)
+	// APIKeyDelete deletes an API key, revoking it.
+	APIKeyDelete(id string, audit ...*types.AuditEntry) error
+
+	// WebhookCreate creates a webhook subscription.
+	WebhookCreate(hook *types.Webhook, audit ...*types.AuditEntry) error
+	// WebhookGet returns the webhook with the given ID.
+	WebhookGet(id string) (*types.Webhook, error)
+	// WebhookList returns webhooks selected by the query.
+	WebhookList(q *types.ListQuery) ([]types.Webhook, error)
+	// WebhookUpdate updates a webhook.
+	WebhookUpdate(hook *types.Webhook, audit ...*types.AuditEntry) error
+	// WebhookDelete deletes a webhook together with its deliveries.
+	WebhookDelete(id string, audit ...*types.AuditEntry) error
+
+	// DeliveryCreate queues a delivery of an event to a webhook.
+	DeliveryCreate(d *types.Delivery) error
+	// DeliveryUpdate records the outcome of a delivery attempt.
+	DeliveryUpdate(d *types.Delivery) error
+	// DeliveryList returns deliveries selected by the query.
+	DeliveryList(q *types.ListQuery) ([]types.Delivery, error)
+	// DeliveryDue returns up to limit pending deliveries due at the given time, oldest first.
+	DeliveryDue(now time.Time, limit int) ([]types.Delivery, error)
+
+	// HoldCreate places a legal hold on recordings of an existing stream.
+	HoldCreate(hold *types.Hold, audit ...*types.AuditEntry) error
+	// HoldGet returns the hold with the given ID.
+	HoldGet(id string) (*types.Hold, error)
+	// HoldList returns holds selected by the query.
+	HoldList(q *types.ListQuery) ([]types.Hold, error)
+	// HoldDelete releases a hold.
+	HoldDelete(id string, audit ...*types.AuditEntry) error
+
+	// AuditAppend appends an entry to the audit trail.
+	AuditAppend(e *types.AuditEntry) error
+	// AuditList returns entries of the audit trail selected by the query.
+	AuditList(q *types.ListQuery) ([]types.AuditEntry, error)
+}
+
+// CheckDbVersion verifies that the storage schema matches the adapter.
+func CheckDbVersion(a Adapter) error {
+	ver, err := a.GetDbVersion()
+	if err == types.ErrNotFound {
+		return fmt.Errorf("storage: %s database is not initialized, run with -init-db", a.Name())
+	}
+	if err != nil {
+		return err
+	}
+	if ver != a.Version() {
+		return fmt.Errorf("storage: %s database version %d does not match expected %d, run with -upgrade-db",
+			a.Name(), ver, a.Version())
+	}
+	return nil
+}
+
+// NewAdapter creates a storage adapter of the configured type. The adapter is not opened.
+func NewAdapter(cfg *Config) (Adapter, error) {
+	switch cfg.Type {
+	case MySQL:
+		if cfg.MySQL == nil {
+			return nil, fmt.Errorf("storage: MySQL configuration is missing")
+		}
+		return mysql.NewAdapter(cfg.MySQL), nil
+	case PostgreSQL:
+		if cfg.PostgreSQL == nil {
+			return nil, fmt.Errorf("storage: PostgreSQL configuration is missing")
+		}
+		return postgres.NewAdapter(cfg.PostgreSQL), nil
+	case Memory:
+		return memory.NewAdapter(), nil
+	case File:
+		if cfg.File == nil {
+			return nil, fmt.Errorf("storage: file configuration is missing")
+		}
+		return file.NewAdapter(cfg.File), nil
+	default:
+		return nil, fmt.Errorf("storage: adapter %s is not supported", cfg.Type)
+	}
+}
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/file"
+	"github.com/dantin/media-hub/asset/storage/memory"
+	"github.com/dantin/media-hub/asset/storage/mysql"
+	"github.com/dantin/media-hub/asset/storage/postgres"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+func TestMemoryAdapter(t *testing.T) {
+	testAdapter(t, memory.NewAdapter())
+}
+
+func TestFileAdapter(t *testing.T) {
+	cfg := &file.Config{Path: filepath.Join(t.TempDir(), "asset.db")}
+	testAdapter(t, file.NewAdapter(cfg))
+
+	// data must survive reopening the file.
+	a := file.NewAdapter(cfg)
+	if err := a.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := a.RoomCreate(&types.Room{ID: "room09"}, nil); err != nil {
+		t.Fatalf("RoomCreate: %v", err)
+	}
+	a.Close()
+
+	if err := a.Open(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer a.Close()
+	if _, err := a.RoomGet("room09"); err != nil {
+		t.Errorf("RoomGet after reopen: %v", err)
+	}
+}
+
+// TestMySQLAdapter runs against any MySQL-compatible server, e.g. MariaDB or TiDB
+// started locally. The database named by MYSQL_TEST_DATABASE is wiped.
+func TestMySQLAdapter(t *testing.T) {
+	host := os.Getenv("MYSQL_TEST_HOST")
+	if host == "" {
+		t.Skip("MYSQL_TEST_HOST is not set")
+	}
+	poolSize, _ := strconv.Atoi(os.Getenv("MYSQL_TEST_POOL_SIZE"))
+
+	a := mysql.NewAdapter(&mysql.Config{
+		Host:     host,
+		User:     os.Getenv("MYSQL_TEST_USER"),
+		Password: os.Getenv("MYSQL_TEST_PASSWORD"),
+		Database: os.Getenv("MYSQL_TEST_DATABASE"),
+		PoolSize: poolSize,
+	})
+	testAdapter(t, a)
+}
+
+// TestPostgreSQLAdapter runs against a local PostgreSQL server. The database named
+// by POSTGRES_TEST_DATABASE is wiped.
+func TestPostgreSQLAdapter(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_TEST_HOST is not set")
+	}
+
+	a := postgres.NewAdapter(&postgres.Config{
+		Host:     host,
+		User:     os.Getenv("POSTGRES_TEST_USER"),
+		Password: os.Getenv("POSTGRES_TEST_PASSWORD"),
+		Database: os.Getenv("POSTGRES_TEST_DATABASE"),
+		SSLMode:  os.Getenv("POSTGRES_TEST_SSL_MODE"),
+	})
+	testAdapter(t, a)
+}
+
+// testAdapter runs the behavior every storage adapter must share.
+func testAdapter(t *testing.T, a Adapter) {
+	if err := a.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer a.Close()
+
+	if !a.IsOpen() {
+		t.Fatal("adapter is not open after Open")
+	}
+	if err := a.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := a.CreateDb(true); err != nil {
+		t.Fatalf("CreateDb: %v", err)
+	}
+	if err := a.UpgradeDb(); err != nil {
+		t.Fatalf("UpgradeDb: %v", err)
+	}
+	if err := CheckDbVersion(a); err != nil {
+		t.Fatalf("CheckDbVersion: %v", err)
+	}
+
+	now := types.TimeNow()
+	room := &types.Room{ID: "room01", Name: "Room 01", CreatedAt: now, UpdatedAt: now}
+	streams := []*types.Stream{
+		{ID: types.NewID(), RoomID: "room01", Type: types.StreamDevice, Key: "room01_dev", CreatedAt: now, UpdatedAt: now},
+		{ID: types.NewID(), RoomID: "room01", Type: types.StreamCamera, Key: "room01_cam", CreatedAt: now, UpdatedAt: now},
+	}
+	if err := a.RoomCreate(room, streams); err != nil {
+		t.Fatalf("RoomCreate: %v", err)
+	}
+	if err := a.RoomCreate(room, nil); err != types.ErrDuplicate {
+		t.Errorf("RoomCreate duplicate: got %v, want %v", err, types.ErrDuplicate)
+	}
+
+	// a failed multi-entity write must not leave a partial room behind.
+	dupKey := []*types.Stream{{ID: types.NewID(), RoomID: "room02", Type: types.StreamDevice, Key: "room01_dev"}}
+	if err := a.RoomCreate(&types.Room{ID: "room02"}, dupKey); err != types.ErrDuplicate {
+		t.Errorf("RoomCreate with taken stream key: got %v, want %v", err, types.ErrDuplicate)
+	}
+	if _, err := a.RoomGet("room02"); err != types.ErrNotFound {
+		t.Errorf("RoomGet after failed create: got %v, want %v", err, types.ErrNotFound)
+	}
+
+	got, err := a.RoomGet("room01")
+	if err != nil {
+		t.Fatalf("RoomGet: %v", err)
+	}
+	if got.Name != "Room 01" || !got.CreatedAt.Equal(now) {
+		t.Errorf("RoomGet: got %+v", got)
+	}
+
+	room.Name = "Exam Room"
+	if err := a.RoomUpdate(room); err != nil {
+		t.Fatalf("RoomUpdate: %v", err)
+	}
+	if err := a.RoomUpdate(&types.Room{ID: "missing"}); err != types.ErrNotFound {
+		t.Errorf("RoomUpdate missing: got %v, want %v", err, types.ErrNotFound)
+	}
+	rooms, err := a.RoomList(nil)
+	if err != nil || len(rooms) != 1 || rooms[0].Name != "Exam Room" {
+		t.Errorf("RoomList: got %+v, %v", rooms, err)
+	}
+
+	list, err := a.StreamList(types.Where("room_id", "room01"))
+	if err != nil || len(list) != 2 {
+		t.Fatalf("StreamList: got %+v, %v", list, err)
+	}
+	if in, err := a.StreamList(&types.ListQuery{In: map[string][]string{"room_id": {"missing", "room01"}}}); err != nil || len(in) != 2 {
+		t.Errorf("StreamList in rooms: got %+v, %v", in, err)
+	}
+	if in, err := a.StreamList(&types.ListQuery{In: map[string][]string{"room_id": {}}}); err != nil || len(in) != 0 {
+		t.Errorf("StreamList in no rooms: got %+v, %v", in, err)
+	}
+	if st, err := a.StreamList(&types.ListQuery{Filters: map[string]string{"key": "room01_cam"}, Limit: 1}); err != nil || len(st) != 1 {
+		t.Errorf("StreamList by key: got %+v, %v", st, err)
+	}
+	extra := &types.Stream{ID: types.NewID(), RoomID: "room01", Type: types.StreamCamera, Key: "room01_cam2"}
+	if err := a.StreamCreate(extra); err != nil {
+		t.Fatalf("StreamCreate: %v", err)
+	}
+	orphan := &types.Stream{ID: types.NewID(), RoomID: "missing", Type: types.StreamCamera, Key: "orphan"}
+	if err := a.StreamCreate(orphan); err != types.ErrMalformed {
+		t.Errorf("StreamCreate in missing room: got %v, want %v", err, types.ErrMalformed)
+	}
+	extra.Key = "room01_cam"
+	if err := a.StreamUpdate(extra); err != types.ErrDuplicate {
+		t.Errorf("StreamUpdate to taken key: got %v, want %v", err, types.ErrDuplicate)
+	}
+	if err := a.StreamDelete(extra.ID); err != nil {
+		t.Errorf("StreamDelete: %v", err)
+	}
+	if _, err := a.StreamGet(extra.ID); err != types.ErrNotFound {
+		t.Errorf("StreamGet deleted: got %v, want %v", err, types.ErrNotFound)
+	}
+
+	dev := &types.Device{Serial: "SN001", Model: "cam-x", RoomID: "room01", StreamID: streams[1].ID, CreatedAt: now, UpdatedAt: now}
+	if err := a.DeviceCreate(dev); err != nil {
+		t.Fatalf("DeviceCreate: %v", err)
+	}
+	if err := a.DeviceCreate(&types.Device{Serial: "SN002", RoomID: "missing"}); err != types.ErrMalformed {
+		t.Errorf("DeviceCreate with missing room: got %v, want %v", err, types.ErrMalformed)
+	}
+	dev.Model = "cam-y"
+	if err := a.DeviceUpdate(dev); err != nil {
+		t.Fatalf("DeviceUpdate: %v", err)
+	}
+	seen := now.Add(time.Minute)
+	if err := a.DeviceTouch("SN001", seen); err != nil {
+		t.Fatalf("DeviceTouch: %v", err)
+	}
+	if err := a.DeviceTouch("SN404", seen); err != types.ErrNotFound {
+		t.Errorf("DeviceTouch missing: got %v, want %v", err, types.ErrNotFound)
+	}
+	if err := a.DeviceUpdate(dev); err != nil {
+		t.Fatalf("DeviceUpdate after touch: %v", err)
+	}
+	devices, err := a.DeviceList(nil)
+	if err != nil || len(devices) != 1 || devices[0].Model != "cam-y" {
+		t.Fatalf("DeviceList: got %+v, %v", devices, err)
+	}
+	if devices[0].LastSeen == nil || !devices[0].LastSeen.Equal(seen) {
+		t.Errorf("DeviceUpdate lost last seen time: %+v", devices[0])
+	}
+
+	from := now.Add(-time.Hour)
+	hold := &types.Hold{ID: "hold1", StreamID: streams[0].ID, From: &from, Reason: "case 42", CreatedAt: now}
+	if err := a.HoldCreate(hold); err != nil {
+		t.Fatalf("HoldCreate: %v", err)
+	}
+	if err := a.HoldCreate(&types.Hold{ID: "hold2", StreamID: "missing", CreatedAt: now}); err != types.ErrMalformed {
+		t.Errorf("HoldCreate for missing stream: got %v, want %v", err, types.ErrMalformed)
+	}
+	if h, err := a.HoldGet("hold1"); err != nil || h.From == nil || !h.From.Equal(from) || h.To != nil || h.Reason != "case 42" {
+		t.Errorf("HoldGet: got %+v, %v", h, err)
+	}
+	if holds, err := a.HoldList(types.Where("stream_id", streams[1].ID)); err != nil || len(holds) != 0 {
+		t.Errorf("HoldList of other stream: got %+v, %v", holds, err)
+	}
+
+	if err := a.RoomDelete("room01"); err != nil {
+		t.Fatalf("RoomDelete: %v", err)
+	}
+	if list, _ := a.StreamList(nil); len(list) != 0 {
+		t.Errorf("streams left after RoomDelete: %+v", list)
+	}
+	d, err := a.DeviceGet("SN001")
+	if err != nil {
+		t.Fatalf("DeviceGet: %v", err)
+	}
+	if d.RoomID != "" || d.StreamID != "" {
+		t.Errorf("device still assigned after RoomDelete: %+v", d)
+	}
+	if err := a.DeviceDelete("SN001"); err != nil {
+		t.Errorf("DeviceDelete: %v", err)
+	}
+	if err := a.DeviceDelete("SN001"); err != types.ErrNotFound {
+		t.Errorf("DeviceDelete missing: got %v, want %v", err, types.ErrNotFound)
+	}
+
+	key := &types.APIKey{ID: "k1", Name: "relay", Hash: strings.Repeat("ab", 32), CreatedAt: now}
+	if err := a.APIKeyCreate(key); err != nil {
+		t.Fatalf("APIKeyCreate: %v", err)
+	}
+	if err := a.APIKeyCreate(key); err != types.ErrDuplicate {
+		t.Errorf("APIKeyCreate duplicate: got %v, want %v", err, types.ErrDuplicate)
+	}
+	k, err := a.APIKeyGet("k1")
+	if err != nil || k.Hash != key.Hash || k.Name != "relay" {
+		t.Fatalf("APIKeyGet: got %+v, %v", k, err)
+	}
+	if keys, err := a.APIKeyList(nil); err != nil || len(keys) != 1 || keys[0].Hash != key.Hash {
+		t.Errorf("APIKeyList: got %+v, %v", keys, err)
+	}
+	if err := a.APIKeyDelete("k1"); err != nil {
+		t.Errorf("APIKeyDelete: %v", err)
+	}
+	if _, err := a.APIKeyGet("k1"); err != types.ErrNotFound {
+		t.Errorf("APIKeyGet deleted: got %v, want %v", err, types.ErrNotFound)
+	}
+
+	// holds outlive their streams, recordings stay on disk.
+	if holds, err := a.HoldList(nil); err != nil || len(holds) != 1 || holds[0].ID != "hold1" {
+		t.Errorf("HoldList after RoomDelete: got %+v, %v", holds, err)
+	}
+	if err := a.HoldDelete("hold1"); err != nil {
+		t.Errorf("HoldDelete: %v", err)
+	}
+	if err := a.HoldDelete("hold1"); err != types.ErrNotFound {
+		t.Errorf("HoldDelete deleted: got %v, want %v", err, types.ErrNotFound)
+	}
+
+	hook := &types.Webhook{ID: "h1", URL: "http://emr.local/hook", Secret: "s3cret", Events: []string{"stream.published", "device.offline"},
+		CreatedAt: now, UpdatedAt: now}
+	if err := a.WebhookCreate(hook); err != nil {
+		t.Fatalf("WebhookCreate: %v", err)
+	}
+	hook.Events = nil
+	if err := a.WebhookUpdate(hook); err != nil {
+		t.Fatalf("WebhookUpdate: %v", err)
+	}
+	h, err := a.WebhookGet("h1")
+	if err != nil || h.Secret != "s3cret" || len(h.Events) != 0 || !h.Wants("device.online") {
+		t.Fatalf("WebhookGet: got %+v, %v", h, err)
+	}
+	if hooks, err := a.WebhookList(nil); err != nil || len(hooks) != 1 {
+		t.Errorf("WebhookList: got %+v, %v", hooks, err)
+	}
+
+	if err := a.DeliveryCreate(&types.Delivery{ID: "d0", WebhookID: "missing", EventID: "e0", EventType: "device.online",
+		Payload: []byte(`{}`), Status: types.DeliveryPending}); err != types.ErrMalformed {
+		t.Errorf("DeliveryCreate for missing webhook: got %v, want %v", err, types.ErrMalformed)
+	}
+	for i := 1; i <= 3; i++ {
+		d := &types.Delivery{ID: "d" + strconv.Itoa(i), WebhookID: "h1", EventID: "e" + strconv.Itoa(i), EventType: "device.online",
+			Payload: []byte(`{"serial":"SN001"}`), Status: types.DeliveryPending, NextAttempt: now.Add(time.Duration(i) * time.Second),
+			CreatedAt: now.Add(time.Duration(i) * time.Second), UpdatedAt: now}
+		if err := a.DeliveryCreate(d); err != nil {
+			t.Fatalf("DeliveryCreate: %v", err)
+		}
+	}
+	due, err := a.DeliveryDue(now.Add(2*time.Second), 0)
+	if err != nil || len(due) != 2 || due[0].ID != "d1" || string(due[0].Payload) != `{"serial":"SN001"}` {
+		t.Fatalf("DeliveryDue: got %+v, %v", due, err)
+	}
+	due[0].Status, due[0].Attempts, due[0].ResponseCode = types.DeliveryDelivered, 1, 200
+	if err := a.DeliveryUpdate(&due[0]); err != nil {
+		t.Fatalf("DeliveryUpdate: %v", err)
+	}
+	if due, _ := a.DeliveryDue(now.Add(time.Hour), 1); len(due) != 1 || due[0].ID != "d2" {
+		t.Errorf("DeliveryDue after update: got %+v", due)
+	}
+	log, err := a.DeliveryList(&types.ListQuery{Filters: map[string]string{"webhook_id": "h1"}, Sort: "created_at", Desc: true, Limit: 2})
+	if err != nil || len(log) != 2 || log[0].ID != "d3" || log[1].ID != "d2" {
+		t.Errorf("DeliveryList: got %+v, %v", log, err)
+	}
+	if err := a.WebhookDelete("h1"); err != nil {
+		t.Fatalf("WebhookDelete: %v", err)
+	}
+	if log, _ := a.DeliveryList(types.Where("webhook_id", "h1")); len(log) != 0 {
+		t.Errorf("deliveries left after WebhookDelete: %+v", log)
+	}
+	if due, _ := a.DeliveryDue(now.Add(time.Hour), 0); len(due) != 0 {
+		t.Errorf("pending deliveries left after WebhookDelete: %+v", due)
+	}
+
+	if err := a.AuditAppend(&types.AuditEntry{ID: "a0", Actor: "apikey:k1", Action: "rename", Entity: "room", EntityID: "room01",
+		CreatedAt: now}); err != types.ErrMalformed {
+		t.Errorf("AuditAppend with unknown action: got %v, want %v", err, types.ErrMalformed)
+	}
+	for i, entity := range []string{"room", "stream", "room"} {
+		e := &types.AuditEntry{ID: "a" + strconv.Itoa(i+1), Actor: "apikey:k1", Action: types.AuditUpdate, Entity: entity,
+			EntityID: entity + "01", After: []byte(`{"name":"Room"}`), SourceIP: "10.0.0.1",
+			CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := a.AuditAppend(e); err != nil {
+			t.Fatalf("AuditAppend: %v", err)
+		}
+	}
+	newest := &types.ListQuery{Filters: map[string]string{"entity": "room", "entity_id": "room01"}, Sort: "created_at", Desc: true}
+	trail, err := a.AuditList(newest)
+	if err != nil || len(trail) != 2 || trail[0].ID != "a3" || trail[1].ID != "a1" || trail[1].Before != nil ||
+		string(trail[1].After) != `{"name":"Room"}` || trail[1].SourceIP != "10.0.0.1" {
+		t.Errorf("AuditList by entity: got %+v, %v", trail, err)
+	}
+	from, to := now.Add(time.Second), now.Add(2*time.Second)
+	if trail, err := a.AuditList(&types.ListQuery{Ranges: []types.Range{{Field: "created_at", From: &from, To: &to}}}); err != nil || len(trail) != 1 || trail[0].ID != "a2" {
+		t.Errorf("AuditList by time: got %+v, %v", trail, err)
+	}
+	if trail, err := a.AuditList(&types.ListQuery{Sort: "created_at", Desc: true, Limit: 1}); err != nil || len(trail) != 1 || trail[0].ID != "a3" {
+		t.Errorf("AuditList with limit: got %+v, %v", trail, err)
+	}
+	newest.Limit, newest.After = 1, newest.CursorOf(&trail[0])
+	if page, err := a.AuditList(newest); err != nil || len(page) != 1 || page[0].ID != "a1" {
+		t.Errorf("AuditList after cursor: got %+v, %v", page, err)
+	}
+	if _, err := a.AuditList(types.Where("source_ip", "10.0.0.1")); err != types.ErrMalformed {
+		t.Errorf("AuditList by unknown field: got %v, want %v", err, types.ErrMalformed)
+	}
+
+	// changes are recorded along with their audit entries, or not at all.
+	created := &types.AuditEntry{ID: "a4", Actor: "apikey:k1", Action: types.AuditCreate, Entity: "room", EntityID: "room03", CreatedAt: now}
+	if err := a.RoomCreate(&types.Room{ID: "room03", Name: "Room 03", CreatedAt: now, UpdatedAt: now}, nil, created); err != nil {
+		t.Fatalf("RoomCreate with audit: %v", err)
+	}
+	if trail, err := a.AuditList(types.Where("entity_id", "room03")); err != nil || len(trail) != 1 || trail[0].ID != "a4" {
+		t.Errorf("audit of RoomCreate: got %+v, %v", trail, err)
+	}
+	renamed := &types.AuditEntry{ID: "a5", Actor: "apikey:k1", Action: "rename", Entity: "room", EntityID: "room03", CreatedAt: now}
+	if err := a.RoomUpdate(&types.Room{ID: "room03", Name: "Renamed", UpdatedAt: now}, renamed); err != types.ErrMalformed {
+		t.Errorf("RoomUpdate with bad audit entry: got %v, want %v", err, types.ErrMalformed)
+	}
+	if err := a.RoomDelete("room03", created); err != types.ErrDuplicate {
+		t.Errorf("RoomDelete with recorded audit entry: got %v, want %v", err, types.ErrDuplicate)
+	}
+	if got, err := a.RoomGet("room03"); err != nil || got.Name != "Room 03" {
+		t.Errorf("room after failed audit: got %+v, %v", got, err)
+	}
+}
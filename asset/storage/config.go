@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/dantin/media-hub/asset/storage/file"
 	"github.com/dantin/media-hub/asset/storage/mysql"
+	"github.com/dantin/media-hub/asset/storage/postgres"
 )
 
 // Type represents a storage manager type.
@@ -19,12 +21,16 @@ const (
 
 	// Memory represents a in-memory storage type.
 	Memory
+
+	// File represents an embedded, file-backed storage type.
+	File
 )
 
 var typeStringMap = map[Type]string{
 	MySQL:      "MySQL",
 	PostgreSQL: "PostgreSQL",
 	Memory:     "Memory",
+	File:       "File",
 }
 
 func (t Type) String() string {
@@ -33,13 +39,17 @@ func (t Type) String() string {
 
 // Config represents an storage manager configuration.
 type Config struct {
-	Type  Type
-	MySQL *mysql.Config
+	Type       Type
+	MySQL      *mysql.Config
+	PostgreSQL *postgres.Config
+	File       *file.Config
 }
 
 type storageProxyType struct {
-	Type  string        `yaml:"type"`
-	MySQL *mysql.Config `yaml:"mysql"`
+	Type       string           `yaml:"type"`
+	MySQL      *mysql.Config    `yaml:"mysql"`
+	PostgreSQL *postgres.Config `yaml:"postgres"`
+	File       *file.Config     `yaml:"file"`
 }
 
 // UnmarshalYAML satisfies Unmarshaler interface.
@@ -57,9 +67,23 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		c.Type = MySQL
 		c.MySQL = p.MySQL
 
+	case "postgres":
+		if p.PostgreSQL == nil {
+			return errors.New("storage.Config: couldn't read PostgreSQL configuration")
+		}
+		c.Type = PostgreSQL
+		c.PostgreSQL = p.PostgreSQL
+
 	case "memory":
 		c.Type = Memory
 
+	case "file":
+		c.Type = File
+		c.File = p.File
+		if c.File == nil {
+			c.File = &file.Config{Path: file.DefaultPath, LockTimeout: file.DefaultLockTimeout}
+		}
+
 	case "":
 		return errors.New("storage.Config: unspecified storage type")
 
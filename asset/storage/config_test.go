@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConfigUnmarshalYAML(t *testing.T) {
+	var cfg Config
+	doc := `
+type: postgres
+postgres:
+  host: "localhost:5432"
+  user: "hub"
+  database: "assets"
+`
+	if err := yaml.Unmarshal([]byte(doc), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Type != PostgreSQL || cfg.PostgreSQL == nil {
+		t.Fatalf("got %+v, want PostgreSQL config", cfg)
+	}
+	if cfg.PostgreSQL.PoolSize != 16 || cfg.PostgreSQL.SSLMode != "disable" {
+		t.Errorf("defaults not applied: %+v", cfg.PostgreSQL)
+	}
+
+	for _, doc := range []string{"type: postgres", "type: oracle", "mysql: {}"} {
+		if err := yaml.Unmarshal([]byte(doc), &Config{}); err == nil {
+			t.Errorf("%q: expected error", doc)
+		}
+	}
+}
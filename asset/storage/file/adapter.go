@@ -0,0 +1,960 @@
+// Package file implements an embedded storage adapter which keeps all entities in
+// a single database file. Every write is an fsync'ed copy-on-write transaction, so
+// the file stays consistent across crashes and power loss.
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const adapterName = "file"
+
+var (
+	bucketRooms      = []byte("rooms")
+	bucketStreams    = []byte("streams")
+	bucketStreamKeys = []byte("stream_keys")
+	bucketDevices    = []byte("devices")
+	bucketAPIKeys    = []byte("apikeys")
+	bucketWebhooks   = []byte("webhooks")
+	bucketDeliveries = []byte("deliveries")
+	// bucketPending indexes IDs of pending deliveries, so the queue is scanned without the log.
+	bucketPending = []byte("deliveries_pending")
+	bucketHolds   = []byte("holds")
+	// bucketAudit keeps the audit trail keyed by time, so that entries are stored in order.
+	bucketAudit = []byte("audit")
+)
+
+// auditKeyLayout formats times in audit keys, they sort the same as the times.
+const auditKeyLayout = "20060102T150405.000Z"
+
+// Adapter is an embedded, file-backed storage adapter.
+type Adapter struct {
+	cfg *Config
+	db  *bolt.DB
+}
+
+// NewAdapter returns a new, unopened file adapter.
+func NewAdapter(cfg *Config) *Adapter {
+	return &Adapter{cfg: cfg}
+}
+
+// Open opens the database file, creating it if necessary.
+func (a *Adapter) Open() error {
+	if a.db != nil {
+		return errors.New("file adapter is already open")
+	}
+
+	path := a.cfg.Path
+	if path == "" {
+		path = DefaultPath
+	}
+	timeout := a.cfg.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	a.db = db
+	return nil
+}
+
+// Close flushes and closes the database file.
+func (a *Adapter) Close() error {
+	if a.db == nil {
+		return nil
+	}
+	err := a.db.Close()
+	a.db = nil
+	return err
+}
+
+// IsOpen checks if the adapter is ready for use.
+func (a *Adapter) IsOpen() bool {
+	return a.db != nil
+}
+
+// Name returns the name of the adapter.
+func (a *Adapter) Name() string {
+	return adapterName
+}
+
+// Ping checks that the database file is readable.
+func (a *Adapter) Ping() error {
+	return a.view(func(tx *bolt.Tx) error { return nil })
+}
+
+// RoomCreate creates a room together with its initial streams in one transaction.
+func (a *Adapter) RoomCreate(room *types.Room, streams []*types.Stream, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if s.RoomID != room.ID {
+			return types.ErrMalformed
+		}
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		rooms := tx.Bucket(bucketRooms)
+		if rooms.Get([]byte(room.ID)) != nil {
+			return types.ErrDuplicate
+		}
+		if err := put(rooms, room.ID, room); err != nil {
+			return err
+		}
+		for _, s := range streams {
+			if err := putNewStream(tx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RoomGet returns the room with the given ID.
+func (a *Adapter) RoomGet(id string) (*types.Room, error) {
+	var room types.Room
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketRooms), id, &room)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// RoomList returns rooms selected by the query.
+func (a *Adapter) RoomList(q *types.ListQuery) ([]types.Room, error) {
+	if err := q.Check(&types.Room{}); err != nil {
+		return nil, err
+	}
+	var all []types.Room
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRooms).ForEach(func(_, v []byte) error {
+			var e types.Room
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	rooms := make([]types.Room, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		rooms = append(rooms, all[i])
+	}
+	return rooms, nil
+}
+
+// RoomUpdate updates a room.
+func (a *Adapter) RoomUpdate(room *types.Room, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		rooms := tx.Bucket(bucketRooms)
+		var old types.Room
+		if err := get(rooms, room.ID, &old); err != nil {
+			return err
+		}
+		stored := *room
+		stored.CreatedAt = old.CreatedAt
+		return put(rooms, room.ID, &stored)
+	})
+}
+
+// RoomDelete deletes a room, its streams and unassigns its devices in one transaction.
+func (a *Adapter) RoomDelete(id string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		rooms := tx.Bucket(bucketRooms)
+		if rooms.Get([]byte(id)) == nil {
+			return types.ErrNotFound
+		}
+
+		var doomed []types.Stream
+		err := tx.Bucket(bucketStreams).ForEach(func(_, v []byte) error {
+			var s types.Stream
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.RoomID == id {
+				doomed = append(doomed, s)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, s := range doomed {
+			if err := deleteStream(tx, &s); err != nil {
+				return err
+			}
+		}
+
+		err = updateDevices(tx, func(d *types.Device) bool {
+			if d.RoomID != id {
+				return false
+			}
+			d.RoomID, d.StreamID = "", ""
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return rooms.Delete([]byte(id))
+	})
+}
+
+// StreamCreate creates a stream in an existing room.
+func (a *Adapter) StreamCreate(s *types.Stream, audit ...*types.AuditEntry) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketRooms).Get([]byte(s.RoomID)) == nil {
+			return types.ErrMalformed
+		}
+		return putNewStream(tx, s)
+	})
+}
+
+// StreamGet returns the stream with the given ID.
+func (a *Adapter) StreamGet(id string) (*types.Stream, error) {
+	var s types.Stream
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketStreams), id, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// StreamList returns streams selected by the query.
+func (a *Adapter) StreamList(q *types.ListQuery) ([]types.Stream, error) {
+	if err := q.Check(&types.Stream{}); err != nil {
+		return nil, err
+	}
+	var all []types.Stream
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketStreams).ForEach(func(_, v []byte) error {
+			var e types.Stream
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	streams := make([]types.Stream, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		streams = append(streams, all[i])
+	}
+	return streams, nil
+}
+
+// StreamUpdate updates a stream. The owning room cannot be changed.
+func (a *Adapter) StreamUpdate(s *types.Stream, audit ...*types.AuditEntry) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		streams, keys := tx.Bucket(bucketStreams), tx.Bucket(bucketStreamKeys)
+		var old types.Stream
+		if err := get(streams, s.ID, &old); err != nil {
+			return err
+		}
+		if old.RoomID != s.RoomID {
+			return types.ErrMalformed
+		}
+		if old.Key != s.Key {
+			if keys.Get([]byte(s.Key)) != nil {
+				return types.ErrDuplicate
+			}
+			if err := keys.Delete([]byte(old.Key)); err != nil {
+				return err
+			}
+			if err := keys.Put([]byte(s.Key), []byte(s.ID)); err != nil {
+				return err
+			}
+		}
+		stored := *s
+		stored.CreatedAt = old.CreatedAt
+		return put(streams, s.ID, &stored)
+	})
+}
+
+// StreamDelete deletes a stream and unassigns its devices in one transaction.
+func (a *Adapter) StreamDelete(id string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		var s types.Stream
+		if err := get(tx.Bucket(bucketStreams), id, &s); err != nil {
+			return err
+		}
+		return deleteStream(tx, &s)
+	})
+}
+
+// DeviceCreate registers a device.
+func (a *Adapter) DeviceCreate(d *types.Device, audit ...*types.AuditEntry) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		devices := tx.Bucket(bucketDevices)
+		if devices.Get([]byte(d.Serial)) != nil {
+			return types.ErrDuplicate
+		}
+		if !validAssignment(tx, d) {
+			return types.ErrMalformed
+		}
+		return put(devices, d.Serial, d)
+	})
+}
+
+// DeviceGet returns the device with the given serial number.
+func (a *Adapter) DeviceGet(serial string) (*types.Device, error) {
+	var d types.Device
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketDevices), serial, &d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeviceList returns devices selected by the query.
+func (a *Adapter) DeviceList(q *types.ListQuery) ([]types.Device, error) {
+	if err := q.Check(&types.Device{}); err != nil {
+		return nil, err
+	}
+	var all []types.Device
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDevices).ForEach(func(_, v []byte) error {
+			var e types.Device
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]types.Device, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		devices = append(devices, all[i])
+	}
+	return devices, nil
+}
+
+// DeviceUpdate updates a device.
+func (a *Adapter) DeviceUpdate(d *types.Device, audit ...*types.AuditEntry) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		devices := tx.Bucket(bucketDevices)
+		var old types.Device
+		if err := get(devices, d.Serial, &old); err != nil {
+			return err
+		}
+		if !validAssignment(tx, d) {
+			return types.ErrMalformed
+		}
+		stored := *d
+		stored.CreatedAt, stored.LastSeen = old.CreatedAt, old.LastSeen
+		return put(devices, d.Serial, &stored)
+	})
+}
+
+// DeviceTouch records the time a device was last seen.
+func (a *Adapter) DeviceTouch(serial string, ts time.Time) error {
+	return a.update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(bucketDevices)
+		var d types.Device
+		if err := get(devices, serial, &d); err != nil {
+			return err
+		}
+		d.LastSeen = &ts
+		return put(devices, serial, &d)
+	})
+}
+
+// DeviceDelete deletes a device.
+func (a *Adapter) DeviceDelete(serial string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		devices := tx.Bucket(bucketDevices)
+		if devices.Get([]byte(serial)) == nil {
+			return types.ErrNotFound
+		}
+		return devices.Delete([]byte(serial))
+	})
+}
+
+// apiKeyRecord is how an API key is persisted, the hash is hidden from JSON of types.APIKey.
+type apiKeyRecord struct {
+	types.APIKey
+	Hash string `json:"hash"`
+}
+
+func (r *apiKeyRecord) key() *types.APIKey {
+	k := r.APIKey
+	k.Hash = r.Hash
+	return &k
+}
+
+// APIKeyCreate stores a new API key.
+func (a *Adapter) APIKeyCreate(k *types.APIKey, audit ...*types.AuditEntry) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketAPIKeys)
+		if keys.Get([]byte(k.ID)) != nil {
+			return types.ErrDuplicate
+		}
+		return put(keys, k.ID, &apiKeyRecord{APIKey: *k, Hash: k.Hash})
+	})
+}
+
+// APIKeyGet returns the API key with the given ID.
+func (a *Adapter) APIKeyGet(id string) (*types.APIKey, error) {
+	var r apiKeyRecord
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketAPIKeys), id, &r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.key(), nil
+}
+
+// APIKeyList returns API keys selected by the query.
+func (a *Adapter) APIKeyList(q *types.ListQuery) ([]types.APIKey, error) {
+	if err := q.Check(&types.APIKey{}); err != nil {
+		return nil, err
+	}
+	var all []types.APIKey
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPIKeys).ForEach(func(_, v []byte) error {
+			var r apiKeyRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if e := r.key(); q.Matches(e) {
+				all = append(all, *e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]types.APIKey, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		keys = append(keys, all[i])
+	}
+	return keys, nil
+}
+
+// APIKeyDelete deletes an API key.
+func (a *Adapter) APIKeyDelete(id string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketAPIKeys)
+		if keys.Get([]byte(id)) == nil {
+			return types.ErrNotFound
+		}
+		return keys.Delete([]byte(id))
+	})
+}
+
+// webhookRecord is how a webhook is persisted, the secret is hidden from JSON of types.Webhook.
+type webhookRecord struct {
+	types.Webhook
+	Secret string `json:"secret"`
+}
+
+func (r *webhookRecord) hook() *types.Webhook {
+	h := r.Webhook
+	h.Secret = r.Secret
+	return &h
+}
+
+// WebhookCreate creates a webhook subscription.
+func (a *Adapter) WebhookCreate(h *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		hooks := tx.Bucket(bucketWebhooks)
+		if hooks.Get([]byte(h.ID)) != nil {
+			return types.ErrDuplicate
+		}
+		return put(hooks, h.ID, &webhookRecord{Webhook: *h, Secret: h.Secret})
+	})
+}
+
+// WebhookGet returns the webhook with the given ID.
+func (a *Adapter) WebhookGet(id string) (*types.Webhook, error) {
+	var r webhookRecord
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketWebhooks), id, &r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.hook(), nil
+}
+
+// WebhookList returns webhooks selected by the query.
+func (a *Adapter) WebhookList(q *types.ListQuery) ([]types.Webhook, error) {
+	if err := q.Check(&types.Webhook{}); err != nil {
+		return nil, err
+	}
+	var all []types.Webhook
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhooks).ForEach(func(_, v []byte) error {
+			var r webhookRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if e := r.hook(); q.Matches(e) {
+				all = append(all, *e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]types.Webhook, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		hooks = append(hooks, all[i])
+	}
+	return hooks, nil
+}
+
+// WebhookUpdate updates a webhook.
+func (a *Adapter) WebhookUpdate(h *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		hooks := tx.Bucket(bucketWebhooks)
+		var old webhookRecord
+		if err := get(hooks, h.ID, &old); err != nil {
+			return err
+		}
+		stored := webhookRecord{Webhook: *h, Secret: h.Secret}
+		stored.CreatedAt = old.CreatedAt
+		return put(hooks, h.ID, &stored)
+	})
+}
+
+// WebhookDelete deletes a webhook together with its deliveries.
+func (a *Adapter) WebhookDelete(id string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		hooks := tx.Bucket(bucketWebhooks)
+		if hooks.Get([]byte(id)) == nil {
+			return types.ErrNotFound
+		}
+
+		deliveries := tx.Bucket(bucketDeliveries)
+		var doomed [][]byte
+		err := deliveries.ForEach(func(k, v []byte) error {
+			var d types.Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.WebhookID == id {
+				doomed = append(doomed, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range doomed {
+			if err := deliveries.Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketPending).Delete(k); err != nil {
+				return err
+			}
+		}
+		return hooks.Delete([]byte(id))
+	})
+}
+
+// DeliveryCreate queues a delivery of an event to a webhook.
+func (a *Adapter) DeliveryCreate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketWebhooks).Get([]byte(d.WebhookID)) == nil {
+			return types.ErrMalformed
+		}
+		deliveries := tx.Bucket(bucketDeliveries)
+		if deliveries.Get([]byte(d.ID)) != nil {
+			return types.ErrDuplicate
+		}
+		return putDelivery(tx, d)
+	})
+}
+
+// DeliveryUpdate records the outcome of a delivery attempt.
+func (a *Adapter) DeliveryUpdate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.update(func(tx *bolt.Tx) error {
+		var old types.Delivery
+		if err := get(tx.Bucket(bucketDeliveries), d.ID, &old); err != nil {
+			return err
+		}
+		old.Status, old.Attempts, old.NextAttempt = d.Status, d.Attempts, d.NextAttempt
+		old.LastError, old.ResponseCode, old.UpdatedAt = d.LastError, d.ResponseCode, d.UpdatedAt
+		return putDelivery(tx, &old)
+	})
+}
+
+// DeliveryList returns deliveries selected by the query.
+func (a *Adapter) DeliveryList(q *types.ListQuery) ([]types.Delivery, error) {
+	if err := q.Check(&types.Delivery{}); err != nil {
+		return nil, err
+	}
+	var all []types.Delivery
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDeliveries).ForEach(func(_, v []byte) error {
+			var e types.Delivery
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]types.Delivery, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		deliveries = append(deliveries, all[i])
+	}
+	return deliveries, nil
+}
+
+// DeliveryDue returns up to limit pending deliveries due at the given time, oldest first.
+func (a *Adapter) DeliveryDue(now time.Time, limit int) ([]types.Delivery, error) {
+	list := make([]types.Delivery, 0)
+	err := a.view(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(bucketDeliveries)
+		return tx.Bucket(bucketPending).ForEach(func(k, _ []byte) error {
+			var d types.Delivery
+			if err := get(deliveries, string(k), &d); err != nil {
+				return err
+			}
+			if !d.NextAttempt.After(now) {
+				list = append(list, d)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].NextAttempt.Equal(list[j].NextAttempt) {
+			return list[i].NextAttempt.Before(list[j].NextAttempt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// putDelivery stores the delivery and keeps the index of pending deliveries up to date.
+func putDelivery(tx *bolt.Tx, d *types.Delivery) error {
+	if err := put(tx.Bucket(bucketDeliveries), d.ID, d); err != nil {
+		return err
+	}
+	pending := tx.Bucket(bucketPending)
+	if d.Status == types.DeliveryPending {
+		return pending.Put([]byte(d.ID), []byte{})
+	}
+	return pending.Delete([]byte(d.ID))
+}
+
+// HoldCreate places a legal hold on recordings of an existing stream.
+func (a *Adapter) HoldCreate(h *types.Hold, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketStreams).Get([]byte(h.StreamID)) == nil {
+			return types.ErrMalformed
+		}
+		holds := tx.Bucket(bucketHolds)
+		if holds.Get([]byte(h.ID)) != nil {
+			return types.ErrDuplicate
+		}
+		return put(holds, h.ID, h)
+	})
+}
+
+// HoldGet returns the hold with the given ID.
+func (a *Adapter) HoldGet(id string) (*types.Hold, error) {
+	var h types.Hold
+	err := a.view(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketHolds), id, &h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// HoldList returns holds selected by the query.
+func (a *Adapter) HoldList(q *types.ListQuery) ([]types.Hold, error) {
+	if err := q.Check(&types.Hold{}); err != nil {
+		return nil, err
+	}
+	var all []types.Hold
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketHolds).ForEach(func(_, v []byte) error {
+			var e types.Hold
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	holds := make([]types.Hold, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		holds = append(holds, all[i])
+	}
+	return holds, nil
+}
+
+// HoldDelete releases a hold.
+func (a *Adapter) HoldDelete(id string, audit ...*types.AuditEntry) error {
+	return a.updateAudited(audit, func(tx *bolt.Tx) error {
+		holds := tx.Bucket(bucketHolds)
+		if holds.Get([]byte(id)) == nil {
+			return types.ErrNotFound
+		}
+		return holds.Delete([]byte(id))
+	})
+}
+
+// AuditAppend appends an entry to the audit trail.
+func (a *Adapter) AuditAppend(e *types.AuditEntry) error {
+	return a.update(func(tx *bolt.Tx) error {
+		return putAudit(tx, e)
+	})
+}
+
+// AuditList returns entries of the audit trail selected by the query.
+func (a *Adapter) AuditList(q *types.ListQuery) ([]types.AuditEntry, error) {
+	if err := q.Check(&types.AuditEntry{}); err != nil {
+		return nil, err
+	}
+	var all []types.AuditEntry
+	err := a.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAudit).ForEach(func(_, v []byte) error {
+			var e types.AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Matches(&e) {
+				all = append(all, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	trail := make([]types.AuditEntry, 0, len(all))
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		trail = append(trail, all[i])
+	}
+	return trail, nil
+}
+
+func auditKey(ts time.Time, id string) string {
+	return ts.UTC().Format(auditKeyLayout) + "/" + id
+}
+
+// putAudit appends an entry to the audit trail.
+func putAudit(tx *bolt.Tx, e *types.AuditEntry) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	audit := tx.Bucket(bucketAudit)
+	key := auditKey(e.CreatedAt, e.ID)
+	if audit.Get([]byte(key)) != nil {
+		return types.ErrDuplicate
+	}
+	return put(audit, key, e)
+}
+
+// view runs fn in a read-only transaction.
+func (a *Adapter) view(fn func(tx *bolt.Tx) error) error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	return a.db.View(fn)
+}
+
+// update runs fn in a read-write transaction, which is rolled back if fn fails.
+func (a *Adapter) update(fn func(tx *bolt.Tx) error) error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	return a.db.Update(fn)
+}
+
+// updateAudited runs fn in a read-write transaction, which also appends the audit
+// entries, so that the change is rolled back if any of them fails.
+func (a *Adapter) updateAudited(audit []*types.AuditEntry, fn func(tx *bolt.Tx) error) error {
+	return a.update(func(tx *bolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for _, e := range audit {
+			if err := putAudit(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func get(b *bolt.Bucket, key string, v interface{}) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return types.ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func put(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// putNewStream stores a new stream and indexes its key.
+func putNewStream(tx *bolt.Tx, s *types.Stream) error {
+	streams, keys := tx.Bucket(bucketStreams), tx.Bucket(bucketStreamKeys)
+	if streams.Get([]byte(s.ID)) != nil || keys.Get([]byte(s.Key)) != nil {
+		return types.ErrDuplicate
+	}
+	if err := keys.Put([]byte(s.Key), []byte(s.ID)); err != nil {
+		return err
+	}
+	return put(streams, s.ID, s)
+}
+
+// deleteStream removes a stream with its key index and unassigns its devices.
+func deleteStream(tx *bolt.Tx, s *types.Stream) error {
+	err := updateDevices(tx, func(d *types.Device) bool {
+		if d.StreamID != s.ID {
+			return false
+		}
+		d.StreamID = ""
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketStreamKeys).Delete([]byte(s.Key)); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketStreams).Delete([]byte(s.ID))
+}
+
+// updateDevices rewrites every device for which fn reports a change.
+func updateDevices(tx *bolt.Tx, fn func(d *types.Device) bool) error {
+	devices := tx.Bucket(bucketDevices)
+
+	var changed []types.Device
+	err := devices.ForEach(func(_, v []byte) error {
+		var d types.Device
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if fn(&d) {
+			changed = append(changed, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// a bucket must not be modified while it's being iterated.
+	for i := range changed {
+		if err := put(devices, changed[i].Serial, &changed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validAssignment checks that the room and the stream a device is assigned to exist.
+func validAssignment(tx *bolt.Tx, d *types.Device) bool {
+	if d.RoomID != "" && tx.Bucket(bucketRooms).Get([]byte(d.RoomID)) == nil {
+		return false
+	}
+	if d.StreamID != "" && tx.Bucket(bucketStreams).Get([]byte(d.StreamID)) == nil {
+		return false
+	}
+	return true
+}
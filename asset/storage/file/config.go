@@ -0,0 +1,31 @@
+package file
+
+import "time"
+
+// DefaultPath defines the default path of the database file.
+const DefaultPath = "asset.db"
+
+// DefaultLockTimeout defines how long to wait for the database file lock.
+const DefaultLockTimeout = 5 * time.Second
+
+// Config represents embedded file storage configuration.
+type Config struct {
+	// Path is the database file, relative paths are resolved against the directory of the executable.
+	Path string `yaml:"path"`
+	// LockTimeout limits waiting for another process to release the database file.
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+}
+
+// UnmarshalYAML satisfies Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawConfig Config
+
+	parsed := rawConfig{Path: DefaultPath, LockTimeout: DefaultLockTimeout}
+
+	if err := unmarshal(&parsed); err != nil {
+		return err
+	}
+	*c = Config(parsed)
+
+	return nil
+}
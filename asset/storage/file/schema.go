@@ -0,0 +1,137 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+var (
+	bucketMeta = []byte("meta")
+	keyVersion = []byte("version")
+)
+
+// migration upgrades the layout from the previous version to `version`.
+type migration struct {
+	version int
+	apply   func(tx *bolt.Tx) error
+}
+
+// migrations must be kept in ascending order of version. Never edit a released
+// migration, append a new one instead.
+var migrations = []migration{
+	{version: 1, apply: createBuckets(bucketRooms, bucketStreams, bucketStreamKeys, bucketDevices)},
+	{version: 2, apply: createBuckets(bucketAPIKeys)},
+	{version: 3, apply: createBuckets(bucketWebhooks, bucketDeliveries, bucketPending)},
+	{version: 4, apply: createBuckets(bucketHolds)},
+	{version: 5, apply: createBuckets(bucketAudit)},
+}
+
+func createBuckets(names ...[]byte) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Version returns the schema version supported by the adapter.
+func (a *Adapter) Version() int {
+	return migrations[len(migrations)-1].version
+}
+
+// GetDbVersion returns the schema version recorded in the database file.
+func (a *Adapter) GetDbVersion() (int, error) {
+	ver := 0
+	err := a.view(func(tx *bolt.Tx) error {
+		var err error
+		ver, err = readVersion(tx)
+		return err
+	})
+	return ver, err
+}
+
+// CreateDb creates all buckets at the latest version. Existing buckets are dropped
+// first if reset is true, otherwise an initialized database is an error.
+func (a *Adapter) CreateDb(reset bool) error {
+	return a.update(func(tx *bolt.Tx) error {
+		if reset {
+			var names [][]byte
+			err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, append([]byte(nil), name...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		} else if ver, err := readVersion(tx); err == nil {
+			return fmt.Errorf("database is already initialized at version %d", ver)
+		} else if err != types.ErrNotFound {
+			return err
+		}
+
+		if _, err := tx.CreateBucket(bucketMeta); err != nil {
+			return err
+		}
+		return migrate(tx, 0)
+	})
+}
+
+// UpgradeDb applies migrations newer than the recorded schema version.
+func (a *Adapter) UpgradeDb() error {
+	return a.update(func(tx *bolt.Tx) error {
+		ver, err := readVersion(tx)
+		if err == types.ErrNotFound {
+			return errors.New("database is not initialized")
+		}
+		if err != nil {
+			return err
+		}
+		if ver > a.Version() {
+			return fmt.Errorf("database version %d is newer than supported version %d", ver, a.Version())
+		}
+		return migrate(tx, ver)
+	})
+}
+
+// migrate applies all migrations in a single transaction, so the upgrade either
+// completes or leaves the file untouched.
+func migrate(tx *bolt.Tx, from int) error {
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.apply(tx); err != nil {
+			return fmt.Errorf("migration to version %d failed, %v", m.version, err)
+		}
+		err := tx.Bucket(bucketMeta).Put(keyVersion, []byte(strconv.Itoa(m.version)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(bucketMeta)
+	if meta == nil {
+		return 0, types.ErrNotFound
+	}
+	value := meta.Get(keyVersion)
+	if value == nil {
+		return 0, types.ErrNotFound
+	}
+	return strconv.Atoi(string(value))
+}
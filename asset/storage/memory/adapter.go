@@ -0,0 +1,949 @@
+// Package memory implements an in-memory storage adapter. State is lost on restart,
+// so it's only suitable for testing and demos.
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const (
+	adapterName = "memory"
+
+	// schemaVersion is reported to keep the adapter interchangeable with persistent ones.
+	schemaVersion = 1
+)
+
+// Adapter holds all entities in memory.
+type Adapter struct {
+	mu   sync.RWMutex
+	open bool
+
+	rooms   map[string]types.Room
+	streams map[string]types.Stream
+	devices map[string]types.Device
+	apiKeys map[string]types.APIKey
+
+	webhooks   map[string]types.Webhook
+	deliveries map[string]types.Delivery
+	holds      map[string]types.Hold
+	audit      map[string]types.AuditEntry
+}
+
+// NewAdapter returns a new, unopened in-memory adapter.
+func NewAdapter() *Adapter {
+	return &Adapter{}
+}
+
+// Open initializes the adapter.
+func (a *Adapter) Open() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.open {
+		return nil
+	}
+	a.rooms = make(map[string]types.Room)
+	a.streams = make(map[string]types.Stream)
+	a.devices = make(map[string]types.Device)
+	a.apiKeys = make(map[string]types.APIKey)
+	a.webhooks = make(map[string]types.Webhook)
+	a.deliveries = make(map[string]types.Delivery)
+	a.holds = make(map[string]types.Hold)
+	a.audit = make(map[string]types.AuditEntry)
+	a.open = true
+	return nil
+}
+
+// Close drops all data held by the adapter.
+func (a *Adapter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rooms, a.streams, a.devices, a.apiKeys = nil, nil, nil, nil
+	a.webhooks, a.deliveries, a.holds, a.audit = nil, nil, nil, nil
+	a.open = false
+	return nil
+}
+
+// IsOpen checks if the adapter is ready for use.
+func (a *Adapter) IsOpen() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.open
+}
+
+// Name returns the name of the adapter.
+func (a *Adapter) Name() string {
+	return adapterName
+}
+
+// Ping checks that the adapter is usable.
+func (a *Adapter) Ping() error {
+	if !a.IsOpen() {
+		return types.ErrNotOpen
+	}
+	return nil
+}
+
+// Version returns the schema version supported by the adapter.
+func (a *Adapter) Version() int {
+	return schemaVersion
+}
+
+// GetDbVersion returns the schema version of the data, which is always current.
+func (a *Adapter) GetDbVersion() (int, error) {
+	if !a.IsOpen() {
+		return 0, types.ErrNotOpen
+	}
+	return schemaVersion, nil
+}
+
+// CreateDb drops all data if reset is true, otherwise it's a no-op.
+func (a *Adapter) CreateDb(reset bool) error {
+	if !a.IsOpen() {
+		return types.ErrNotOpen
+	}
+	if reset {
+		a.Close()
+		return a.Open()
+	}
+	return nil
+}
+
+// UpgradeDb is a no-op since in-memory data never outlives the process.
+func (a *Adapter) UpgradeDb() error {
+	if !a.IsOpen() {
+		return types.ErrNotOpen
+	}
+	return nil
+}
+
+// RoomCreate creates a room together with its initial streams.
+func (a *Adapter) RoomCreate(room *types.Room, streams []*types.Stream, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.rooms[room.ID]; ok {
+		return types.ErrDuplicate
+	}
+	keys := make(map[string]bool)
+	for _, s := range streams {
+		if s.RoomID != room.ID {
+			return types.ErrMalformed
+		}
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if _, ok := a.streams[s.ID]; ok || keys[s.Key] || a.streamKeyTaken(s.Key, "") {
+			return types.ErrDuplicate
+		}
+		keys[s.Key] = true
+	}
+
+	a.rooms[room.ID] = *room
+	for _, s := range streams {
+		a.streams[s.ID] = *s
+	}
+	a.appendAudit(audit)
+	return nil
+}
+
+// RoomGet returns the room with the given ID.
+func (a *Adapter) RoomGet(id string) (*types.Room, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	room, ok := a.rooms[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &room, nil
+}
+
+// RoomList returns rooms selected by the query.
+func (a *Adapter) RoomList(q *types.ListQuery) ([]types.Room, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Room{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Room, 0, len(a.rooms))
+	for _, v := range a.rooms {
+		all = append(all, v)
+	}
+	rooms := make([]types.Room, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		rooms = append(rooms, all[i])
+	}
+	return rooms, nil
+}
+
+// RoomUpdate updates a room.
+func (a *Adapter) RoomUpdate(room *types.Room, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	old, ok := a.rooms[room.ID]
+	if !ok {
+		return types.ErrNotFound
+	}
+	stored := *room
+	stored.CreatedAt = old.CreatedAt
+	a.rooms[room.ID] = stored
+	a.appendAudit(audit)
+	return nil
+}
+
+// RoomDelete deletes a room, its streams and unassigns its devices.
+func (a *Adapter) RoomDelete(id string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.rooms[id]; !ok {
+		return types.ErrNotFound
+	}
+	for sid, s := range a.streams {
+		if s.RoomID == id {
+			delete(a.streams, sid)
+		}
+	}
+	for serial, d := range a.devices {
+		if d.RoomID == id {
+			d.RoomID, d.StreamID = "", ""
+			a.devices[serial] = d
+		}
+	}
+	delete(a.rooms, id)
+	a.appendAudit(audit)
+	return nil
+}
+
+// StreamCreate creates a stream in an existing room.
+func (a *Adapter) StreamCreate(stream *types.Stream, audit ...*types.AuditEntry) error {
+	if err := stream.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.rooms[stream.RoomID]; !ok {
+		return types.ErrMalformed
+	}
+	if _, ok := a.streams[stream.ID]; ok || a.streamKeyTaken(stream.Key, "") {
+		return types.ErrDuplicate
+	}
+	a.streams[stream.ID] = *stream
+	a.appendAudit(audit)
+	return nil
+}
+
+// StreamGet returns the stream with the given ID.
+func (a *Adapter) StreamGet(id string) (*types.Stream, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	stream, ok := a.streams[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &stream, nil
+}
+
+// StreamList returns streams selected by the query.
+func (a *Adapter) StreamList(q *types.ListQuery) ([]types.Stream, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Stream{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Stream, 0, len(a.streams))
+	for _, v := range a.streams {
+		all = append(all, v)
+	}
+	streams := make([]types.Stream, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		streams = append(streams, all[i])
+	}
+	return streams, nil
+}
+
+// StreamUpdate updates a stream. The owning room cannot be changed.
+func (a *Adapter) StreamUpdate(stream *types.Stream, audit ...*types.AuditEntry) error {
+	if err := stream.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	old, ok := a.streams[stream.ID]
+	if !ok {
+		return types.ErrNotFound
+	}
+	if old.RoomID != stream.RoomID {
+		return types.ErrMalformed
+	}
+	if a.streamKeyTaken(stream.Key, stream.ID) {
+		return types.ErrDuplicate
+	}
+	stored := *stream
+	stored.CreatedAt = old.CreatedAt
+	a.streams[stream.ID] = stored
+	a.appendAudit(audit)
+	return nil
+}
+
+// StreamDelete deletes a stream and unassigns its devices.
+func (a *Adapter) StreamDelete(id string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.streams[id]; !ok {
+		return types.ErrNotFound
+	}
+	for serial, d := range a.devices {
+		if d.StreamID == id {
+			d.StreamID = ""
+			a.devices[serial] = d
+		}
+	}
+	delete(a.streams, id)
+	a.appendAudit(audit)
+	return nil
+}
+
+// DeviceCreate registers a device.
+func (a *Adapter) DeviceCreate(dev *types.Device, audit ...*types.AuditEntry) error {
+	if err := dev.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.devices[dev.Serial]; ok {
+		return types.ErrDuplicate
+	}
+	if !a.validAssignment(dev) {
+		return types.ErrMalformed
+	}
+	a.devices[dev.Serial] = *dev
+	a.appendAudit(audit)
+	return nil
+}
+
+// DeviceGet returns the device with the given serial number.
+func (a *Adapter) DeviceGet(serial string) (*types.Device, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	dev, ok := a.devices[serial]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &dev, nil
+}
+
+// DeviceList returns devices selected by the query.
+func (a *Adapter) DeviceList(q *types.ListQuery) ([]types.Device, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Device{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Device, 0, len(a.devices))
+	for _, v := range a.devices {
+		all = append(all, v)
+	}
+	devices := make([]types.Device, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		devices = append(devices, all[i])
+	}
+	return devices, nil
+}
+
+// DeviceUpdate updates a device.
+func (a *Adapter) DeviceUpdate(dev *types.Device, audit ...*types.AuditEntry) error {
+	if err := dev.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	old, ok := a.devices[dev.Serial]
+	if !ok {
+		return types.ErrNotFound
+	}
+	if !a.validAssignment(dev) {
+		return types.ErrMalformed
+	}
+	stored := *dev
+	stored.CreatedAt, stored.LastSeen = old.CreatedAt, old.LastSeen
+	a.devices[dev.Serial] = stored
+	a.appendAudit(audit)
+	return nil
+}
+
+// DeviceTouch records the time a device was last seen.
+func (a *Adapter) DeviceTouch(serial string, ts time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	d, ok := a.devices[serial]
+	if !ok {
+		return types.ErrNotFound
+	}
+	d.LastSeen = &ts
+	a.devices[serial] = d
+	return nil
+}
+
+// DeviceDelete deletes a device.
+func (a *Adapter) DeviceDelete(serial string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.devices[serial]; !ok {
+		return types.ErrNotFound
+	}
+	delete(a.devices, serial)
+	a.appendAudit(audit)
+	return nil
+}
+
+// APIKeyCreate stores a new API key.
+func (a *Adapter) APIKeyCreate(key *types.APIKey, audit ...*types.AuditEntry) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.apiKeys[key.ID]; ok {
+		return types.ErrDuplicate
+	}
+	a.apiKeys[key.ID] = *key
+	a.appendAudit(audit)
+	return nil
+}
+
+// APIKeyGet returns the API key with the given ID.
+func (a *Adapter) APIKeyGet(id string) (*types.APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	key, ok := a.apiKeys[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &key, nil
+}
+
+// APIKeyList returns API keys selected by the query.
+func (a *Adapter) APIKeyList(q *types.ListQuery) ([]types.APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.APIKey{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.APIKey, 0, len(a.apiKeys))
+	for _, v := range a.apiKeys {
+		all = append(all, v)
+	}
+	keys := make([]types.APIKey, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		keys = append(keys, all[i])
+	}
+	return keys, nil
+}
+
+// APIKeyDelete deletes an API key.
+func (a *Adapter) APIKeyDelete(id string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.apiKeys[id]; !ok {
+		return types.ErrNotFound
+	}
+	delete(a.apiKeys, id)
+	a.appendAudit(audit)
+	return nil
+}
+
+// WebhookCreate creates a webhook subscription.
+func (a *Adapter) WebhookCreate(hook *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.webhooks[hook.ID]; ok {
+		return types.ErrDuplicate
+	}
+	a.webhooks[hook.ID] = *hook
+	a.appendAudit(audit)
+	return nil
+}
+
+// WebhookGet returns the webhook with the given ID.
+func (a *Adapter) WebhookGet(id string) (*types.Webhook, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	hook, ok := a.webhooks[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &hook, nil
+}
+
+// WebhookList returns webhooks selected by the query.
+func (a *Adapter) WebhookList(q *types.ListQuery) ([]types.Webhook, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Webhook{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Webhook, 0, len(a.webhooks))
+	for _, v := range a.webhooks {
+		all = append(all, v)
+	}
+	hooks := make([]types.Webhook, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		hooks = append(hooks, all[i])
+	}
+	return hooks, nil
+}
+
+// WebhookUpdate updates a webhook.
+func (a *Adapter) WebhookUpdate(hook *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	old, ok := a.webhooks[hook.ID]
+	if !ok {
+		return types.ErrNotFound
+	}
+	stored := *hook
+	stored.CreatedAt = old.CreatedAt
+	a.webhooks[hook.ID] = stored
+	a.appendAudit(audit)
+	return nil
+}
+
+// WebhookDelete deletes a webhook together with its deliveries.
+func (a *Adapter) WebhookDelete(id string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.webhooks[id]; !ok {
+		return types.ErrNotFound
+	}
+	for did, d := range a.deliveries {
+		if d.WebhookID == id {
+			delete(a.deliveries, did)
+		}
+	}
+	delete(a.webhooks, id)
+	a.appendAudit(audit)
+	return nil
+}
+
+// DeliveryCreate queues a delivery of an event to a webhook.
+func (a *Adapter) DeliveryCreate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if _, ok := a.webhooks[d.WebhookID]; !ok {
+		return types.ErrMalformed
+	}
+	if _, ok := a.deliveries[d.ID]; ok {
+		return types.ErrDuplicate
+	}
+	a.deliveries[d.ID] = *d
+	return nil
+}
+
+// DeliveryUpdate records the outcome of a delivery attempt.
+func (a *Adapter) DeliveryUpdate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	old, ok := a.deliveries[d.ID]
+	if !ok {
+		return types.ErrNotFound
+	}
+	old.Status, old.Attempts, old.NextAttempt = d.Status, d.Attempts, d.NextAttempt
+	old.LastError, old.ResponseCode, old.UpdatedAt = d.LastError, d.ResponseCode, d.UpdatedAt
+	a.deliveries[d.ID] = old
+	return nil
+}
+
+// DeliveryList returns deliveries selected by the query.
+func (a *Adapter) DeliveryList(q *types.ListQuery) ([]types.Delivery, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Delivery{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Delivery, 0, len(a.deliveries))
+	for _, v := range a.deliveries {
+		all = append(all, v)
+	}
+	deliveries := make([]types.Delivery, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		deliveries = append(deliveries, all[i])
+	}
+	return deliveries, nil
+}
+
+// DeliveryDue returns up to limit pending deliveries due at the given time, oldest first.
+func (a *Adapter) DeliveryDue(now time.Time, limit int) ([]types.Delivery, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	list := make([]types.Delivery, 0)
+	for _, d := range a.deliveries {
+		if d.Status == types.DeliveryPending && !d.NextAttempt.After(now) {
+			list = append(list, d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].NextAttempt.Equal(list[j].NextAttempt) {
+			return list[i].NextAttempt.Before(list[j].NextAttempt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// HoldCreate places a legal hold on recordings of an existing stream.
+func (a *Adapter) HoldCreate(hold *types.Hold, audit ...*types.AuditEntry) error {
+	if err := hold.Validate(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.streams[hold.StreamID]; !ok {
+		return types.ErrMalformed
+	}
+	if _, ok := a.holds[hold.ID]; ok {
+		return types.ErrDuplicate
+	}
+	a.holds[hold.ID] = *hold
+	a.appendAudit(audit)
+	return nil
+}
+
+// HoldGet returns the hold with the given ID.
+func (a *Adapter) HoldGet(id string) (*types.Hold, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	hold, ok := a.holds[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return &hold, nil
+}
+
+// HoldList returns holds selected by the query.
+func (a *Adapter) HoldList(q *types.ListQuery) ([]types.Hold, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.Hold{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.Hold, 0, len(a.holds))
+	for _, v := range a.holds {
+		all = append(all, v)
+	}
+	holds := make([]types.Hold, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		holds = append(holds, all[i])
+	}
+	return holds, nil
+}
+
+// HoldDelete releases a hold.
+func (a *Adapter) HoldDelete(id string, audit ...*types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit(audit); err != nil {
+		return err
+	}
+	if _, ok := a.holds[id]; !ok {
+		return types.ErrNotFound
+	}
+	delete(a.holds, id)
+	a.appendAudit(audit)
+	return nil
+}
+
+// AuditAppend appends an entry to the audit trail.
+func (a *Adapter) AuditAppend(e *types.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.open {
+		return types.ErrNotOpen
+	}
+	if err := a.checkAudit([]*types.AuditEntry{e}); err != nil {
+		return err
+	}
+	a.appendAudit([]*types.AuditEntry{e})
+	return nil
+}
+
+// AuditList returns entries of the audit trail selected by the query.
+func (a *Adapter) AuditList(q *types.ListQuery) ([]types.AuditEntry, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if !a.open {
+		return nil, types.ErrNotOpen
+	}
+	if err := q.Check(&types.AuditEntry{}); err != nil {
+		return nil, err
+	}
+	all := make([]types.AuditEntry, 0, len(a.audit))
+	for _, v := range a.audit {
+		all = append(all, v)
+	}
+	trail := make([]types.AuditEntry, 0)
+	for _, i := range q.Select(len(all), func(i int) types.Listed { return &all[i] }) {
+		trail = append(trail, all[i])
+	}
+	return trail, nil
+}
+
+// checkAudit validates entries to be appended along with a change, before anything changes.
+func (a *Adapter) checkAudit(audit []*types.AuditEntry) error {
+	for _, e := range audit {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+		if _, ok := a.audit[e.ID]; ok {
+			return types.ErrDuplicate
+		}
+	}
+	return nil
+}
+
+// appendAudit appends entries checked by checkAudit to the audit trail.
+func (a *Adapter) appendAudit(audit []*types.AuditEntry) {
+	for _, e := range audit {
+		a.audit[e.ID] = *e
+	}
+}
+
+// streamKeyTaken checks if key is used by a stream other than the one with ID `except`.
+func (a *Adapter) streamKeyTaken(key, except string) bool {
+	for id, s := range a.streams {
+		if s.Key == key && id != except {
+			return true
+		}
+	}
+	return false
+}
+
+// validAssignment checks that the room and the stream a device is assigned to exist.
+func (a *Adapter) validAssignment(dev *types.Device) bool {
+	if dev.RoomID != "" {
+		if _, ok := a.rooms[dev.RoomID]; !ok {
+			return false
+		}
+	}
+	if dev.StreamID != "" {
+		if _, ok := a.streams[dev.StreamID]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	ms "github.com/go-sql-driver/mysql"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// migration upgrades the schema from the previous version to `version`.
+type migration struct {
+	version int
+	stmts   []string
+}
+
+// migrations must be kept in ascending order of version. Never edit a released
+// migration, append a new one instead.
+var migrations = []migration{
+	{version: 1, stmts: []string{
+		`CREATE TABLE rooms(
+			id         VARCHAR(64) NOT NULL,
+			name       VARCHAR(255) NOT NULL DEFAULT '',
+			created_at DATETIME(3) NOT NULL,
+			updated_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE TABLE streams(
+			id         VARCHAR(64) NOT NULL,
+			room_id    VARCHAR(64) NOT NULL,
+			type       VARCHAR(16) NOT NULL,
+			stream_key VARCHAR(255) NOT NULL,
+			created_at DATETIME(3) NOT NULL,
+			updated_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id),
+			UNIQUE INDEX streams_stream_key(stream_key),
+			FOREIGN KEY(room_id) REFERENCES rooms(id)
+		)`,
+		`CREATE TABLE devices(
+			serial     VARCHAR(64) NOT NULL,
+			model      VARCHAR(255) NOT NULL DEFAULT '',
+			room_id    VARCHAR(64),
+			stream_id  VARCHAR(64),
+			created_at DATETIME(3) NOT NULL,
+			updated_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(serial),
+			FOREIGN KEY(room_id) REFERENCES rooms(id),
+			FOREIGN KEY(stream_id) REFERENCES streams(id)
+		)`,
+	}},
+	{version: 2, stmts: []string{
+		`ALTER TABLE devices ADD COLUMN last_seen DATETIME(3)`,
+	}},
+	{version: 3, stmts: []string{
+		`CREATE TABLE apikeys(
+			id         VARCHAR(64) NOT NULL,
+			name       VARCHAR(255) NOT NULL DEFAULT '',
+			hash       CHAR(64) NOT NULL,
+			created_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+	}},
+	{version: 4, stmts: []string{
+		`CREATE TABLE webhooks(
+			id         VARCHAR(64) NOT NULL,
+			url        VARCHAR(2048) NOT NULL,
+			secret     VARCHAR(255) NOT NULL DEFAULT '',
+			events     TEXT NOT NULL,
+			created_at DATETIME(3) NOT NULL,
+			updated_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE TABLE deliveries(
+			id            VARCHAR(64) NOT NULL,
+			webhook_id    VARCHAR(64) NOT NULL,
+			event_id      VARCHAR(64) NOT NULL,
+			event_type    VARCHAR(64) NOT NULL,
+			payload       MEDIUMTEXT NOT NULL,
+			status        VARCHAR(16) NOT NULL,
+			attempts      INT NOT NULL DEFAULT 0,
+			next_attempt  DATETIME(3) NOT NULL,
+			last_error    TEXT,
+			response_code INT NOT NULL DEFAULT 0,
+			created_at    DATETIME(3) NOT NULL,
+			updated_at    DATETIME(3) NOT NULL,
+			PRIMARY KEY(id),
+			INDEX deliveries_due(status, next_attempt),
+			INDEX deliveries_webhook(webhook_id, created_at),
+			FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+		)`,
+	}},
+	{version: 5, stmts: []string{
+		`CREATE TABLE holds(
+			id         VARCHAR(64) NOT NULL,
+			stream_id  VARCHAR(64) NOT NULL,
+			from_time  DATETIME(3),
+			to_time    DATETIME(3),
+			reason     TEXT,
+			created_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id),
+			INDEX holds_stream(stream_id)
+		)`,
+	}},
+	{version: 6, stmts: []string{
+		`CREATE TABLE audit(
+			id         VARCHAR(64) NOT NULL,
+			actor      VARCHAR(255) NOT NULL,
+			action     VARCHAR(16) NOT NULL,
+			entity     VARCHAR(32) NOT NULL,
+			entity_id  VARCHAR(255) NOT NULL,
+			before_doc MEDIUMTEXT,
+			after_doc  MEDIUMTEXT,
+			source_ip  VARCHAR(64) NOT NULL DEFAULT '',
+			request_id VARCHAR(64) NOT NULL DEFAULT '',
+			created_at DATETIME(3) NOT NULL,
+			PRIMARY KEY(id),
+			INDEX audit_time(created_at),
+			INDEX audit_entity(entity, entity_id, created_at)
+		)`,
+	}},
+	// lists are filtered and sorted by these, see listQuery.
+	{version: 7, stmts: []string{
+		`CREATE INDEX rooms_name ON rooms(name, id)`,
+		`CREATE INDEX rooms_created ON rooms(created_at, id)`,
+		`CREATE INDEX devices_last_seen ON devices(last_seen)`,
+	}},
+}
+
+// tables lists all tables in reverse order of dependency, to be dropped on reset.
+var tables = []string{"audit", "holds", "deliveries", "webhooks", "apikeys", "devices", "streams", "rooms", "kvmeta"}
+
+const (
+	sqlMetaCreate = `CREATE TABLE IF NOT EXISTS kvmeta(
+		name  VARCHAR(64) NOT NULL,
+		value TEXT,
+		PRIMARY KEY(name)
+	)`
+	sqlVersionGet = "SELECT value FROM kvmeta WHERE name='version'"
+	sqlVersionSet = "INSERT INTO kvmeta(name,value) VALUES('version',?) ON DUPLICATE KEY UPDATE value=VALUES(value)"
+)
+
+// Version returns the schema version supported by the adapter.
+func (a *Adapter) Version() int {
+	return migrations[len(migrations)-1].version
+}
+
+// GetDbVersion returns the schema version recorded in the database.
+func (a *Adapter) GetDbVersion() (int, error) {
+	if a.db == nil {
+		return 0, types.ErrNotOpen
+	}
+	var value string
+	if err := a.db.QueryRow(sqlVersionGet).Scan(&value); err != nil {
+		if err == sql.ErrNoRows || isMissingTable(err) {
+			return 0, types.ErrNotFound
+		}
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// CreateDb creates the schema at the latest version. Existing tables are dropped
+// first if reset is true, otherwise an initialized database is an error.
+func (a *Adapter) CreateDb(reset bool) error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	if reset {
+		for _, table := range tables {
+			if _, err := a.db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+				return err
+			}
+		}
+	} else if ver, err := a.GetDbVersion(); err == nil {
+		return fmt.Errorf("database is already initialized at version %d", ver)
+	} else if err != types.ErrNotFound {
+		return err
+	}
+
+	if _, err := a.db.Exec(sqlMetaCreate); err != nil {
+		return err
+	}
+	return a.migrate(0)
+}
+
+// UpgradeDb applies migrations newer than the recorded schema version.
+func (a *Adapter) UpgradeDb() error {
+	ver, err := a.GetDbVersion()
+	if err == types.ErrNotFound {
+		return errors.New("database is not initialized")
+	}
+	if err != nil {
+		return err
+	}
+	if ver > a.Version() {
+		return fmt.Errorf("database version %d is newer than supported version %d", ver, a.Version())
+	}
+	return a.migrate(ver)
+}
+
+// DDL statements are not transactional in MySQL, so the version is recorded after each
+// migration, letting an interrupted upgrade resume from the last completed step.
+func (a *Adapter) migrate(from int) error {
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		if err := a.applyMigration(m); err != nil {
+			return fmt.Errorf("migration to version %d failed, %v", m.version, err)
+		}
+	}
+	return nil
+}
+
+func (a *Adapter) applyMigration(m migration) error {
+	for _, stmt := range m.stmts {
+		if _, err := a.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	_, err := a.db.Exec(sqlVersionSet, strconv.Itoa(m.version))
+	return err
+}
+
+// isMissingTable checks if the error is caused by querying a table which doesn't exist.
+func isMissingTable(err error) bool {
+	myErr, ok := err.(*ms.MySQLError)
+	return ok && myErr.Number == errNoSuchTable
+}
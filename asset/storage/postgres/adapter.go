@@ -0,0 +1,970 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+const adapterName = "postgres"
+
+// PostgreSQL error codes the adapter translates into storage errors.
+const (
+	errUniqueViolation     = "23505"
+	errForeignKeyViolation = "23503"
+	errUndefinedTable      = "42P01"
+
+	// error classes of connection failures and server shutdown.
+	errClassConnection = "08"
+	errClassOperator   = "57"
+)
+
+const (
+	sqlRoomInsert = "INSERT INTO rooms(id,name,created_at,updated_at) VALUES($1,$2,$3,$4)"
+	sqlRoomGet    = "SELECT id,name,created_at,updated_at FROM rooms WHERE id=$1"
+	sqlRoomSelect = "SELECT id,name,created_at,updated_at FROM rooms"
+	sqlRoomUpdate = "UPDATE rooms SET name=$1,updated_at=$2 WHERE id=$3"
+	sqlRoomDelete = "DELETE FROM rooms WHERE id=$1"
+
+	sqlStreamInsert       = "INSERT INTO streams(id,room_id,type,stream_key,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6)"
+	sqlStreamGet          = "SELECT id,room_id,type,stream_key,created_at,updated_at FROM streams WHERE id=$1"
+	sqlStreamSelect       = "SELECT id,room_id,type,stream_key,created_at,updated_at FROM streams"
+	sqlStreamUpdate       = "UPDATE streams SET type=$1,stream_key=$2,updated_at=$3 WHERE id=$4 AND room_id=$5"
+	sqlStreamDelete       = "DELETE FROM streams WHERE id=$1"
+	sqlStreamDeleteByRoom = "DELETE FROM streams WHERE room_id=$1"
+
+	sqlDeviceInsert         = "INSERT INTO devices(serial,model,room_id,stream_id,last_seen,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6,$7)"
+	sqlDeviceGet            = "SELECT serial,model,room_id,stream_id,last_seen,created_at,updated_at FROM devices WHERE serial=$1"
+	sqlDeviceSelect         = "SELECT serial,model,room_id,stream_id,last_seen,created_at,updated_at FROM devices"
+	sqlDeviceUpdate         = "UPDATE devices SET model=$1,room_id=$2,stream_id=$3,updated_at=$4 WHERE serial=$5"
+	sqlDeviceTouch          = "UPDATE devices SET last_seen=$1 WHERE serial=$2"
+	sqlDeviceDelete         = "DELETE FROM devices WHERE serial=$1"
+	sqlDeviceUnassignRoom   = "UPDATE devices SET room_id=NULL,stream_id=NULL WHERE room_id=$1"
+	sqlDeviceUnassignStream = "UPDATE devices SET stream_id=NULL WHERE stream_id=$1"
+
+	sqlAPIKeyInsert = "INSERT INTO apikeys(id,name,hash,created_at) VALUES($1,$2,$3,$4)"
+	sqlAPIKeyGet    = "SELECT id,name,hash,created_at FROM apikeys WHERE id=$1"
+	sqlAPIKeySelect = "SELECT id,name,hash,created_at FROM apikeys"
+	sqlAPIKeyDelete = "DELETE FROM apikeys WHERE id=$1"
+
+	sqlWebhookInsert = "INSERT INTO webhooks(id,url,secret,events,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6)"
+	sqlWebhookGet    = "SELECT id,url,secret,events,created_at,updated_at FROM webhooks WHERE id=$1"
+	sqlWebhookSelect = "SELECT id,url,secret,events,created_at,updated_at FROM webhooks"
+	sqlWebhookUpdate = "UPDATE webhooks SET url=$1,secret=$2,events=$3,updated_at=$4 WHERE id=$5"
+	sqlWebhookDelete = "DELETE FROM webhooks WHERE id=$1"
+
+	sqlDeliveryInsert = "INSERT INTO deliveries(id,webhook_id,event_id,event_type,payload,status,attempts,next_attempt," +
+		"last_error,response_code,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)"
+	sqlDeliveryUpdate = "UPDATE deliveries SET status=$1,attempts=$2,next_attempt=$3,last_error=$4,response_code=$5,updated_at=$6 WHERE id=$7"
+	sqlDeliverySelect = "SELECT id,webhook_id,event_id,event_type,payload,status,attempts,next_attempt,last_error,response_code," +
+		"created_at,updated_at FROM deliveries"
+	sqlDeliveryDue = "SELECT id,webhook_id,event_id,event_type,payload,status,attempts,next_attempt,last_error,response_code," +
+		"created_at,updated_at FROM deliveries WHERE status=$1 AND next_attempt<=$2 ORDER BY next_attempt,id LIMIT $3"
+	sqlDeliveryDeleteByWebhook = "DELETE FROM deliveries WHERE webhook_id=$1"
+
+	// holds outlive their streams, so the stream is checked on insert instead of by a foreign key.
+	sqlHoldInsert = "INSERT INTO holds(id,stream_id,from_time,to_time,reason,created_at) " +
+		"SELECT $1::VARCHAR,id,$2::TIMESTAMPTZ,$3::TIMESTAMPTZ,$4::TEXT,$5::TIMESTAMPTZ FROM streams WHERE id=$6"
+	sqlHoldGet    = "SELECT id,stream_id,from_time,to_time,reason,created_at FROM holds WHERE id=$1"
+	sqlHoldSelect = "SELECT id,stream_id,from_time,to_time,reason,created_at FROM holds"
+	sqlHoldDelete = "DELETE FROM holds WHERE id=$1"
+
+	sqlAuditInsert = "INSERT INTO audit(id,actor,action,entity,entity_id,before_doc,after_doc,source_ip,request_id,created_at) " +
+		"VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)"
+	sqlAuditSelect = "SELECT id,actor,action,entity,entity_id,before_doc,after_doc,source_ip,request_id,created_at FROM audit"
+)
+
+// Adapter is a PostgreSQL storage adapter.
+type Adapter struct {
+	cfg *Config
+	db  *sql.DB
+
+	// prepared statements, keyed by query text.
+	stmtsLock sync.Mutex
+	stmts     map[string]*sql.Stmt
+}
+
+// NewAdapter returns a new, unopened PostgreSQL adapter.
+func NewAdapter(cfg *Config) *Adapter {
+	return &Adapter{cfg: cfg}
+}
+
+// Open connects to the database and sizes the connection pool.
+func (a *Adapter) Open() error {
+	if a.db != nil {
+		return errors.New("postgres adapter is already open")
+	}
+
+	sslMode := a.cfg.SSLMode
+	if sslMode == "" {
+		sslMode = DefaultSSLMode
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(a.cfg.User, a.cfg.Password),
+		Host:     a.cfg.Host,
+		Path:     "/" + a.cfg.Database,
+		RawQuery: url.Values{"sslmode": {sslMode}, "timezone": {"UTC"}}.Encode(),
+	}
+
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		return err
+	}
+
+	poolSize := a.cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = DefaultPoolSize
+	}
+	db.SetMaxOpenConns(poolSize)
+	db.SetMaxIdleConns(poolSize)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+
+	a.db = db
+	a.stmts = make(map[string]*sql.Stmt)
+	return nil
+}
+
+// Close closes prepared statements and the connection pool.
+func (a *Adapter) Close() error {
+	if a.db == nil {
+		return nil
+	}
+
+	a.stmtsLock.Lock()
+	for _, stmt := range a.stmts {
+		stmt.Close()
+	}
+	a.stmts = nil
+	a.stmtsLock.Unlock()
+
+	err := a.db.Close()
+	a.db = nil
+	return err
+}
+
+// IsOpen checks if the adapter is ready for use.
+func (a *Adapter) IsOpen() bool {
+	return a.db != nil
+}
+
+// Name returns the name of the adapter.
+func (a *Adapter) Name() string {
+	return adapterName
+}
+
+// Ping checks that the database is reachable.
+func (a *Adapter) Ping() error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	return a.db.Ping()
+}
+
+// RoomCreate creates a room together with its initial streams in one transaction.
+func (a *Adapter) RoomCreate(room *types.Room, streams []*types.Stream, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if s.RoomID != room.ID {
+			return types.ErrMalformed
+		}
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		if err := a.txExec(tx, sqlRoomInsert, room.ID, room.Name, room.CreatedAt, room.UpdatedAt); err != nil {
+			return err
+		}
+		for _, s := range streams {
+			if err := a.txExec(tx, sqlStreamInsert, s.ID, s.RoomID, string(s.Type), s.Key, s.CreatedAt, s.UpdatedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RoomGet returns the room with the given ID.
+func (a *Adapter) RoomGet(id string) (*types.Room, error) {
+	stmt, err := a.prepare(sqlRoomGet)
+	if err != nil {
+		return nil, err
+	}
+	room, err := scanRoom(stmt.QueryRow(id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return room, nil
+}
+
+// RoomList returns rooms selected by the query.
+func (a *Adapter) RoomList(q *types.ListQuery) ([]types.Room, error) {
+	query, args, err := listQuery(sqlRoomSelect, roomColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]types.Room, 0)
+	for rows.Next() {
+		e, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *e)
+	}
+	return rooms, rows.Err()
+}
+
+// RoomUpdate updates a room.
+func (a *Adapter) RoomUpdate(room *types.Room, audit ...*types.AuditEntry) error {
+	if err := room.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlRoomUpdate, room.Name, room.UpdatedAt, room.ID)
+	})
+}
+
+// RoomDelete deletes a room, its streams and unassigns its devices in one transaction.
+func (a *Adapter) RoomDelete(id string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		if err := a.txExec(tx, sqlDeviceUnassignRoom, id); err != nil {
+			return err
+		}
+		if err := a.txExec(tx, sqlStreamDeleteByRoom, id); err != nil {
+			return err
+		}
+		return a.txExecOne(tx, sqlRoomDelete, id)
+	})
+}
+
+// StreamCreate creates a stream in an existing room.
+func (a *Adapter) StreamCreate(s *types.Stream, audit ...*types.AuditEntry) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExec(tx, sqlStreamInsert, s.ID, s.RoomID, string(s.Type), s.Key, s.CreatedAt, s.UpdatedAt)
+	})
+}
+
+// StreamGet returns the stream with the given ID.
+func (a *Adapter) StreamGet(id string) (*types.Stream, error) {
+	stmt, err := a.prepare(sqlStreamGet)
+	if err != nil {
+		return nil, err
+	}
+	s, err := scanStream(stmt.QueryRow(id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return s, nil
+}
+
+// StreamList returns streams selected by the query.
+func (a *Adapter) StreamList(q *types.ListQuery) ([]types.Stream, error) {
+	query, args, err := listQuery(sqlStreamSelect, streamColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streams := make([]types.Stream, 0)
+	for rows.Next() {
+		e, err := scanStream(rows)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, *e)
+	}
+	return streams, rows.Err()
+}
+
+// StreamUpdate updates a stream. The owning room cannot be changed.
+func (a *Adapter) StreamUpdate(s *types.Stream, audit ...*types.AuditEntry) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	err := a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlStreamUpdate, string(s.Type), s.Key, s.UpdatedAt, s.ID, s.RoomID)
+	})
+	if err == types.ErrNotFound {
+		// tell a missing stream apart from an attempt to move it to another room.
+		if _, gerr := a.StreamGet(s.ID); gerr == nil {
+			return types.ErrMalformed
+		}
+	}
+	return err
+}
+
+// StreamDelete deletes a stream and unassigns its devices in one transaction.
+func (a *Adapter) StreamDelete(id string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		if err := a.txExec(tx, sqlDeviceUnassignStream, id); err != nil {
+			return err
+		}
+		return a.txExecOne(tx, sqlStreamDelete, id)
+	})
+}
+
+// DeviceCreate registers a device.
+func (a *Adapter) DeviceCreate(d *types.Device, audit ...*types.AuditEntry) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExec(tx, sqlDeviceInsert, d.Serial, d.Model, nullString(d.RoomID), nullString(d.StreamID),
+			nullTime(d.LastSeen), d.CreatedAt, d.UpdatedAt)
+	})
+}
+
+// DeviceGet returns the device with the given serial number.
+func (a *Adapter) DeviceGet(serial string) (*types.Device, error) {
+	stmt, err := a.prepare(sqlDeviceGet)
+	if err != nil {
+		return nil, err
+	}
+	d, err := scanDevice(stmt.QueryRow(serial))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return d, nil
+}
+
+// DeviceList returns devices selected by the query.
+func (a *Adapter) DeviceList(q *types.ListQuery) ([]types.Device, error) {
+	query, args, err := listQuery(sqlDeviceSelect, deviceColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]types.Device, 0)
+	for rows.Next() {
+		e, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *e)
+	}
+	return devices, rows.Err()
+}
+
+// DeviceUpdate updates a device.
+func (a *Adapter) DeviceUpdate(d *types.Device, audit ...*types.AuditEntry) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlDeviceUpdate, d.Model, nullString(d.RoomID), nullString(d.StreamID), d.UpdatedAt, d.Serial)
+	})
+}
+
+// DeviceTouch records the time a device was last seen.
+func (a *Adapter) DeviceTouch(serial string, ts time.Time) error {
+	return a.execOne(sqlDeviceTouch, ts, serial)
+}
+
+// DeviceDelete deletes a device.
+func (a *Adapter) DeviceDelete(serial string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlDeviceDelete, serial)
+	})
+}
+
+// APIKeyCreate stores a new API key.
+func (a *Adapter) APIKeyCreate(k *types.APIKey, audit ...*types.AuditEntry) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExec(tx, sqlAPIKeyInsert, k.ID, k.Name, k.Hash, k.CreatedAt)
+	})
+}
+
+// APIKeyGet returns the API key with the given ID.
+func (a *Adapter) APIKeyGet(id string) (*types.APIKey, error) {
+	stmt, err := a.prepare(sqlAPIKeyGet)
+	if err != nil {
+		return nil, err
+	}
+	k, err := scanAPIKey(stmt.QueryRow(id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return k, nil
+}
+
+// APIKeyList returns API keys selected by the query.
+func (a *Adapter) APIKeyList(q *types.ListQuery) ([]types.APIKey, error) {
+	query, args, err := listQuery(sqlAPIKeySelect, apiKeyColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]types.APIKey, 0)
+	for rows.Next() {
+		e, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *e)
+	}
+	return keys, rows.Err()
+}
+
+// APIKeyDelete deletes an API key.
+func (a *Adapter) APIKeyDelete(id string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlAPIKeyDelete, id)
+	})
+}
+
+// WebhookCreate creates a webhook subscription.
+func (a *Adapter) WebhookCreate(h *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExec(tx, sqlWebhookInsert, h.ID, h.URL, h.Secret, joinEvents(h.Events), h.CreatedAt, h.UpdatedAt)
+	})
+}
+
+// WebhookGet returns the webhook with the given ID.
+func (a *Adapter) WebhookGet(id string) (*types.Webhook, error) {
+	stmt, err := a.prepare(sqlWebhookGet)
+	if err != nil {
+		return nil, err
+	}
+	h, err := scanWebhook(stmt.QueryRow(id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return h, nil
+}
+
+// WebhookList returns webhooks selected by the query.
+func (a *Adapter) WebhookList(q *types.ListQuery) ([]types.Webhook, error) {
+	query, args, err := listQuery(sqlWebhookSelect, webhookColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]types.Webhook, 0)
+	for rows.Next() {
+		e, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *e)
+	}
+	return hooks, rows.Err()
+}
+
+// WebhookUpdate updates a webhook.
+func (a *Adapter) WebhookUpdate(h *types.Webhook, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlWebhookUpdate, h.URL, h.Secret, joinEvents(h.Events), h.UpdatedAt, h.ID)
+	})
+}
+
+// WebhookDelete deletes a webhook together with its deliveries in one transaction.
+func (a *Adapter) WebhookDelete(id string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		if err := a.txExec(tx, sqlDeliveryDeleteByWebhook, id); err != nil {
+			return err
+		}
+		return a.txExecOne(tx, sqlWebhookDelete, id)
+	})
+}
+
+// DeliveryCreate queues a delivery of an event to a webhook.
+func (a *Adapter) DeliveryCreate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	stmt, err := a.prepare(sqlDeliveryInsert)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(d.ID, d.WebhookID, d.EventID, d.EventType, string(d.Payload), string(d.Status), d.Attempts,
+		d.NextAttempt, nullString(d.LastError), d.ResponseCode, d.CreatedAt, d.UpdatedAt)
+	return convertError(err)
+}
+
+// DeliveryUpdate records the outcome of a delivery attempt.
+func (a *Adapter) DeliveryUpdate(d *types.Delivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return a.execOne(sqlDeliveryUpdate, string(d.Status), d.Attempts, d.NextAttempt, nullString(d.LastError),
+		d.ResponseCode, d.UpdatedAt, d.ID)
+}
+
+// DeliveryList returns deliveries selected by the query.
+func (a *Adapter) DeliveryList(q *types.ListQuery) ([]types.Delivery, error) {
+	query, args, err := listQuery(sqlDeliverySelect, deliveryColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	return a.queryDeliveries(q, query, args...)
+}
+
+// DeliveryDue returns up to limit pending deliveries due at the given time, oldest first.
+func (a *Adapter) DeliveryDue(now time.Time, limit int) ([]types.Delivery, error) {
+	return a.queryDeliveries(nil, sqlDeliveryDue, string(types.DeliveryPending), now, queryLimit(limit))
+}
+
+func (a *Adapter) queryDeliveries(q *types.ListQuery, query string, args ...interface{}) ([]types.Delivery, error) {
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]types.Delivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *d)
+	}
+	return list, rows.Err()
+}
+
+// HoldCreate places a legal hold on recordings of an existing stream.
+func (a *Adapter) HoldCreate(h *types.Hold, audit ...*types.AuditEntry) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	err := a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlHoldInsert, h.ID, nullTime(h.From), nullTime(h.To), nullString(h.Reason), h.CreatedAt, h.StreamID)
+	})
+	if err == types.ErrNotFound {
+		return types.ErrMalformed
+	}
+	return err
+}
+
+// HoldGet returns the hold with the given ID.
+func (a *Adapter) HoldGet(id string) (*types.Hold, error) {
+	stmt, err := a.prepare(sqlHoldGet)
+	if err != nil {
+		return nil, err
+	}
+	h, err := scanHold(stmt.QueryRow(id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return h, nil
+}
+
+// HoldList returns holds selected by the query.
+func (a *Adapter) HoldList(q *types.ListQuery) ([]types.Hold, error) {
+	query, args, err := listQuery(sqlHoldSelect, holdColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make([]types.Hold, 0)
+	for rows.Next() {
+		e, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *e)
+	}
+	return holds, rows.Err()
+}
+
+// HoldDelete releases a hold.
+func (a *Adapter) HoldDelete(id string, audit ...*types.AuditEntry) error {
+	return a.withAudit(audit, func(tx *sql.Tx) error {
+		return a.txExecOne(tx, sqlHoldDelete, id)
+	})
+}
+
+// AuditAppend appends an entry to the audit trail.
+func (a *Adapter) AuditAppend(e *types.AuditEntry) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	stmt, err := a.prepare(sqlAuditInsert)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(auditArgs(e)...)
+	return convertError(err)
+}
+
+// AuditList returns entries of the audit trail selected by the query.
+func (a *Adapter) AuditList(q *types.ListQuery) ([]types.AuditEntry, error) {
+	query, args, err := listQuery(sqlAuditSelect, auditColumns, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.listRows(q, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trail := make([]types.AuditEntry, 0)
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		trail = append(trail, *e)
+	}
+	return trail, rows.Err()
+}
+
+// prepare returns a cached prepared statement for the query, preparing it on first use.
+func (a *Adapter) prepare(query string) (*sql.Stmt, error) {
+	if a.db == nil {
+		return nil, types.ErrNotOpen
+	}
+
+	a.stmtsLock.Lock()
+	defer a.stmtsLock.Unlock()
+
+	if stmt, ok := a.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := a.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	a.stmts[query] = stmt
+	return stmt, nil
+}
+
+// listRows runs a select statement of a list. Statements with IN lists vary with
+// the number of values, so they are not kept prepared.
+func (a *Adapter) listRows(q *types.ListQuery, query string, args []interface{}) (*sql.Rows, error) {
+	if q != nil && len(q.In) > 0 {
+		if a.db == nil {
+			return nil, types.ErrNotOpen
+		}
+		return a.db.Query(query, args...)
+	}
+	stmt, err := a.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+// execOne executes a prepared statement which must affect exactly one row.
+func (a *Adapter) execOne(query string, args ...interface{}) error {
+	stmt, err := a.prepare(query)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(args...)
+	return checkOne(res, err)
+}
+
+// withTx runs fn in a transaction, committing if it succeeds and rolling back otherwise.
+func (a *Adapter) withTx(fn func(tx *sql.Tx) error) error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return convertError(err)
+	}
+	return tx.Commit()
+}
+
+// withAudit runs fn in a transaction which also appends the audit entries, so that
+// the change is rolled back if any of them fails.
+func (a *Adapter) withAudit(audit []*types.AuditEntry, fn func(tx *sql.Tx) error) error {
+	return a.withTx(func(tx *sql.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for _, e := range audit {
+			if err := e.Validate(); err != nil {
+				return err
+			}
+			if err := a.txExec(tx, sqlAuditInsert, auditArgs(e)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// auditArgs are values of sqlAuditInsert for the entry.
+func auditArgs(e *types.AuditEntry) []interface{} {
+	return []interface{}{e.ID, e.Actor, string(e.Action), e.Entity, e.EntityID, nullString(string(e.Before)),
+		nullString(string(e.After)), e.SourceIP, e.RequestID, e.CreatedAt}
+}
+
+// txExec executes a prepared statement within the transaction.
+func (a *Adapter) txExec(tx *sql.Tx, query string, args ...interface{}) error {
+	stmt, err := a.prepare(query)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Stmt(stmt).Exec(args...)
+	return err
+}
+
+// txExecOne executes a prepared statement within the transaction which must affect exactly one row.
+func (a *Adapter) txExecOne(tx *sql.Tx, query string, args ...interface{}) error {
+	stmt, err := a.prepare(query)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Stmt(stmt).Exec(args...)
+	return checkOne(res, err)
+}
+
+func checkOne(res sql.Result, err error) error {
+	if err != nil {
+		return convertError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
+
+// convertError translates driver errors into storage errors.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	if err == driver.ErrBadConn {
+		return types.ErrUnavailable
+	}
+	if _, ok := err.(net.Error); ok {
+		return types.ErrUnavailable
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case errUniqueViolation:
+			return types.ErrDuplicate
+		case errForeignKeyViolation:
+			return types.ErrMalformed
+		}
+		switch pqErr.Code.Class() {
+		case errClassConnection, errClassOperator:
+			return types.ErrUnavailable
+		}
+	}
+	return err
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRoom(row scanner) (*types.Room, error) {
+	var r types.Room
+	if err := row.Scan(&r.ID, &r.Name, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func scanStream(row scanner) (*types.Stream, error) {
+	var (
+		s   types.Stream
+		typ string
+	)
+	if err := row.Scan(&s.ID, &s.RoomID, &typ, &s.Key, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.Type = types.StreamType(typ)
+	return &s, nil
+}
+
+func scanDevice(row scanner) (*types.Device, error) {
+	var (
+		d              types.Device
+		roomID, stream sql.NullString
+		lastSeen       sql.NullTime
+	)
+	if err := row.Scan(&d.Serial, &d.Model, &roomID, &stream, &lastSeen, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	d.RoomID, d.StreamID = roomID.String, stream.String
+	if lastSeen.Valid {
+		d.LastSeen = &lastSeen.Time
+	}
+	return &d, nil
+}
+
+func scanAPIKey(row scanner) (*types.APIKey, error) {
+	var k types.APIKey
+	if err := row.Scan(&k.ID, &k.Name, &k.Hash, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func scanWebhook(row scanner) (*types.Webhook, error) {
+	var (
+		h      types.Webhook
+		events string
+	)
+	if err := row.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.CreatedAt, &h.UpdatedAt); err != nil {
+		return nil, err
+	}
+	h.Events = splitEvents(events)
+	return &h, nil
+}
+
+func scanDelivery(row scanner) (*types.Delivery, error) {
+	var (
+		d         types.Delivery
+		payload   string
+		status    string
+		lastError sql.NullString
+	)
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &status, &d.Attempts, &d.NextAttempt,
+		&lastError, &d.ResponseCode, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload, d.Status, d.LastError = json.RawMessage(payload), types.DeliveryStatus(status), lastError.String
+	return &d, nil
+}
+
+func scanHold(row scanner) (*types.Hold, error) {
+	var (
+		h        types.Hold
+		from, to sql.NullTime
+		reason   sql.NullString
+	)
+	if err := row.Scan(&h.ID, &h.StreamID, &from, &to, &reason, &h.CreatedAt); err != nil {
+		return nil, err
+	}
+	if from.Valid {
+		h.From = &from.Time
+	}
+	if to.Valid {
+		h.To = &to.Time
+	}
+	h.Reason = reason.String
+	return &h, nil
+}
+
+func scanAuditEntry(row scanner) (*types.AuditEntry, error) {
+	var (
+		e             types.AuditEntry
+		action        string
+		before, after sql.NullString
+	)
+	err := row.Scan(&e.ID, &e.Actor, &action, &e.Entity, &e.EntityID, &before, &after, &e.SourceIP, &e.RequestID,
+		&e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.Action = types.AuditAction(action)
+	if before.Valid {
+		e.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		e.After = json.RawMessage(after.String)
+	}
+	return &e, nil
+}
+
+// joinEvents stores event types of a webhook in a single column.
+func joinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+func splitEvents(events string) []string {
+	if events == "" {
+		return nil
+	}
+	return strings.Split(events, ",")
+}
+
+// queryLimit turns a non-positive limit into no limit.
+func queryLimit(limit int) int {
+	if limit <= 0 {
+		return math.MaxInt32
+	}
+	return limit
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
@@ -0,0 +1,31 @@
+package postgres
+
+// DefaultPoolSize defines the default size of PostgreSQL connection pool.
+const DefaultPoolSize = 16
+
+// DefaultSSLMode defines the default SSL mode of PostgreSQL connections.
+const DefaultSSLMode = "disable"
+
+// Config represents PostgreSQL storage configuration.
+type Config struct {
+	Host     string `yaml:"host"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	SSLMode  string `yaml:"ssl_mode"`
+	PoolSize int    `yaml:"pool_size"`
+}
+
+// UnmarshalYAML satisfies Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawConfig Config
+
+	parsed := rawConfig{PoolSize: DefaultPoolSize, SSLMode: DefaultSSLMode}
+
+	if err := unmarshal(&parsed); err != nil {
+		return err
+	}
+	*c = Config(parsed)
+
+	return nil
+}
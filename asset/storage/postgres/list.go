@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// Columns of fields entities are listed by, see listQuery.
+var (
+	roomColumns     = map[string]string{"id": "id", "name": "name", "created_at": "created_at", "updated_at": "updated_at"}
+	streamColumns   = map[string]string{"id": "id", "room_id": "room_id", "type": "type", "key": "stream_key", "created_at": "created_at", "updated_at": "updated_at"}
+	deviceColumns   = map[string]string{"id": "serial", "model": "model", "room_id": "room_id", "stream_id": "stream_id", "last_seen": "last_seen", "created_at": "created_at", "updated_at": "updated_at"}
+	apiKeyColumns   = map[string]string{"id": "id", "name": "name", "created_at": "created_at"}
+	webhookColumns  = map[string]string{"id": "id", "url": "url", "created_at": "created_at", "updated_at": "updated_at"}
+	deliveryColumns = map[string]string{"id": "id", "webhook_id": "webhook_id", "event_type": "event_type", "status": "status", "next_attempt": "next_attempt", "created_at": "created_at", "updated_at": "updated_at"}
+	holdColumns     = map[string]string{"id": "id", "stream_id": "stream_id", "created_at": "created_at"}
+	auditColumns    = map[string]string{"id": "id", "actor": "actor", "action": "action", "entity": "entity", "entity_id": "entity_id", "created_at": "created_at"}
+)
+
+// listQuery completes the select statement of a table with conditions, order and
+// limit of the query, and returns it with its arguments. Fields are mapped to
+// columns, unknown fields are malformed. Lists are paged by the sort column and
+// the ID, so that pages don't shift as entities are added. There are few
+// combinations of fields, so each statement is prepared once, except those with
+// IN lists, see listRows.
+func listQuery(sel string, columns map[string]string, q *types.ListQuery) (string, []interface{}, error) {
+	if q == nil {
+		q = &types.ListQuery{}
+	}
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	column := func(field string) (string, error) {
+		c, ok := columns[field]
+		if !ok {
+			return "", types.ErrMalformed
+		}
+		return c, nil
+	}
+
+	// filters are added by name, so that the same filters make the same statement.
+	names := make([]string, 0, len(q.Filters))
+	for name := range q.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, err := column(name)
+		if err != nil {
+			return "", nil, err
+		}
+		where = append(where, c+"="+arg(q.Filters[name]))
+	}
+	names = names[:0]
+	for name := range q.In {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, err := column(name)
+		if err != nil {
+			return "", nil, err
+		}
+		values := q.In[name]
+		if len(values) == 0 {
+			where = append(where, "1=0")
+			continue
+		}
+		marks := make([]string, len(values))
+		for i, v := range values {
+			marks[i] = arg(v)
+		}
+		where = append(where, c+" IN ("+strings.Join(marks, ",")+")")
+	}
+	for _, r := range q.Ranges {
+		c, err := column(r.Field)
+		if err != nil || !types.IsTimeField(r.Field) {
+			return "", nil, types.ErrMalformed
+		}
+		if r.From != nil {
+			where = append(where, c+">="+arg(*r.From))
+		}
+		if r.To != nil {
+			// entities without the time count as older than any.
+			where = append(where, "("+c+"<"+arg(*r.To)+" OR "+c+" IS NULL)")
+		}
+	}
+
+	id := columns["id"]
+	by, err := column(q.SortField())
+	if err != nil {
+		return "", nil, err
+	}
+	op, dir := ">", ""
+	if q.Desc {
+		op, dir = "<", " DESC"
+	}
+	if q.After != nil {
+		if by == id {
+			where = append(where, id+op+arg(q.After.ID))
+		} else {
+			var v interface{} = q.After.Value
+			if types.IsTimeField(q.SortField()) {
+				if v, err = types.ParseFieldTime(q.After.Value); err != nil {
+					return "", nil, err
+				}
+			}
+			where = append(where, "("+by+op+arg(v)+" OR "+by+"="+arg(v)+" AND "+id+op+arg(q.After.ID)+")")
+		}
+	}
+
+	query := sel
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	order := id + dir
+	if by != id {
+		order = by + dir + "," + order
+	}
+	return query + " ORDER BY " + order + " LIMIT " + arg(queryLimit(q.Limit)), args, nil
+}
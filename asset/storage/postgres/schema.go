@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// migration upgrades the schema from the previous version to `version`.
+type migration struct {
+	version int
+	stmts   []string
+}
+
+// migrations must be kept in ascending order of version. Never edit a released
+// migration, append a new one instead.
+var migrations = []migration{
+	{version: 1, stmts: []string{
+		`CREATE TABLE rooms(
+			id         VARCHAR(64) NOT NULL,
+			name       VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			updated_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE TABLE streams(
+			id         VARCHAR(64) NOT NULL,
+			room_id    VARCHAR(64) NOT NULL,
+			type       VARCHAR(16) NOT NULL,
+			stream_key VARCHAR(255) NOT NULL,
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			updated_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id),
+			UNIQUE(stream_key),
+			FOREIGN KEY(room_id) REFERENCES rooms(id)
+		)`,
+		`CREATE TABLE devices(
+			serial     VARCHAR(64) NOT NULL,
+			model      VARCHAR(255) NOT NULL DEFAULT '',
+			room_id    VARCHAR(64),
+			stream_id  VARCHAR(64),
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			updated_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(serial),
+			FOREIGN KEY(room_id) REFERENCES rooms(id),
+			FOREIGN KEY(stream_id) REFERENCES streams(id)
+		)`,
+	}},
+	{version: 2, stmts: []string{
+		`ALTER TABLE devices ADD COLUMN last_seen TIMESTAMPTZ(3)`,
+	}},
+	{version: 3, stmts: []string{
+		`CREATE TABLE apikeys(
+			id         VARCHAR(64) NOT NULL,
+			name       VARCHAR(255) NOT NULL DEFAULT '',
+			hash       CHAR(64) NOT NULL,
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+	}},
+	{version: 4, stmts: []string{
+		`CREATE TABLE webhooks(
+			id         VARCHAR(64) NOT NULL,
+			url        VARCHAR(2048) NOT NULL,
+			secret     VARCHAR(255) NOT NULL DEFAULT '',
+			events     TEXT NOT NULL,
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			updated_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE TABLE deliveries(
+			id            VARCHAR(64) NOT NULL,
+			webhook_id    VARCHAR(64) NOT NULL,
+			event_id      VARCHAR(64) NOT NULL,
+			event_type    VARCHAR(64) NOT NULL,
+			payload       TEXT NOT NULL,
+			status        VARCHAR(16) NOT NULL,
+			attempts      INT NOT NULL DEFAULT 0,
+			next_attempt  TIMESTAMPTZ(3) NOT NULL,
+			last_error    TEXT,
+			response_code INT NOT NULL DEFAULT 0,
+			created_at    TIMESTAMPTZ(3) NOT NULL,
+			updated_at    TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id),
+			FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+		)`,
+		`CREATE INDEX deliveries_due ON deliveries(status, next_attempt)`,
+		`CREATE INDEX deliveries_webhook ON deliveries(webhook_id, created_at)`,
+	}},
+	{version: 5, stmts: []string{
+		`CREATE TABLE holds(
+			id         VARCHAR(64) NOT NULL,
+			stream_id  VARCHAR(64) NOT NULL,
+			from_time  TIMESTAMPTZ(3),
+			to_time    TIMESTAMPTZ(3),
+			reason     TEXT,
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE INDEX holds_stream ON holds(stream_id)`,
+	}},
+	{version: 6, stmts: []string{
+		`CREATE TABLE audit(
+			id         VARCHAR(64) NOT NULL,
+			actor      VARCHAR(255) NOT NULL,
+			action     VARCHAR(16) NOT NULL,
+			entity     VARCHAR(32) NOT NULL,
+			entity_id  VARCHAR(255) NOT NULL,
+			before_doc TEXT,
+			after_doc  TEXT,
+			source_ip  VARCHAR(64) NOT NULL DEFAULT '',
+			request_id VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY(id)
+		)`,
+		`CREATE INDEX audit_time ON audit(created_at)`,
+		`CREATE INDEX audit_entity ON audit(entity, entity_id, created_at)`,
+	}},
+	// lists are filtered and sorted by these, see listQuery.
+	{version: 7, stmts: []string{
+		`CREATE INDEX rooms_name ON rooms(name, id)`,
+		`CREATE INDEX rooms_created ON rooms(created_at, id)`,
+		`CREATE INDEX streams_room ON streams(room_id)`,
+		`CREATE INDEX devices_room ON devices(room_id)`,
+		`CREATE INDEX devices_last_seen ON devices(last_seen)`,
+	}},
+}
+
+// tables lists all tables in reverse order of dependency, to be dropped on reset.
+var tables = []string{"audit", "holds", "deliveries", "webhooks", "apikeys", "devices", "streams", "rooms", "kvmeta"}
+
+const (
+	sqlMetaCreate = `CREATE TABLE IF NOT EXISTS kvmeta(
+		name  VARCHAR(64) NOT NULL,
+		value TEXT,
+		PRIMARY KEY(name)
+	)`
+	sqlVersionGet = "SELECT value FROM kvmeta WHERE name='version'"
+	sqlVersionSet = "INSERT INTO kvmeta(name,value) VALUES('version',$1) ON CONFLICT(name) DO UPDATE SET value=EXCLUDED.value"
+)
+
+// Version returns the schema version supported by the adapter.
+func (a *Adapter) Version() int {
+	return migrations[len(migrations)-1].version
+}
+
+// GetDbVersion returns the schema version recorded in the database.
+func (a *Adapter) GetDbVersion() (int, error) {
+	if a.db == nil {
+		return 0, types.ErrNotOpen
+	}
+	var value string
+	if err := a.db.QueryRow(sqlVersionGet).Scan(&value); err != nil {
+		if err == sql.ErrNoRows || isMissingTable(err) {
+			return 0, types.ErrNotFound
+		}
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// CreateDb creates the schema at the latest version. Existing tables are dropped
+// first if reset is true, otherwise an initialized database is an error.
+func (a *Adapter) CreateDb(reset bool) error {
+	if a.db == nil {
+		return types.ErrNotOpen
+	}
+	if reset {
+		for _, table := range tables {
+			if _, err := a.db.Exec("DROP TABLE IF EXISTS " + table + " CASCADE"); err != nil {
+				return err
+			}
+		}
+	} else if ver, err := a.GetDbVersion(); err == nil {
+		return fmt.Errorf("database is already initialized at version %d", ver)
+	} else if err != types.ErrNotFound {
+		return err
+	}
+
+	if _, err := a.db.Exec(sqlMetaCreate); err != nil {
+		return err
+	}
+	return a.migrate(0)
+}
+
+// UpgradeDb applies migrations newer than the recorded schema version.
+func (a *Adapter) UpgradeDb() error {
+	ver, err := a.GetDbVersion()
+	if err == types.ErrNotFound {
+		return errors.New("database is not initialized")
+	}
+	if err != nil {
+		return err
+	}
+	if ver > a.Version() {
+		return fmt.Errorf("database version %d is newer than supported version %d", ver, a.Version())
+	}
+	return a.migrate(ver)
+}
+
+// Each migration runs in its own transaction together with recording the new version.
+func (a *Adapter) migrate(from int) error {
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		if err := a.applyMigration(m); err != nil {
+			return fmt.Errorf("migration to version %d failed, %v", m.version, err)
+		}
+	}
+	return nil
+}
+
+func (a *Adapter) applyMigration(m migration) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec(sqlVersionSet, strconv.Itoa(m.version)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// isMissingTable checks if the error is caused by querying a table which doesn't exist.
+func isMissingTable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == errUndefinedTable
+}
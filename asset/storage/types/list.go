@@ -0,0 +1,210 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// fieldTimeLayout formats time fields, so that they sort the same as the times.
+const fieldTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// timeFields are fields holding times, database backends compare them as times.
+var timeFields = map[string]bool{
+	"created_at":   true,
+	"updated_at":   true,
+	"last_seen":    true,
+	"next_attempt": true,
+}
+
+// IsTimeField checks if the field holds times.
+func IsTimeField(name string) bool {
+	return timeFields[name]
+}
+
+// FormatFieldTime formats the value of a time field.
+func FormatFieldTime(t time.Time) string {
+	return t.UTC().Format(fieldTimeLayout)
+}
+
+// ParseFieldTime parses the value of a time field, e.g. from a cursor.
+func ParseFieldTime(v string) (time.Time, error) {
+	t, err := time.Parse(fieldTimeLayout, v)
+	if err != nil {
+		return time.Time{}, ErrMalformed
+	}
+	return t, nil
+}
+
+// Listed is an entity which can be listed with a ListQuery.
+type Listed interface {
+	// Field returns the value of the named field as it's ordered, empty if not set,
+	// and whether the entity is listed by the field. Times are formatted with
+	// FormatFieldTime. Every entity has a unique `id` field.
+	Field(name string) (string, bool)
+}
+
+// Range selects entities with a time field within [From, To), either end is open
+// if nil. Entities without the time count as older than any.
+type Range struct {
+	Field string
+	From  *time.Time
+	To    *time.Time
+}
+
+// Cursor is the position of an entity in a list, the next page continues after it.
+type Cursor struct {
+	// Value is the sort field of the entity, ID is its unique ID.
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// ListQuery selects, orders and pages entities of a list. A nil query lists all
+// entities ordered by ID.
+type ListQuery struct {
+	// Filters select entities with fields equal to the values, e.g. `room_id`.
+	Filters map[string]string
+	// In selects entities with fields equal to any of the values, none if there
+	// are no values, e.g. streams of a page of rooms.
+	In map[string][]string
+	// Ranges select entities by time fields.
+	Ranges []Range
+	// Sort is the field entities are ordered by, then by ID. Only fields set for
+	// every entity may be sorted by. Entities are ordered by ID if empty.
+	Sort string
+	// Desc reverses the order.
+	Desc bool
+	// After continues the list after the entity at the cursor.
+	After *Cursor
+	// Limit is the most entities returned, all if not positive.
+	Limit int
+}
+
+// Where returns the query of entities with the field equal to the value.
+func Where(field, value string) *ListQuery {
+	return &ListQuery{Filters: map[string]string{field: value}}
+}
+
+// field returns the value of the field of the entity.
+func field(e Listed, name string) string {
+	v, _ := e.Field(name)
+	return v
+}
+
+// SortField returns the field entities are ordered by.
+func (q *ListQuery) SortField() string {
+	if q == nil || q.Sort == "" {
+		return "id"
+	}
+	return q.Sort
+}
+
+// CursorOf returns the position of the entity in the list.
+func (q *ListQuery) CursorOf(e Listed) *Cursor {
+	return &Cursor{Value: field(e, q.SortField()), ID: field(e, "id")}
+}
+
+// Check verifies that the entity is listed by every field of the query, e.g. a
+// zero value of the listed type, and that the cursor holds a valid sort value.
+func (q *ListQuery) Check(e Listed) error {
+	if q == nil {
+		return nil
+	}
+	names := []string{q.SortField()}
+	for name := range q.Filters {
+		names = append(names, name)
+	}
+	for name := range q.In {
+		names = append(names, name)
+	}
+	for _, r := range q.Ranges {
+		if !IsTimeField(r.Field) {
+			return ErrMalformed
+		}
+		names = append(names, r.Field)
+	}
+	for _, name := range names {
+		if _, ok := e.Field(name); !ok {
+			return ErrMalformed
+		}
+	}
+	if q.After != nil && IsTimeField(q.SortField()) {
+		if _, err := ParseFieldTime(q.After.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches checks if the entity is selected by filters and ranges of the query,
+// and comes after its cursor.
+func (q *ListQuery) Matches(e Listed) bool {
+	if q == nil {
+		return true
+	}
+	for name, value := range q.Filters {
+		if field(e, name) != value {
+			return false
+		}
+	}
+	for name, values := range q.In {
+		if !anyOf(field(e, name), values) {
+			return false
+		}
+	}
+	for _, r := range q.Ranges {
+		t := field(e, r.Field)
+		if r.From != nil && (t == "" || t < FormatFieldTime(*r.From)) {
+			return false
+		}
+		if r.To != nil && t != "" && t >= FormatFieldTime(*r.To) {
+			return false
+		}
+	}
+	if q.After != nil && !q.before(q.After, q.CursorOf(e)) {
+		return false
+	}
+	return true
+}
+
+func anyOf(v string, values []string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Less checks if entity a comes before b in the order of the query.
+func (q *ListQuery) Less(a, b Listed) bool {
+	return q.before(q.CursorOf(a), q.CursorOf(b))
+}
+
+func (q *ListQuery) before(a, b *Cursor) bool {
+	x, y := a.Value, b.Value
+	if x == y {
+		x, y = a.ID, b.ID
+	}
+	if q != nil && q.Desc {
+		return x > y
+	}
+	return x < y
+}
+
+// Select returns indexes of entities selected by the query in its order, at most
+// Limit of them. It serves backends which hold entities in memory.
+func (q *ListQuery) Select(n int, entity func(i int) Listed) []int {
+	selected := make([]int, 0)
+	for i := 0; i < n; i++ {
+		if q.Matches(entity(i)) {
+			selected = append(selected, i)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return q.Less(entity(selected[i]), entity(selected[j]))
+	})
+	if q != nil && q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[:q.Limit]
+	}
+	return selected
+}
@@ -0,0 +1,410 @@
+// Package types defines the entities persisted by asset storage adapters.
+package types
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a requested entity does not exist.
+	ErrNotFound = errors.New("storage: not found")
+
+	// ErrDuplicate is returned when an entity with the same unique key already exists.
+	ErrDuplicate = errors.New("storage: duplicate entity")
+
+	// ErrMalformed is returned when an entity is incomplete or invalid.
+	ErrMalformed = errors.New("storage: malformed entity")
+
+	// ErrNotOpen is returned when the adapter is used before Open or after Close.
+	ErrNotOpen = errors.New("storage: adapter is not open")
+
+	// ErrUnavailable is returned when the backend cannot be reached, e.g. the database is down.
+	ErrUnavailable = errors.New("storage: backend unavailable")
+)
+
+// NewID generates a random identifier suitable for entity primary keys.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("storage: failed to generate ID, " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// TimeNow returns current UTC time rounded to milliseconds, the precision
+// every backend is able to keep.
+func TimeNow() time.Time {
+	return time.Now().UTC().Round(time.Millisecond)
+}
+
+// StreamType is the kind of video stream produced in a room.
+type StreamType string
+
+const (
+	// StreamDevice is the stream of an ultrasound device.
+	StreamDevice StreamType = "dev"
+
+	// StreamCamera is the stream of a room camera.
+	StreamCamera StreamType = "cam"
+)
+
+// IsValid checks if the stream type is supported.
+func (t StreamType) IsValid() bool {
+	return t == StreamDevice || t == StreamCamera
+}
+
+// Room is a clinic room which produces video streams.
+type Room struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Stream is a video stream of a room.
+type Stream struct {
+	ID     string     `json:"id"`
+	RoomID string     `json:"room_id"`
+	Type   StreamType `json:"type"`
+	// Key is the stream name used in SRT stream ID, unique across all rooms.
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Device is a piece of hardware, e.g. camera or encoding box.
+type Device struct {
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	RoomID   string `json:"room_id,omitempty"`
+	StreamID string `json:"stream_id,omitempty"`
+	// LastSeen is the time of the last heartbeat, nil if the device never reported.
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// APIKey is a credential of a service calling the API. Only a hash of the secret is kept.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 of the key secret.
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook is a subscription to events, which are delivered to the URL by HTTP POST.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret is the key payloads are signed with.
+	Secret string `json:"-"`
+	// Events lists types of events to deliver, all events if empty.
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wants checks if the webhook is subscribed to events of the type.
+func (h *Webhook) Wants(eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is the state of an event delivery to a webhook.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is waiting for the first or next attempt.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryDelivered was accepted by the receiver.
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed ran out of attempts.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery is an event queued for, or delivered to, a webhook.
+type Delivery struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    DeliveryStatus  `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// NextAttempt is when a pending delivery is tried next.
+	NextAttempt time.Time `json:"next_attempt"`
+	// LastError describes why the last attempt failed.
+	LastError string `json:"last_error,omitempty"`
+	// ResponseCode is the HTTP status of the last attempt, zero if there was no response.
+	ResponseCode int       `json:"response_code,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Hold is a legal hold, it protects recordings of a stream from deletion.
+type Hold struct {
+	ID       string `json:"id"`
+	StreamID string `json:"stream_id"`
+	// From and To limit the hold to recordings in the window, either end is open if nil.
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AuditAction is the kind of change recorded in the audit trail.
+type AuditAction string
+
+const (
+	// AuditCreate records an entity being created.
+	AuditCreate AuditAction = "create"
+	// AuditUpdate records an entity being changed.
+	AuditUpdate AuditAction = "update"
+	// AuditDelete records an entity being deleted.
+	AuditDelete AuditAction = "delete"
+)
+
+// AuditEntry records a change of configuration made through the API. Entries are
+// only ever appended, never changed or deleted.
+type AuditEntry struct {
+	ID string `json:"id"`
+	// Actor is who made the change, e.g. `apikey:<id>` or `token:<subject>`.
+	Actor  string      `json:"actor"`
+	Action AuditAction `json:"action"`
+	// Entity is the kind of entity changed, e.g. `room`, EntityID is its ID.
+	Entity   string `json:"entity"`
+	EntityID string `json:"entity_id"`
+	// Before and After are JSON documents of the entity around the change, nil if
+	// it didn't exist before or after.
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	SourceIP  string          `json:"source_ip,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Validate checks that required fields of the room are set.
+func (r *Room) Validate() error {
+	if r.ID == "" {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the stream are set.
+func (s *Stream) Validate() error {
+	if s.ID == "" || s.RoomID == "" || s.Key == "" || !s.Type.IsValid() {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the device are set.
+func (d *Device) Validate() error {
+	if d.Serial == "" {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the API key are set.
+func (k *APIKey) Validate() error {
+	if k.ID == "" || k.Hash == "" {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the webhook are set.
+func (h *Webhook) Validate() error {
+	if h.ID == "" || h.URL == "" {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the delivery are set.
+func (d *Delivery) Validate() error {
+	if d.ID == "" || d.WebhookID == "" || d.EventID == "" || d.EventType == "" {
+		return ErrMalformed
+	}
+	switch d.Status {
+	case DeliveryPending, DeliveryDelivered, DeliveryFailed:
+		return nil
+	}
+	return ErrMalformed
+}
+
+// Validate checks that required fields of the hold are set.
+func (h *Hold) Validate() error {
+	if h.ID == "" || h.StreamID == "" {
+		return ErrMalformed
+	}
+	if h.From != nil && h.To != nil && !h.From.Before(*h.To) {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Validate checks that required fields of the audit entry are set.
+func (e *AuditEntry) Validate() error {
+	if e.ID == "" || e.Actor == "" || e.Entity == "" || e.EntityID == "" {
+		return ErrMalformed
+	}
+	switch e.Action {
+	case AuditCreate, AuditUpdate, AuditDelete:
+		return nil
+	}
+	return ErrMalformed
+}
+
+// Field returns the value of a field rooms are listed by.
+func (r *Room) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return r.ID, true
+	case "name":
+		return r.Name, true
+	case "created_at":
+		return FormatFieldTime(r.CreatedAt), true
+	case "updated_at":
+		return FormatFieldTime(r.UpdatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field streams are listed by.
+func (s *Stream) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return s.ID, true
+	case "room_id":
+		return s.RoomID, true
+	case "type":
+		return string(s.Type), true
+	case "key":
+		return s.Key, true
+	case "created_at":
+		return FormatFieldTime(s.CreatedAt), true
+	case "updated_at":
+		return FormatFieldTime(s.UpdatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field devices are listed by, the serial is the ID.
+func (d *Device) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return d.Serial, true
+	case "model":
+		return d.Model, true
+	case "room_id":
+		return d.RoomID, true
+	case "stream_id":
+		return d.StreamID, true
+	case "last_seen":
+		if d.LastSeen == nil {
+			return "", true
+		}
+		return FormatFieldTime(*d.LastSeen), true
+	case "created_at":
+		return FormatFieldTime(d.CreatedAt), true
+	case "updated_at":
+		return FormatFieldTime(d.UpdatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field API keys are listed by.
+func (k *APIKey) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return k.ID, true
+	case "name":
+		return k.Name, true
+	case "created_at":
+		return FormatFieldTime(k.CreatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field webhooks are listed by.
+func (h *Webhook) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return h.ID, true
+	case "url":
+		return h.URL, true
+	case "created_at":
+		return FormatFieldTime(h.CreatedAt), true
+	case "updated_at":
+		return FormatFieldTime(h.UpdatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field deliveries are listed by.
+func (d *Delivery) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return d.ID, true
+	case "webhook_id":
+		return d.WebhookID, true
+	case "event_type":
+		return d.EventType, true
+	case "status":
+		return string(d.Status), true
+	case "next_attempt":
+		return FormatFieldTime(d.NextAttempt), true
+	case "created_at":
+		return FormatFieldTime(d.CreatedAt), true
+	case "updated_at":
+		return FormatFieldTime(d.UpdatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field holds are listed by.
+func (h *Hold) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return h.ID, true
+	case "stream_id":
+		return h.StreamID, true
+	case "created_at":
+		return FormatFieldTime(h.CreatedAt), true
+	}
+	return "", false
+}
+
+// Field returns the value of a field audit entries are listed by.
+func (e *AuditEntry) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return e.ID, true
+	case "actor":
+		return e.Actor, true
+	case "action":
+		return string(e.Action), true
+	case "entity":
+		return e.Entity, true
+	case "entity_id":
+		return e.EntityID, true
+	case "created_at":
+		return FormatFieldTime(e.CreatedAt), true
+	}
+	return "", false
+}
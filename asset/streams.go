@@ -0,0 +1,243 @@
+package asset
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+// srtApp is the application name sls uses for both players and publishers.
+const srtApp = "live"
+
+// keyPattern restricts stream keys which become part of SRT stream IDs and file paths.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// streamReq is the body of stream create and update requests.
+type streamReq struct {
+	Type types.StreamType `json:"type"`
+	Key  string           `json:"key"`
+}
+
+// streamURLs are ready-to-use addresses of a stream.
+type streamURLs struct {
+	Publisher string `json:"publisher"`
+	Player    string `json:"player"`
+	HLS       string `json:"hls,omitempty"`
+}
+
+// streamResp is a stream with its addresses and live state.
+type streamResp struct {
+	*types.Stream
+	URLs   streamURLs  `json:"urls"`
+	Status *liveStatus `json:"status,omitempty"`
+}
+
+// publishPath is the stream path relays publish into, `up{domain}/live/{key}`. sls
+// also records HLS of the stream under the same path below `hls_path`.
+func (hc *hubConfig) publishPath(key string) string {
+	return fmt.Sprintf("up%s/%s/%s", hc.Domain, srtApp, key)
+}
+
+// playPath is the stream path players request, `{domain}/live/{key}`.
+func (hc *hubConfig) playPath(key string) string {
+	return fmt.Sprintf("%s/%s/%s", hc.Domain, srtApp, key)
+}
+
+// urls computes addresses of the stream with the given key.
+func (hc *hubConfig) urls(key string) streamURLs {
+	addr := fmt.Sprintf("srt://%s:%d?streamid=", hc.Host, hc.ListenOn)
+	urls := streamURLs{
+		Publisher: addr + hc.publishPath(key),
+		Player:    addr + hc.playPath(key),
+	}
+	if hc.HLSURL != "" {
+		urls.HLS = strings.TrimSuffix(hc.HLSURL, "/") + "/" + path.Join(hc.publishPath(key), key+".m3u8")
+	}
+	return urls
+}
+
+func (s *Server) newStreamResp(st *types.Stream) streamResp {
+	return streamResp{Stream: st, URLs: s.cfg.Hub.urls(st.Key), Status: s.live.status(st.Key)}
+}
+
+func (s *Server) newStreamsResp(streams []types.Stream) []streamResp {
+	resp := make([]streamResp, 0, len(streams))
+	for i := range streams {
+		resp = append(resp, s.newStreamResp(&streams[i]))
+	}
+	return resp
+}
+
+// streamsHandler serves `v0/rooms/{id}/streams` collection, `v0/rooms/{id}/streams/{sid}` items
+// and recordings of the streams with their legal holds.
+func (s *Server) streamsHandler(wrt http.ResponseWriter, req *http.Request, roomID string, parts []string, now time.Time) {
+	switch {
+	case len(parts) == 0:
+		switch req.Method {
+		case http.MethodGet:
+			s.streamList(wrt, req, roomID, now)
+		case http.MethodPost:
+			s.streamCreate(wrt, req, roomID, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 1:
+		switch req.Method {
+		case http.MethodGet:
+			s.streamGet(wrt, roomID, parts[0], now)
+		case http.MethodPut, http.MethodPatch:
+			s.streamUpdate(wrt, req, roomID, parts[0], now)
+		case http.MethodDelete:
+			s.streamDelete(wrt, req, roomID, parts[0], now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 2 && parts[1] == "recordings.m3u8",
+		len(parts) <= 3 && parts[1] == "recordings":
+		s.streamRecordings(wrt, req, roomID, parts[0], parts[1:], now)
+
+	case len(parts) <= 3 && parts[1] == "holds":
+		s.streamHolds(wrt, req, roomID, parts[0], parts[1:], now)
+
+	default:
+		writeResp(wrt, ErrNotFound(now))
+	}
+}
+
+// streamListSpec filters streams of a room by type.
+var streamListSpec = &listSpec{
+	filters: map[string]string{"type": "type"},
+	enums:   map[string][]string{"type": streamTypeSchema.Enum},
+	sorts:   []string{"id", "type", "key", "created_at", "updated_at"},
+	window:  "created_at",
+}
+
+func (s *Server) streamList(wrt http.ResponseWriter, req *http.Request, roomID string, now time.Time) {
+	q, err := streamListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if _, err := s.store.RoomGet(roomID); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if q.Filters == nil {
+		q.Filters = make(map[string]string)
+	}
+	q.Filters["room_id"] = roomID
+	streams, err := s.store.StreamList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(streams), func(i int) types.Listed { return &streams[i] })
+	writeResp(wrt, listResp(now, s.newStreamsResp(streams[:n]), next))
+}
+
+func (s *Server) streamCreate(wrt http.ResponseWriter, req *http.Request, roomID string, now time.Time) {
+	var body streamReq
+	if err := decodeBody(req, streamCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.Key == "" {
+		body.Key = defaultStreamKey(roomID, body.Type)
+	}
+	if !keyPattern.MatchString(body.Key) {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	if _, err := s.store.RoomGet(roomID); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+
+	st := &types.Stream{
+		ID:        types.NewID(),
+		RoomID:    roomID,
+		Type:      body.Type,
+		Key:       body.Key,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.store.StreamCreate(st, s.auditEntry(req, types.AuditCreate, auditStream, st.ID, nil, st, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("streams: Stream '%s' created in room '%s'", st.Key, roomID)
+	writeResp(wrt, NoErrCreated(now, s.newStreamResp(st)))
+}
+
+// roomStream returns the stream only if it belongs to the room.
+func (s *Server) roomStream(roomID, id string) (*types.Stream, error) {
+	st, err := s.store.StreamGet(id)
+	if err != nil {
+		return nil, err
+	}
+	if st.RoomID != roomID {
+		return nil, types.ErrNotFound
+	}
+	return st, nil
+}
+
+func (s *Server) streamGet(wrt http.ResponseWriter, roomID, id string, now time.Time) {
+	st, err := s.roomStream(roomID, id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, s.newStreamResp(st)))
+}
+
+func (s *Server) streamUpdate(wrt http.ResponseWriter, req *http.Request, roomID, id string, now time.Time) {
+	var body streamReq
+	if err := decodeBody(req, streamUpdateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	st, err := s.roomStream(roomID, id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	before := *st
+	if body.Type != "" {
+		st.Type = body.Type
+	}
+	if body.Key != "" {
+		st.Key = body.Key
+	}
+	if !st.Type.IsValid() || !keyPattern.MatchString(st.Key) {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	st.UpdatedAt = now
+	if err := s.store.StreamUpdate(st, s.auditEntry(req, types.AuditUpdate, auditStream, st.ID, &before, st, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, s.newStreamResp(st)))
+}
+
+func (s *Server) streamDelete(wrt http.ResponseWriter, req *http.Request, roomID, id string, now time.Time) {
+	st, err := s.roomStream(roomID, id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if err := s.store.StreamDelete(id, s.auditEntry(req, types.AuditDelete, auditStream, id, st, nil, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("streams: Stream '%s' deleted from room '%s'", id, roomID)
+	writeResp(wrt, NoErr(now, nil))
+}
@@ -0,0 +1,61 @@
+package asset
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestStreamsAPI(t *testing.T) {
+	s, mux := newTestServer(t)
+	s.cfg.Hub = hubConfig{Host: "hub.local", ListenOn: 8080, Domain: "live.example.com", HLSURL: "http://hub.local/hls/"}
+
+	if code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms", map[string]interface{}{"id": "room01", "streams": []string{"dev"}}); code != http.StatusCreated {
+		t.Fatalf("create room: got %d, %s", code, data)
+	}
+
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/rooms/room01/streams", map[string]string{"type": "cam"})
+	if code != http.StatusCreated {
+		t.Fatalf("create stream: got %d, %s", code, data)
+	}
+	var st streamResp
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	want := streamURLs{
+		Publisher: "srt://hub.local:8080?streamid=uplive.example.com/live/room01_cam",
+		Player:    "srt://hub.local:8080?streamid=live.example.com/live/room01_cam",
+		HLS:       "http://hub.local/hls/uplive.example.com/live/room01_cam/room01_cam.m3u8",
+	}
+	if st.Key != "room01_cam" || st.URLs != want {
+		t.Errorf("create stream: got %s", data)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms/room01/streams", map[string]string{"type": "cam"}); code != http.StatusConflict {
+		t.Errorf("create stream with taken key: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/rooms/room01/streams", map[string]string{"type": "mic"}); code != http.StatusBadRequest {
+		t.Errorf("create stream of unknown type: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room02/streams", nil); code != http.StatusNotFound {
+		t.Errorf("list streams of missing room: got %d", code)
+	}
+
+	code, data = doRequest(t, mux, http.MethodPatch, "/api/v0/rooms/room01/streams/"+st.ID, map[string]string{"key": "exam01"})
+	if code != http.StatusOK {
+		t.Fatalf("update stream: got %d, %s", code, data)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room01/streams", nil)
+	var list []streamResp
+	if err := json.Unmarshal(data, &list); err != nil || code != http.StatusOK || len(list) != 2 {
+		t.Fatalf("list streams: got %d, %s", code, data)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodDelete, "/api/v0/rooms/room01/streams/"+st.ID, nil); code != http.StatusOK {
+		t.Errorf("delete stream: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/rooms/room01/streams/"+st.ID, nil); code != http.StatusNotFound {
+		t.Errorf("get deleted stream: got %d", code)
+	}
+}
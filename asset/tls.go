@@ -0,0 +1,210 @@
+package asset
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+)
+
+// defaultCertReloadInterval is how often certificate files are checked for changes.
+const defaultCertReloadInterval = time.Minute
+
+// tlsConfig configures HTTPS. TLS is disabled unless both certificate and key are set.
+type tlsConfig struct {
+	// CertFile and KeyFile are PEM encoded server certificate chain and private key.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCA is a PEM bundle of CAs device certificates are issued by. If set, devices
+	// may authenticate with client certificates, listeners may require them.
+	ClientCA string `yaml:"client_ca"`
+	// ReloadInterval is how often files are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// RedirectHTTP is the address of a plain HTTP listener redirecting clients to
+	// HTTPS, disabled if empty.
+	RedirectHTTP string `yaml:"redirect_http"`
+}
+
+func (tc *tlsConfig) enabled() bool {
+	return tc.CertFile != "" && tc.KeyFile != ""
+}
+
+// certReloader serves certificates and client CAs loaded from files, and picks up
+// changes of the files. Established connections keep the certificate they were
+// made with.
+type certReloader struct {
+	cfg *tlsConfig
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	// modTimes of files at the last load, keyed by path.
+	modTimes map[string]time.Time
+}
+
+func newCertReloader(cfg *tlsConfig) (*certReloader, error) {
+	if !cfg.enabled() {
+		return nil, errors.New("tls: cert_file and key_file are required")
+	}
+	r := &certReloader{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCA != "" {
+		files = append(files, r.cfg.ClientCA)
+	}
+	return files
+}
+
+// load reads all files. Nothing changes if any of them is invalid.
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		modTimes[name] = fi.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: fail to load certificate, %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCA != "" {
+		pem, err := ioutil.ReadFile(r.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificate found in '%s'", r.cfg.ClientCA)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.clientCAs, r.modTimes = &cert, clientCAs, modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports if any file was modified since the last load.
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			// a file being replaced, try again later.
+			return false
+		}
+		if !fi.ModTime().Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// run checks files for changes until stop is closed.
+func (r *certReloader) run(stop <-chan bool) {
+	interval := r.cfg.ReloadInterval
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				logger.Warnf("%v, keep using the previous certificate", err)
+			} else {
+				logger.Infof("tls: Certificate reloaded from '%s'", r.cfg.CertFile)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// configForClient builds the config of a handshake from files loaded at the time.
+func (r *certReloader) configForClient(requireClientCert bool) (*tls.Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.clientCAs != nil {
+		cfg.ClientCAs = r.clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
+// tlsConfig returns the server side config of a listener, every handshake uses
+// current files. Listeners get configs of their own, so that only those devices
+// call refuse clients without a certificate.
+func (r *certReloader) tlsConfig(requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.configForClient(requireClientCert)
+		},
+	}
+}
+
+// redirectToHTTPS sends clients to the same URL over HTTPS on the port of `addr`.
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		// 308 keeps the method and body of API calls.
+		http.Redirect(wrt, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// deviceCertSubject returns the common name of a verified client certificate.
+func deviceCertSubject(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.CommonName
+}
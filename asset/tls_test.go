@@ -0,0 +1,191 @@
+package asset
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for the name, self-signed if parent is nil.
+func testCert(t *testing.T, name string, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert saves the certificate and its key as PEM files.
+func writeCert(t *testing.T, cert *tls.Certificate, certFile, keyFile string) {
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &tlsConfig{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.crt"),
+	}
+	ca := testCert(t, "ca", nil)
+	writeCert(t, ca, cfg.ClientCA, filepath.Join(dir, "ca.key"))
+	writeCert(t, testCert(t, "old.example.com", ca), cfg.CertFile, cfg.KeyFile)
+
+	certs, err := newCertReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs.changed() {
+		t.Error("changed right after load")
+	}
+
+	// a renewed certificate is picked up, a broken one is not.
+	writeCert(t, testCert(t, "new.example.com", ca), cfg.CertFile, cfg.KeyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.CertFile, later, later)
+	if !certs.changed() {
+		t.Fatal("renewed certificate not noticed")
+	}
+	if err := certs.load(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(cfg.KeyFile, []byte("garbage"), 0600)
+	if err := certs.load(); err == nil {
+		t.Error("broken key accepted")
+	}
+	cert, _ := certs.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "new.example.com" {
+		t.Errorf("serving certificate of %s", leaf.Subject.CommonName)
+	}
+}
+
+func TestDeviceCert(t *testing.T) {
+	dir := t.TempDir()
+	s, mux := newTestServer(t)
+	s.cfg.Auth.Enabled = true
+	s.cfg.TLS = tlsConfig{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.crt"),
+	}
+	ca := testCert(t, "ca", nil)
+	writeCert(t, ca, s.cfg.TLS.ClientCA, filepath.Join(dir, "ca.key"))
+	writeCert(t, testCert(t, "127.0.0.1", ca), s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+
+	if code, data := doRequest(t, mux, http.MethodPost, "/api/v0/devices", map[string]string{"serial": "dev01"}); code != http.StatusCreated {
+		t.Fatalf("create device: got %d, %s", code, data)
+	}
+
+	certs, err := newCertReloader(&s.cfg.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(s.authenticate(mux))
+	srv.TLS = certs.tlsConfig(false)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{*testCert(t, "dev01", ca)},
+	}}}
+
+	for path, want := range map[string]int{
+		"/api/v0/devices/dev01/heartbeat": http.StatusOK,
+		"/api/v0/devices/dev02/heartbeat": http.StatusForbidden,
+	} {
+		resp, err := client.Post(srv.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	// without a client certificate credentials are needed as usual.
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Post(srv.URL+"/api/v0/devices/dev01/heartbeat", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no client certificate: got %d", resp.StatusCode)
+	}
+
+	// only listeners requiring client certificates refuse the handshake.
+	devSrv := httptest.NewUnstartedServer(s.authenticate(mux))
+	devSrv.TLS = certs.tlsConfig(true)
+	devSrv.StartTLS()
+	defer devSrv.Close()
+	if resp, err := client.Post(devSrv.URL+"/api/v0/devices/dev01/heartbeat", "application/json", nil); err == nil {
+		resp.Body.Close()
+		t.Error("no client certificate accepted by the device listener")
+	}
+
+	s.cfg.Listeners = []listenerConfig{{Addr: "127.0.0.1:0", Routes: []string{routeAPI}, RequireClientCert: true}}
+	if _, err := s.listeners(certs); err == nil {
+		t.Error("client certificates required without TLS")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://asset.example.com:8080/api/v0/rooms?x=1", nil)
+	rec := httptest.NewRecorder()
+	redirectToHTTPS(":8443").ServeHTTP(rec, req)
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusPermanentRedirect || loc != "https://asset.example.com:8443/api/v0/rooms?x=1" {
+		t.Errorf("got %d to %q", rec.Code, loc)
+	}
+}
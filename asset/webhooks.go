@@ -0,0 +1,536 @@
+package asset
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/asset/storage"
+	"github.com/dantin/media-hub/asset/storage/types"
+	"github.com/dantin/media-hub/pkg/stats"
+)
+
+const (
+	// webhookPollInterval is how often the delivery queue is checked for due retries.
+	webhookPollInterval = 5 * time.Second
+	// webhookTimeout limits a single delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts is how many times a delivery is tried before it fails.
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff is the delay after the first failed attempt, doubled after every other.
+	webhookBaseBackoff = 10 * time.Second
+	// webhookMaxBackoff caps the delay between attempts.
+	webhookMaxBackoff = time.Hour
+	// webhookBatch is how many due deliveries are taken from the queue at once.
+	webhookBatch = 50
+	// webhookWorkers is how many webhooks are delivered to at once.
+	webhookWorkers = 8
+	// webhookOrphanDelay postpones deliveries whose webhook can't be read.
+	webhookOrphanDelay = time.Minute
+	// webhookSecretLen is the length of generated webhook secrets in bytes.
+	webhookSecretLen = 32
+
+	// defaultDeliveryLimit and maxDeliveryLimit bound the size of delivery log pages.
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// Headers of webhook requests.
+const (
+	webhookEventHeader    = "X-Asset-Event"
+	webhookDeliveryHeader = "X-Asset-Delivery"
+	// webhookSignatureHeader is `t={unix time},v1={hex HMAC-SHA256 of "{t}.{body}"}`.
+	webhookSignatureHeader = "X-Asset-Signature"
+)
+
+// webhookDispatcher delivers events to webhooks. Deliveries are queued in storage
+// before the event is published any further, so pending ones survive crashes and
+// restarts and are delivered at least once. Webhooks are delivered to concurrently,
+// each by a worker of its own, so that a slow receiver holds up only itself.
+type webhookDispatcher struct {
+	store  storage.Adapter
+	client *http.Client
+	// wake tells the dispatcher goroutine that deliveries may be due.
+	wake chan struct{}
+
+	mu sync.Mutex
+	// busy are webhooks a worker delivers to, at most webhookWorkers of them.
+	busy    map[string]bool
+	workers sync.WaitGroup
+}
+
+func newWebhookDispatcher(store storage.Adapter) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:  store,
+		client: &http.Client{Timeout: webhookTimeout},
+		wake:   make(chan struct{}, 1),
+		busy:   make(map[string]bool),
+	}
+}
+
+// enqueue queues deliveries of the event and wakes the dispatcher. It's called by
+// the publisher, so the deliveries are stored before the publisher goes on.
+func (d *webhookDispatcher) enqueue(ev *event) {
+	if d.queue(ev) {
+		d.notify()
+	}
+}
+
+// notify wakes the dispatcher goroutine unless it's already woken.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queue stores a delivery of the event for every webhook subscribed to it, and
+// reports if there is any. Events which fail to be queued are logged and counted.
+func (d *webhookDispatcher) queue(ev *event) bool {
+	hooks, err := d.store.WebhookList(nil)
+	if err != nil {
+		stats.Inc("WebhookEventsDropped", 1)
+		logger.Errorf("webhooks: Dropped %s event '%s', failed to list webhooks, %v", ev.Type, ev.ID, err)
+		return false
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		logger.Warnf("webhooks: Failed to encode event %s, %v", ev.Type, err)
+		return false
+	}
+
+	queued := false
+	for i := range hooks {
+		if !hooks[i].Wants(ev.Type) {
+			continue
+		}
+		dl := &types.Delivery{
+			ID:          types.NewID(),
+			WebhookID:   hooks[i].ID,
+			EventID:     ev.ID,
+			EventType:   ev.Type,
+			Payload:     payload,
+			Status:      types.DeliveryPending,
+			NextAttempt: ev.Time,
+			CreatedAt:   ev.Time,
+			UpdatedAt:   ev.Time,
+		}
+		if err := d.store.DeliveryCreate(dl); err != nil {
+			stats.Inc("WebhookEventsDropped", 1)
+			logger.Errorf("webhooks: Dropped %s event '%s' for webhook '%s', %v", ev.Type, ev.ID, hooks[i].ID, err)
+			continue
+		}
+		queued = true
+	}
+	return queued
+}
+
+// run delivers queued events until stop is closed.
+func (d *webhookDispatcher) run(stop <-chan bool) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(types.TimeNow())
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// dispatch hands deliveries which are due to workers, one per webhook. Webhooks
+// which have a worker already, or over the limit of workers, wait for the next
+// dispatch, which a worker asks for once it's done.
+func (d *webhookDispatcher) dispatch(now time.Time) {
+	due, err := d.store.DeliveryDue(now, webhookBatch)
+	if err != nil {
+		logger.Warnf("webhooks: Failed to read delivery queue, %v", err)
+		return
+	}
+
+	var hooks []string
+	byHook := make(map[string][]types.Delivery)
+	for _, dl := range due {
+		if byHook[dl.WebhookID] == nil {
+			hooks = append(hooks, dl.WebhookID)
+		}
+		byHook[dl.WebhookID] = append(byHook[dl.WebhookID], dl)
+	}
+	for _, id := range hooks {
+		if !d.acquire(id) {
+			continue
+		}
+		d.workers.Add(1)
+		go func(id string, due []types.Delivery) {
+			defer d.workers.Done()
+			d.deliver(id, due, now)
+			d.release(id)
+			d.notify()
+		}(id, byHook[id])
+	}
+}
+
+// acquire takes a worker for the webhook, unless it has one or all are taken.
+func (d *webhookDispatcher) acquire(hookID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.busy[hookID] || len(d.busy) >= webhookWorkers {
+		return false
+	}
+	d.busy[hookID] = true
+	return true
+}
+
+func (d *webhookDispatcher) release(hookID string) {
+	d.mu.Lock()
+	delete(d.busy, hookID)
+	d.mu.Unlock()
+}
+
+// wait waits for workers started by dispatch to finish.
+func (d *webhookDispatcher) wait() {
+	d.workers.Wait()
+}
+
+// deliver attempts due deliveries of the webhook in order. Once an attempt fails,
+// the rest wait for its retry, so that a dead receiver costs one timeout a retry.
+func (d *webhookDispatcher) deliver(hookID string, due []types.Delivery, now time.Time) {
+	hook, err := d.store.WebhookGet(hookID)
+	if err != nil && err != types.ErrNotFound {
+		logger.Warnf("webhooks: Failed to read webhook '%s', %v", hookID, err)
+	}
+
+	var retry *time.Time
+	for i := range due {
+		dl := &due[i]
+		switch {
+		case err == types.ErrNotFound:
+			// the webhook was deleted after the queue was read.
+			dl.Status, dl.LastError, dl.UpdatedAt = types.DeliveryFailed, "webhook deleted", types.TimeNow()
+		case err != nil:
+			// try again later rather than reading the same deliveries over and over.
+			dl.LastError, dl.UpdatedAt = err.Error(), types.TimeNow()
+			dl.NextAttempt = now.Add(webhookOrphanDelay)
+		case retry != nil:
+			dl.NextAttempt, dl.UpdatedAt = *retry, types.TimeNow()
+		default:
+			d.attempt(hook, dl)
+			if dl.Status == types.DeliveryPending {
+				retry = &dl.NextAttempt
+			}
+		}
+		if err := d.store.DeliveryUpdate(dl); err != nil {
+			logger.Warnf("webhooks: Failed to record delivery '%s', %v", dl.ID, err)
+		}
+	}
+}
+
+// attempt posts the event to the webhook and updates the delivery with the outcome.
+func (d *webhookDispatcher) attempt(hook *types.Webhook, dl *types.Delivery) {
+	code, err := d.post(hook, dl)
+	now := types.TimeNow()
+	dl.Attempts++
+	dl.ResponseCode = code
+	dl.UpdatedAt = now
+	stats.Inc("WebhookAttempts", 1)
+
+	if err == nil {
+		dl.Status, dl.LastError = types.DeliveryDelivered, ""
+		return
+	}
+
+	dl.LastError = err.Error()
+	if dl.Attempts >= webhookMaxAttempts {
+		dl.Status = types.DeliveryFailed
+		stats.Inc("WebhookFailures", 1)
+		logger.Warnf("webhooks: Giving up delivery of %s to '%s' after %d attempts, %v", dl.EventType, hook.URL, dl.Attempts, err)
+		return
+	}
+	dl.NextAttempt = now.Add(webhookBackoff(dl.Attempts))
+	logger.Infof("webhooks: Delivery of %s to '%s' failed, retry at %s, %v", dl.EventType, hook.URL, dl.NextAttempt.Format(time.RFC3339), err)
+}
+
+// post sends the payload, any 2xx status counts as delivered.
+func (d *webhookDispatcher) post(hook *types.Webhook, dl *types.Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultName+"/"+version)
+	req.Header.Set(webhookEventHeader, dl.EventType)
+	req.Header.Set(webhookDeliveryHeader, dl.ID)
+	req.Header.Set(webhookSignatureHeader, signPayload(hook.Secret, time.Now().Unix(), dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload signs the payload and the time of sending, so receivers can reject replays.
+func signPayload(secret string, ts int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookBackoff is the delay before the next attempt after the given number of attempts.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}
+
+// webhookReq is the body of webhook create and update requests.
+type webhookReq struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// webhookResp is a newly created webhook, the only time its secret is revealed.
+type webhookResp struct {
+	*types.Webhook
+	Secret string `json:"secret"`
+}
+
+// validWebhook checks the URL and event types of a webhook.
+func validWebhook(h *types.Webhook) bool {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	for _, e := range h.Events {
+		if !eventTypes[e] {
+			return false
+		}
+	}
+	return true
+}
+
+// webhooksHandler serves `v0/webhooks` collection, `v0/webhooks/{id}` items and
+// `v0/webhooks/{id}/deliveries` log. All of them are reserved to services.
+func (s *Server) webhooksHandler(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	if !requireAPIKey(wrt, req, now) {
+		return
+	}
+
+	rest := strings.TrimPrefix(req.URL.Path, s.cfg.APIPath+"v0/webhooks")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		switch req.Method {
+		case http.MethodGet:
+			s.webhookList(wrt, req, now)
+		case http.MethodPost:
+			s.webhookCreate(wrt, req, now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 1:
+		switch req.Method {
+		case http.MethodGet:
+			s.webhookGet(wrt, parts[0], now)
+		case http.MethodPut, http.MethodPatch:
+			s.webhookUpdate(wrt, req, parts[0], now)
+		case http.MethodDelete:
+			s.webhookDelete(wrt, req, parts[0], now)
+		default:
+			writeResp(wrt, ErrOperationNotAllowed(now))
+		}
+
+	case len(parts) == 2 && parts[1] == "deliveries":
+		if req.Method != http.MethodGet {
+			writeResp(wrt, ErrOperationNotAllowed(now))
+			return
+		}
+		s.deliveryList(wrt, req, parts[0], now)
+
+	default:
+		writeResp(wrt, ErrNotFound(now))
+	}
+}
+
+// webhookListSpec filters webhooks by URL.
+var webhookListSpec = &listSpec{
+	filters: map[string]string{"url": "url"},
+	sorts:   []string{"id", "url", "created_at", "updated_at"},
+	window:  "created_at",
+}
+
+func (s *Server) webhookList(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	q, err := webhookListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	hooks, err := s.store.WebhookList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(hooks), func(i int) types.Listed { return &hooks[i] })
+	writeResp(wrt, listResp(now, hooks[:n], next))
+}
+
+func (s *Server) webhookCreate(wrt http.ResponseWriter, req *http.Request, now time.Time) {
+	var body webhookReq
+	if err := decodeBody(req, webhookCreateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if body.Secret == "" {
+		b := make([]byte, webhookSecretLen)
+		if _, err := rand.Read(b); err != nil {
+			writeResp(wrt, ErrInternal(now))
+			return
+		}
+		body.Secret = hex.EncodeToString(b)
+	}
+
+	hook := &types.Webhook{
+		ID:        types.NewID(),
+		URL:       body.URL,
+		Secret:    body.Secret,
+		Events:    body.Events,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if !validWebhook(hook) {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	if err := s.store.WebhookCreate(hook, s.auditEntry(req, types.AuditCreate, auditWebhook, hook.ID, nil, hook, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("webhooks: Webhook '%s' created for '%s'", hook.ID, hook.URL)
+	writeResp(wrt, NoErrCreated(now, webhookResp{Webhook: hook, Secret: hook.Secret}))
+}
+
+func (s *Server) webhookGet(wrt http.ResponseWriter, id string, now time.Time) {
+	hook, err := s.store.WebhookGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, hook))
+}
+
+func (s *Server) webhookUpdate(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	var body webhookReq
+	if err := decodeBody(req, webhookUpdateSchema, &body); err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	hook, err := s.store.WebhookGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	before := *hook
+	if body.URL != "" {
+		hook.URL = body.URL
+	}
+	if body.Secret != "" {
+		hook.Secret = body.Secret
+	}
+	if body.Events != nil {
+		hook.Events = body.Events
+	}
+	if !validWebhook(hook) {
+		writeResp(wrt, ErrMalformed(now))
+		return
+	}
+	hook.UpdatedAt = now
+	if err := s.store.WebhookUpdate(hook, s.auditEntry(req, types.AuditUpdate, auditWebhook, id, &before, hook, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	writeResp(wrt, NoErr(now, hook))
+}
+
+func (s *Server) webhookDelete(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	hook, err := s.store.WebhookGet(id)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if err := s.store.WebhookDelete(id, s.auditEntry(req, types.AuditDelete, auditWebhook, id, hook, nil, now)); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	logger.Infof("webhooks: Webhook '%s' deleted", id)
+	writeResp(wrt, NoErr(now, nil))
+}
+
+// deliveryListSpec filters the delivery log of a webhook, newest first by default.
+var deliveryListSpec = &listSpec{
+	filters: map[string]string{"status": "status", "event": "event_type"},
+	enums: map[string][]string{
+		"status": {string(types.DeliveryPending), string(types.DeliveryDelivered), string(types.DeliveryFailed)},
+		"event":  eventTypeSchema.Enum,
+	},
+	sorts:        []string{"created_at", "updated_at", "next_attempt", "id"},
+	desc:         true,
+	window:       "created_at",
+	defaultLimit: defaultDeliveryLimit,
+	maxLimit:     maxDeliveryLimit,
+}
+
+// deliveryList returns the delivery log of a webhook.
+func (s *Server) deliveryList(wrt http.ResponseWriter, req *http.Request, id string, now time.Time) {
+	q, err := deliveryListSpec.parse(req)
+	if err != nil {
+		writeResp(wrt, errMalformed(err, now))
+		return
+	}
+	if _, err := s.store.WebhookGet(id); err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	if q.Filters == nil {
+		q.Filters = make(map[string]string)
+	}
+	q.Filters["webhook_id"] = id
+	list, err := s.store.DeliveryList(q)
+	if err != nil {
+		writeResp(wrt, decodeStoreError(err, now))
+		return
+	}
+	n, next := page(q, len(list), func(i int) types.Listed { return &list[i] })
+	writeResp(wrt, listResp(now, list[:n], next))
+}
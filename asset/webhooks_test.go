@@ -0,0 +1,178 @@
+package asset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dantin/media-hub/asset/storage/types"
+)
+
+func TestWebhooks(t *testing.T) {
+	s, mux := newTestServer(t)
+
+	var (
+		mu       sync.Mutex
+		received []*http.Request
+		bodies   [][]byte
+		status   = http.StatusInternalServerError
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		received = append(received, req)
+		bodies = append(bodies, body)
+		code := status
+		mu.Unlock()
+		wrt.WriteHeader(code)
+	}))
+	defer receiver.Close()
+
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/webhooks", map[string]interface{}{"url": "ftp://example.com"}); code != http.StatusBadRequest {
+		t.Errorf("create with bad URL: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodPost, "/api/v0/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{"stream.exploded"}}); code != http.StatusBadRequest {
+		t.Errorf("create with unknown event: got %d", code)
+	}
+	code, data := doRequest(t, mux, http.MethodPost, "/api/v0/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{eventDeviceOnline}})
+	var hook webhookResp
+	if err := json.Unmarshal(data, &hook); err != nil || code != http.StatusCreated || hook.Secret == "" {
+		t.Fatalf("create webhook: got %d, %s", code, data)
+	}
+	if code, data := doRequest(t, mux, http.MethodGet, "/api/v0/webhooks/"+hook.ID, nil); code != http.StatusOK || strings.Contains(string(data), hook.Secret) {
+		t.Errorf("get webhook: got %d, %s", code, data)
+	}
+
+	s.events = newEventBus()
+	s.hooks = newWebhookDispatcher(s.store)
+	s.events.subscribe(s.hooks.enqueue)
+
+	// events the webhook did not subscribe to are not queued.
+	s.streamChanged("room01_dev", true, nil)
+	s.deviceChanged("SN0001", deviceOnline)
+
+	now := types.TimeNow()
+	s.hooks.dispatch(now)
+	s.hooks.wait()
+	mu.Lock()
+	if len(received) != 1 {
+		t.Fatalf("first attempt: got %d requests", len(received))
+	}
+	status = http.StatusNoContent
+	mu.Unlock()
+
+	// failed delivery waits for its backoff.
+	s.hooks.dispatch(now)
+	s.hooks.wait()
+	if len(received) != 1 {
+		t.Fatalf("retry before backoff: got %d requests", len(received))
+	}
+	s.hooks.dispatch(now.Add(2 * webhookBaseBackoff))
+	s.hooks.wait()
+	if len(received) != 2 {
+		t.Fatalf("retry: got %d requests", len(received))
+	}
+
+	req, body := received[1], bodies[1]
+	if req.Header.Get(webhookEventHeader) != eventDeviceOnline {
+		t.Errorf("event header: got %q", req.Header.Get(webhookEventHeader))
+	}
+	sig := req.Header.Get(webhookSignatureHeader)
+	parts := strings.SplitN(strings.TrimPrefix(sig, "t="), ",", 2)
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || signPayload(hook.Secret, ts, body) != sig {
+		t.Errorf("signature %q does not match body %s", sig, body)
+	}
+	var ev event
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Type != eventDeviceOnline {
+		t.Errorf("payload: got %s", body)
+	}
+
+	code, data = doRequest(t, mux, http.MethodGet, "/api/v0/webhooks/"+hook.ID+"/deliveries", nil)
+	var log []types.Delivery
+	if err := json.Unmarshal(data, &log); err != nil || code != http.StatusOK || len(log) != 1 {
+		t.Fatalf("delivery log: got %d, %s", code, data)
+	}
+	if dl := log[0]; dl.Status != types.DeliveryDelivered || dl.Attempts != 2 || dl.ResponseCode != http.StatusNoContent {
+		t.Errorf("delivery: got %+v", dl)
+	}
+
+	if code, _ := doRequest(t, mux, http.MethodDelete, "/api/v0/webhooks/"+hook.ID, nil); code != http.StatusOK {
+		t.Errorf("delete webhook: got %d", code)
+	}
+	if code, _ := doRequest(t, mux, http.MethodGet, "/api/v0/webhooks/"+hook.ID+"/deliveries", nil); code != http.StatusNotFound {
+		t.Errorf("log of deleted webhook: got %d", code)
+	}
+}
+
+func TestWebhookDeadReceiver(t *testing.T) {
+	s, mux := newTestServer(t)
+
+	release := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer dead.Close()
+	defer close(release)
+	var live int
+	var mu sync.Mutex
+	alive := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		live++
+		mu.Unlock()
+	}))
+	defer alive.Close()
+
+	var hooks []webhookResp
+	for _, u := range []string{dead.URL, alive.URL} {
+		code, data := doRequest(t, mux, http.MethodPost, "/api/v0/webhooks", map[string]interface{}{"url": u, "events": []string{eventDeviceOnline}})
+		var hook webhookResp
+		if err := json.Unmarshal(data, &hook); err != nil || code != http.StatusCreated {
+			t.Fatalf("create webhook: got %d, %s", code, data)
+		}
+		hooks = append(hooks, hook)
+	}
+
+	s.events = newEventBus()
+	s.hooks = newWebhookDispatcher(s.store)
+	s.hooks.client.Timeout = 100 * time.Millisecond
+	s.events.subscribe(s.hooks.enqueue)
+	// deliveries are stored by the time the event is published.
+	s.deviceChanged("SN0001", deviceOnline)
+	s.deviceChanged("SN0002", deviceOnline)
+	if queued, _ := s.store.DeliveryList(nil); len(queued) != 4 {
+		t.Fatalf("queued deliveries: got %d", len(queued))
+	}
+
+	s.hooks.dispatch(types.TimeNow())
+	s.hooks.wait()
+	mu.Lock()
+	if live != 2 {
+		t.Errorf("deliveries to live receiver: got %d", live)
+	}
+	mu.Unlock()
+
+	// the second delivery to the dead receiver waits for the retry of the first.
+	log, err := s.store.DeliveryList(types.Where("webhook_id", hooks[0].ID))
+	if err != nil || len(log) != 2 {
+		t.Fatalf("deliveries to dead receiver: got %+v, %v", log, err)
+	}
+	if attempts := log[0].Attempts + log[1].Attempts; attempts != 1 || !log[0].NextAttempt.Equal(log[1].NextAttempt) ||
+		log[0].Status != types.DeliveryPending || log[1].Status != types.DeliveryPending {
+		t.Errorf("deliveries to dead receiver: got %+v", log)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 4: 80 * time.Second, 20: time.Hour} {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts: got %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -18,7 +18,19 @@ func main() {
 	}
 
 	svr := asset.NewServer(cfg)
-	if err := svr.Run(); err != nil {
+
+	var err error
+	switch {
+	case cfg.InitDB:
+		err = svr.InitDB()
+	case cfg.UpgradeDB:
+		err = svr.UpgradeDB()
+	case cfg.NewAPIKey != "":
+		err = svr.NewAPIKey()
+	default:
+		err = svr.Run()
+	}
+	if err != nil {
 		logger.Fatal(err)
 	}
 }
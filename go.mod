@@ -4,5 +4,9 @@ go 1.15
 
 require (
 	github.com/dantin/logger v0.0.0-20201103035549-f293c6594888
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/lib/pq v1.8.0
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/yaml.v2 v2.3.0
 )
@@ -23,7 +23,11 @@ type Config struct {
 	PIDFile      string         `yaml:"pid_file"`
 	SRTCfg       srtConfig      `yaml:"srt"`
 	PortRelayMap map[string]int `yaml:"port_relay"`
-	rootpath     string
+	// MetricsListen is the address stats are served on over HTTP, disabled if empty.
+	MetricsListen string `yaml:"metrics_listen"`
+	// MetricsPath is the URL path of Prometheus metrics, `/metrics` if empty.
+	MetricsPath string `yaml:"metrics_path"`
+	rootpath    string
 }
 
 // srtConfig
@@ -32,6 +36,11 @@ type srtConfig struct {
 	Domain    string `yaml:"domain"`
 	HLSPath   string `yaml:"hls_path"`
 	HLSStatus string `yaml:"hls_status"`
+	// StatPort is the HTTP port sls serves stream statistics on, disabled if zero.
+	StatPort int `yaml:"stat_port"`
+	// OnEventURL is called by sls to authorize publish and play sessions. Everyone
+	// may publish or play if not set.
+	OnEventURL string `yaml:"on_event_url"`
 }
 
 // NewConfig creates an instance of UDP mutiplex configuration.
@@ -99,6 +108,17 @@ func (cfg *Config) Parse(args []string) error {
 	return nil
 }
 
+// LoadConfig reads SRT server configuration from file, so that other services can
+// follow the settings of the hub.
+func LoadConfig(path string) (*Config, error) {
+	cfg := NewConfig()
+	cfg.rootpath, _ = filepath.Split(path)
+	if err := cfg.configFromFile(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 func (cfg *Config) configFromFile(path string) error {
 	yamlFile, err := ioutil.ReadFile(path)
 	if err != nil {
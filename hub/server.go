@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/pkg/stats"
 	"github.com/dantin/media-hub/pkg/utils"
 	"github.com/dantin/media-hub/subprocess"
 )
@@ -23,6 +24,9 @@ srt {
 
     log_file logs/error.log;
     log_level info;
+{{if .StatPort}}
+    http_port {{.StatPort}};                 #stat served at /sls/stat
+{{end}}
 
     record_hls_path_prefix {{.HLSPath}};
 
@@ -34,6 +38,9 @@ srt {
         domain_publisher up{{.Domain}};
         backlog 100;                         #accept connections at the same time
         idle_streams_timeout 10;             #s -1: unlimited
+{{if .OnEventURL}}
+        on_event_url {{.OnEventURL}};        #?method=on_connect|on_close&role_name=&srt_url=
+{{end}}
         app {
             app_player live;
             app_publisher live;
@@ -46,6 +53,9 @@ srt {
 `
 )
 
+// statsNamespace prefixes metric names of the SRT server.
+const statsNamespace = "srt_server"
+
 // Server encapsulates a SRT live server.
 type Server struct {
 	cfg *Config
@@ -70,6 +80,20 @@ func (s *Server) Run() error {
 
 	s.setupSLSCfg()
 
+	// exposing values for statistics and monitoring.
+	if s.cfg.MetricsListen != "" {
+		stats.Init(statsNamespace)
+		stats.RegisterGauge("RelaysRunning", "Port relays running.")
+		stats.RegisterCounter("RelayStartFailures", "Port relays which failed to start.")
+		stats.RegisterCounter("ProcessErrors", "Errors reported by sls and port relays.")
+
+		metrics, err := stats.Listen(s.cfg.MetricsListen, s.cfg.MetricsPath)
+		if err != nil {
+			return fmt.Errorf("fail to serve metrics, %v", err)
+		}
+		defer metrics.Close()
+	}
+
 	return s.serve(utils.SignalHandler())
 }
 
@@ -100,12 +124,16 @@ func (s *Server) serve(stop <-chan bool) error {
 
 	logger.Infof("There are %d port relay is ready to run.", len(relays))
 
+	running := 0
 	for _, relay := range relays {
 		if err := relay.Run(); err != nil {
 			logger.Warnf("srt-live-transmit start error, %v", err)
+			stats.Inc("RelayStartFailures", 1)
 			continue
 		}
+		running++
 	}
+	stats.Set("RelaysRunning", int64(running))
 
 	// wait for either a termination signal or an underlying error happens.
 Loop:
@@ -129,6 +157,7 @@ Loop:
 			break Loop
 		case err := <-errCh:
 			logger.Warnf("Error from SRT live server, %v", err)
+			stats.Inc("ProcessErrors", 1)
 		}
 	}
 
@@ -0,0 +1,52 @@
+package hub
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupSLSCfg(t *testing.T) {
+	cfg := &Config{rootpath: t.TempDir()}
+	cfg.SRTCfg = srtConfig{ListenOn: 8080, Domain: "live.example.com", HLSPath: "/tmp/hls", HLSStatus: "on"}
+	s := &Server{cfg: cfg}
+
+	render := func() string {
+		if err := s.setupSLSCfg(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cfg.rootpath, "sls.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	conf := render()
+	for _, want := range []string{"listen 8080;", "domain_publisher uplive.example.com;", "record_hls_path_prefix /tmp/hls;"} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("sls.conf is missing %q", want)
+		}
+	}
+	if strings.Contains(conf, "http_port") {
+		t.Error("stat port rendered while disabled")
+	}
+
+	if strings.Contains(conf, "on_event_url") {
+		t.Error("event callback rendered while disabled")
+	}
+
+	cfg.SRTCfg.StatPort = 8181
+	cfg.SRTCfg.OnEventURL = "http://127.0.0.1:8000/api/v0/sls/event"
+	conf = render()
+	for _, want := range []string{"http_port 8181;", "on_event_url http://127.0.0.1:8000/api/v0/sls/event;"} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("sls.conf is missing %q:\n%s", want, conf)
+		}
+	}
+	// stats are polled by the asset server, browsers are not let in.
+	if strings.Contains(conf, "cors_header") {
+		t.Error("stat port open to any origin")
+	}
+}
@@ -0,0 +1,203 @@
+package stats
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dantin/logger"
+)
+
+// contentType is the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ServeMetrics exposes stats in Prometheus text format at the path, disabled if
+// path is empty or "-".
+func ServeMetrics(mux *http.ServeMux, path string) {
+	if path == "" || path == "-" {
+		return
+	}
+	mux.Handle(path, Handler())
+	logger.Infof("stats: Metrics exposed at '%s'", path)
+}
+
+// Handler renders stats in Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		wrt.Header().Set("Content-Type", contentType)
+		if err := WritePrometheus(wrt); err != nil {
+			logger.Warnf("stats: Failed to write metrics, %v", err)
+		}
+	})
+}
+
+// WritePrometheus writes all registered stats in Prometheus text format.
+func WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if v, ok := expvar.Get("Uptime").(expvar.Func); ok {
+		writeHeader(bw, metricName("uptime_seconds"), "gauge", "Seconds since the process started.")
+		fmt.Fprintf(bw, "%s %s\n", metricName("uptime_seconds"), formatFloat(toFloat(v.Value())))
+	}
+	if v, ok := expvar.Get("NumGoroutines").(expvar.Func); ok {
+		writeHeader(bw, metricName("goroutines"), "gauge", "Number of goroutines.")
+		fmt.Fprintf(bw, "%s %s\n", metricName("goroutines"), formatFloat(toFloat(v.Value())))
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, v := range vars {
+		name := metricName(snakeCase(v.name))
+		switch ev := expvar.Get(v.name).(type) {
+		case *expvar.Int:
+			if v.kind == kindCounter {
+				name += "_total"
+				writeHeader(bw, name, "counter", v.help)
+			} else {
+				writeHeader(bw, name, "gauge", v.help)
+			}
+			fmt.Fprintf(bw, "%s %d\n", name, ev.Value())
+
+		case *histogram:
+			writeHeader(bw, name, "histogram", v.help)
+			writeHistogram(bw, name, "", ev)
+
+		case *vec:
+			if v.kind == kindCounter {
+				name += "_total"
+				writeHeader(bw, name, "counter", v.help)
+			} else {
+				writeHeader(bw, name, "histogram", v.help)
+			}
+			for _, e := range ev.sorted() {
+				labels := formatLabels(ev.labels, e.values)
+				switch ee := e.v.(type) {
+				case *expvar.Int:
+					fmt.Fprintf(bw, "%s{%s} %d\n", name, labels, ee.Value())
+				case *histogram:
+					writeHistogram(bw, name, labels+",", ee)
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// writeHistogram writes series of a histogram, `labels` are prepended to the
+// bucket label and must end with a comma if not empty.
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cumulative int64
+	for i, bound := range h.Bounds {
+		cumulative += h.CountPerBucket[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.Count)
+	if labels == "" {
+		fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.Sum))
+		fmt.Fprintf(w, "%s_count %d\n", name, h.Count)
+	} else {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.Sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+	}
+}
+
+// formatLabels renders label pairs as `name="value",...`.
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func metricName(name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "_" + name
+}
+
+// snakeCase turns a variable name into a metric name, e.g. `HTTPRequests` into `http_requests`.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a word starts at an upper case letter following a lower case one, or
+			// at the last upper case letter of an acronym followed by a lower case one.
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Listen serves stats in Prometheus format at the path, and as expvar JSON at
+// `/debug/vars`, on a dedicated HTTP listener. It is meant for services with no
+// HTTP server of their own, the returned server should be shut down on exit.
+func Listen(addr, path string) (*http.Server, error) {
+	if path == "" {
+		path = "/metrics"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	ServeMetrics(mux, path)
+	ServeExpvar(mux, "/debug/vars")
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Warnf("stats: HTTP server failed, %v", err)
+		}
+	}()
+	logger.Infof("stats: Listening on %s", ln.Addr())
+	return server, nil
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"bytes"
+	"expvar"
+	"strings"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"DevicesOnline":  "devices_online",
+		"HTTPRequests":   "http_requests",
+		"StatPollErrors": "stat_poll_errors",
+		"Requests2xx":    "requests2xx",
+		"Uptime":         "uptime",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q): got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	Init("media")
+	RegisterGauge("Live", "Live things.")
+	RegisterCounter("Events", "")
+	RegisterHistogram("Latency", "Request latency.", []float64{0.1, 1})
+	RegisterCounterVec("Requests", "", "route", "code")
+	RegisterHistogramVec("Duration", "", []float64{1}, "route")
+
+	expvar.Get("Live").(*expvar.Int).Set(3)
+	expvar.Get("Events").(*expvar.Int).Add(7)
+	h := expvar.Get("Latency").(*histogram)
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.addSample(v)
+	}
+	requests := expvar.Get("Requests").(*vec)
+	requests.with([]string{"/rooms", "2xx"}).(*expvar.Int).Add(2)
+	requests.with([]string{"/devices", "4xx"}).(*expvar.Int).Add(1)
+	expvar.Get("Duration").(*vec).with([]string{"/rooms"}).(*histogram).addSample(0.5)
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE media_uptime_seconds gauge\n",
+		"# HELP media_live Live things.\n# TYPE media_live gauge\nmedia_live 3\n",
+		"# TYPE media_events_total counter\nmedia_events_total 7\n",
+		"# TYPE media_latency histogram\n",
+		`media_latency_bucket{le="0.1"} 2` + "\n",
+		`media_latency_bucket{le="1"} 3` + "\n",
+		`media_latency_bucket{le="+Inf"} 4` + "\n",
+		"media_latency_sum 2.65\n",
+		"media_latency_count 4\n",
+		"# TYPE media_requests_total counter\n" +
+			`media_requests_total{route="/devices",code="4xx"} 1` + "\n" +
+			`media_requests_total{route="/rooms",code="2xx"} 2` + "\n",
+		`media_duration_bucket{route="/rooms",le="1"} 1` + "\n",
+		`media_duration_sum{route="/rooms"} 0.5` + "\n",
+		`media_duration_count{route="/rooms"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package stats publishes runtime statistics of media-hub services through expvar
+// and in Prometheus text format.
+package stats
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dantin/logger"
+)
+
+// kind tells how a variable is exposed to Prometheus.
+type kind int
+
+const (
+	kindGauge kind = iota
+	kindCounter
+	kindHistogram
+)
+
+// variable is a registered statistic.
+type variable struct {
+	name string
+	kind kind
+	help string
+}
+
+var (
+	mu sync.RWMutex
+	// vars lists registered variables in order of registration.
+	vars      []variable
+	namespace string
+
+	statsUpdate chan *varUpdate
+	initOnce    sync.Once
+)
+
+// histogram is a simple implementation of histogram expvar.Var.
+// `Bounds` specifies the histogram buckets as follows (length = len(bounds)):
+//
+//	(-inf, Bounds[i]] for i = 0
+//	(Bounds[i-1], Bounds[i]] for 0 < i < length
+//	(Bounds[i-1], +inf) for i = length
+type histogram struct {
+	Count          int64     `json:"count"`
+	Sum            float64   `json:"sum"`
+	CountPerBucket []int64   `json:"count_per_bucket"`
+	Bounds         []float64 `json:"bounds"`
+}
+
+func (h *histogram) addSample(v float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	h.Count++
+	h.Sum += v
+	idx := sort.SearchFloat64s(h.Bounds, v)
+	h.CountPerBucket[idx]++
+}
+
+func (h *histogram) String() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if r, err := json.Marshal(h); err == nil {
+		return string(r)
+	}
+	return ""
+}
+
+// vec is a family of variables of the same kind told apart by label values,
+// e.g. a request counter per route.
+type vec struct {
+	labels []string
+	newVar func() expvar.Var
+	// entries are keyed by label values joined with labelSep.
+	entries map[string]*vecEntry
+}
+
+type vecEntry struct {
+	values []string
+	v      expvar.Var
+}
+
+// labelSep joins label values into a map key, it can't appear in valid UTF-8.
+const labelSep = "\xff"
+
+// with returns the variable for the label values, creating it on first use.
+func (vc *vec) with(values []string) expvar.Var {
+	key := strings.Join(values, labelSep)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	e := vc.entries[key]
+	if e == nil {
+		e = &vecEntry{values: values, v: vc.newVar()}
+		vc.entries[key] = e
+	}
+	return e.v
+}
+
+// sorted returns entries ordered by label values.
+func (vc *vec) sorted() []*vecEntry {
+	keys := make([]string, 0, len(vc.entries))
+	for key := range vc.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]*vecEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = vc.entries[key]
+	}
+	return entries
+}
+
+func (vc *vec) String() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	out := make(map[string]interface{}, len(vc.entries))
+	for key, e := range vc.entries {
+		switch v := e.v.(type) {
+		case *expvar.Int:
+			out[strings.Replace(key, labelSep, ",", -1)] = v.Value()
+		default:
+			out[strings.Replace(key, labelSep, ",", -1)] = v
+		}
+	}
+	if r, err := json.Marshal(out); err == nil {
+		return string(r)
+	}
+	return ""
+}
+
+type varUpdate struct {
+	// Name of the variable to update
+	varname string
+	// Value to publish (int, float, etc.)
+	value interface{}
+	// Treat the count as an increment as opposite to the final value.
+	inc bool
+	// Label values of a variable in a vec.
+	labels []string
+}
+
+// Init starts collecting stats. Metric names are prefixed by `ns` in Prometheus format.
+// Updates made before Init are dropped.
+func Init(ns string) {
+	initOnce.Do(func() {
+		namespace = ns
+		statsUpdate = make(chan *varUpdate, 1024)
+
+		start := time.Now()
+		expvar.Publish("Uptime", expvar.Func(func() interface{} {
+			return time.Since(start).Seconds()
+		}))
+		expvar.Publish("NumGoroutines", expvar.Func(func() interface{} {
+			return runtime.NumGoroutine()
+		}))
+
+		go statsUpdater()
+	})
+}
+
+// ServeExpvar exposes stats as expvar JSON at the path, disabled if path is empty or "-".
+func ServeExpvar(mux *http.ServeMux, path string) {
+	if path == "" || path == "-" {
+		return
+	}
+	mux.Handle(path, expvar.Handler())
+	logger.Infof("stats: Variables exposed at '%s'", path)
+}
+
+func register(name string, k kind, help string, v expvar.Var) {
+	expvar.Publish(name, v)
+
+	mu.Lock()
+	vars = append(vars, variable{name: name, kind: k, help: help})
+	mu.Unlock()
+}
+
+// RegisterGauge registers an integer variable which may go up and down.
+func RegisterGauge(name, help string) {
+	register(name, kindGauge, help, new(expvar.Int))
+}
+
+// RegisterCounter registers an integer variable which only goes up.
+func RegisterCounter(name, help string) {
+	register(name, kindCounter, help, new(expvar.Int))
+}
+
+// RegisterHistogram registers histogram variable. `bounds` specifies histogram buckets/bins
+// (see comment next to the `histogram` struct definition).
+func RegisterHistogram(name, help string, bounds []float64) {
+	numBuckets := len(bounds) + 1
+	register(name, kindHistogram, help, &histogram{
+		CountPerBucket: make([]int64, numBuckets),
+		Bounds:         bounds})
+}
+
+// RegisterCounterVec registers a family of counters partitioned by the labels.
+func RegisterCounterVec(name, help string, labels ...string) {
+	register(name, kindCounter, help, &vec{
+		labels:  labels,
+		newVar:  func() expvar.Var { return new(expvar.Int) },
+		entries: make(map[string]*vecEntry),
+	})
+}
+
+// RegisterHistogramVec registers a family of histograms with the same buckets
+// partitioned by the labels.
+func RegisterHistogramVec(name, help string, bounds []float64, labels ...string) {
+	register(name, kindHistogram, help, &vec{
+		labels: labels,
+		newVar: func() expvar.Var {
+			return &histogram{CountPerBucket: make([]int64, len(bounds)+1), Bounds: bounds}
+		},
+		entries: make(map[string]*vecEntry),
+	})
+}
+
+// Set publishes the value of an integer variable.
+func Set(name string, val int64) {
+	if statsUpdate != nil {
+		select {
+		case statsUpdate <- &varUpdate{varname: name, value: val}:
+		default:
+		}
+	}
+}
+
+// Inc publishes an increment (decrement) to an integer variable.
+func Inc(name string, val int) {
+	if statsUpdate != nil {
+		select {
+		case statsUpdate <- &varUpdate{varname: name, value: int64(val), inc: true}:
+		default:
+		}
+	}
+}
+
+// AddHistSample publishes a value (add a sample) to a histogram variable.
+func AddHistSample(name string, val float64) {
+	if statsUpdate != nil {
+		select {
+		case statsUpdate <- &varUpdate{varname: name, value: val}:
+		default:
+		}
+	}
+}
+
+// IncVec publishes an increment to the counter of a vec with the label values.
+func IncVec(name string, val int, labels ...string) {
+	if statsUpdate != nil {
+		select {
+		case statsUpdate <- &varUpdate{varname: name, labels: labels, value: int64(val), inc: true}:
+		default:
+		}
+	}
+}
+
+// AddHistSampleVec adds a sample to the histogram of a vec with the label values.
+func AddHistSampleVec(name string, val float64, labels ...string) {
+	if statsUpdate != nil {
+		select {
+		case statsUpdate <- &varUpdate{varname: name, labels: labels, value: val}:
+		default:
+		}
+	}
+}
+
+// Shutdown stops publishing stats.
+func Shutdown() {
+	if statsUpdate != nil {
+		statsUpdate <- nil
+	}
+}
+
+// The go routine which actually publishes stats updates.
+func statsUpdater() {
+	for upd := range statsUpdate {
+		if upd == nil {
+			statsUpdate = nil
+			// Don't care to close the channel.
+			break
+		}
+
+		// Handle var update
+		if ev := expvar.Get(upd.varname); ev != nil {
+			if vc, ok := ev.(*vec); ok {
+				if len(upd.labels) != len(vc.labels) {
+					logger.Warnf("stats: Variable %s takes %d labels, got %d", upd.varname, len(vc.labels), len(upd.labels))
+					continue
+				}
+				ev = vc.with(upd.labels)
+			}
+			update(ev, upd)
+		} else {
+			panic("stats: update to unknown variable " + upd.varname)
+		}
+	}
+	logger.Infof("stats: Shutdown")
+}
+
+func update(ev expvar.Var, upd *varUpdate) {
+	switch v := ev.(type) {
+	case *expvar.Int:
+		count := upd.value.(int64)
+		if upd.inc {
+			v.Add(count)
+		} else {
+			v.Set(count)
+		}
+	case *histogram:
+		val := upd.value.(float64)
+		v.addSample(val)
+	default:
+		logger.Warnf("stats: Unsupported expvar type %T", ev)
+	}
+}
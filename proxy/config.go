@@ -64,6 +64,10 @@ type Config struct {
 	MirrorAddrs    mirrorList
 	ConnectTimeout time.Duration
 	ResolveTTL     time.Duration
+	// MetricsListen is the address stats are served on over HTTP, disabled if empty.
+	MetricsListen string
+	// MetricsPath is the URL path of Prometheus metrics.
+	MetricsPath string
 }
 
 // NewConfig creates an instance of UDP mutiplex configuration.
@@ -89,6 +93,8 @@ func (cfg *Config) Parse(args []string) error {
 	fs.Var(&cfg.MirrorAddrs, "m", "Comma separated list of mirror addresses (e.g. 'localhost:8081,localhost:8082').")
 	fs.DurationVar(&cfg.ConnectTimeout, "t", 500*time.Millisecond, "Client connect timeout")
 	fs.DurationVar(&cfg.ResolveTTL, "ttl", 20*time.Millisecond, "Mirror resolve TTL")
+	fs.StringVar(&cfg.MetricsListen, "metrics", "", "Address to serve Prometheus metrics on (e.g. ':9103'). Disable if not set.")
+	fs.StringVar(&cfg.MetricsPath, "metrics_path", "/metrics", "URL path of Prometheus metrics.")
 	fs.StringVar(&level, "level", "info", "Log level, supported level: debug, info, error, fatal.")
 
 	if err := fs.Parse(args); err != nil {
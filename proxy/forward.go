@@ -39,6 +39,10 @@ type Forwarder struct {
 
 	upstreamMsgCh   chan packet
 	downstreamMsgCh chan packet
+
+	// forwarded and errors count packets sent to upstream.
+	forwarded uint64
+	errors    uint64
 }
 
 // NewForwarder returns a new UDP forwarder.
@@ -100,6 +104,7 @@ func (fwd *Forwarder) handleDownstreamPackets() {
 			conn, err := net.ListenUDP("udp", fwd.client)
 			if err != nil {
 				logger.Warnf("UDP forwarder failed to dail, drop packet, err %v", err)
+				atomic.AddUint64(&fwd.errors, 1)
 				continue
 			}
 			fwd.connsMap.Store(clientAddr, &connection{
@@ -107,10 +112,10 @@ func (fwd *Forwarder) handleDownstreamPackets() {
 				lastActivity: time.Now(),
 			})
 
-			conn.WriteTo(pkt.data, fwd.upstream)
+			fwd.count(conn.WriteTo(pkt.data, fwd.upstream))
 			go fwd.downstreamReadLoop(pkt.src, conn)
 		} else {
-			conn.(*connection).udp.WriteTo(pkt.data, fwd.upstream)
+			fwd.count(conn.(*connection).udp.WriteTo(pkt.data, fwd.upstream))
 			shouldUpdateLastActivity := false
 			if conn, found := fwd.connsMap.Load(clientAddr); found {
 				if conn.(*connection).lastActivity.Before(
@@ -125,6 +130,25 @@ func (fwd *Forwarder) handleDownstreamPackets() {
 	}
 }
 
+// count records the outcome of sending a packet to upstream.
+func (fwd *Forwarder) count(_ int, err error) {
+	if err != nil {
+		atomic.AddUint64(&fwd.errors, 1)
+	} else {
+		atomic.AddUint64(&fwd.forwarded, 1)
+	}
+}
+
+// clients returns the number of clients with an open upstream connection.
+func (fwd *Forwarder) clients() int {
+	n := 0
+	fwd.connsMap.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
 func (fwd *Forwarder) downstreamReadLoop(addr *net.UDPAddr, upstreamConn *net.UDPConn) {
 	clientAddr := addr.String()
 	for {
@@ -9,16 +9,30 @@ import (
 	"time"
 
 	"github.com/dantin/logger"
+	"github.com/dantin/media-hub/pkg/stats"
 	"github.com/dantin/media-hub/pkg/utils"
 )
 
-const maxBufferSize = 10 * (1 << 10) // 10K
+const (
+	maxBufferSize = 10 * (1 << 10) // 10K
+
+	// statsNamespace prefixes metric names of the multiplex.
+	statsNamespace = "udp_multiplex"
+	// statsInterval is how often packet counters are published.
+	statsInterval = time.Second
+)
 
 // Multiplex encapsulates several UDP forwards which forward each UDP packet from its listening address to its forward list.
 type Multiplex struct {
 	listenAddr *net.UDPAddr
 	forwards   []*Forwarder
 
+	metricsListen string
+	metricsPath   string
+	// packets and bytes count what is received from clients.
+	packets uint64
+	bytes   uint64
+
 	listenConn *net.UDPConn
 
 	closed     uint32
@@ -28,8 +42,10 @@ type Multiplex struct {
 // NewMultiplex returns a runnable UDP multiplex using the given configuration.
 func NewMultiplex(cfg *Config) *Multiplex {
 	m := &Multiplex{
-		listenAddr: cfg.ListenAddr,
-		bufferPool: sync.Pool{New: func() interface{} { return make([]byte, maxBufferSize) }},
+		listenAddr:    cfg.ListenAddr,
+		metricsListen: cfg.MetricsListen,
+		metricsPath:   cfg.MetricsPath,
+		bufferPool:    sync.Pool{New: func() interface{} { return make([]byte, maxBufferSize) }},
 	}
 
 	// build UDP forwards.
@@ -63,6 +79,26 @@ func (m *Multiplex) Run() error {
 	stop := utils.SignalHandler()
 	done := make(chan bool)
 
+	// exposing values for statistics and monitoring.
+	if m.metricsListen != "" {
+		stats.Init(statsNamespace)
+		stats.RegisterCounter("PacketsReceived", "UDP packets received from clients.")
+		stats.RegisterCounter("BytesReceived", "Bytes received from clients.")
+		stats.RegisterCounter("PacketsForwarded", "UDP packets sent to upstreams.")
+		stats.RegisterCounter("ForwardErrors", "UDP packets which could not be sent to upstreams.")
+		stats.RegisterGauge("Clients", "Clients with an open upstream connection.")
+
+		metrics, err := stats.Listen(m.metricsListen, m.metricsPath)
+		if err != nil {
+			return fmt.Errorf("fail to serve metrics, %v", err)
+		}
+		defer metrics.Close()
+
+		statsDone := make(chan bool)
+		defer close(statsDone)
+		go m.publishStats(statsDone)
+	}
+
 	// run forwards.
 	for _, fwd := range m.forwards {
 		fwd.Run()
@@ -146,6 +182,8 @@ func (m *Multiplex) serverLoop() error {
 		if err != nil {
 			continue
 		}
+		atomic.AddUint64(&m.packets, 1)
+		atomic.AddUint64(&m.bytes, uint64(size))
 
 		for _, fwd := range m.forwards {
 			fwd.Forward(packet{
@@ -158,3 +196,29 @@ func (m *Multiplex) serverLoop() error {
 
 	return nil
 }
+
+// publishStats publishes packet counters periodically, counting each packet
+// through stats would not keep up with the traffic.
+func (m *Multiplex) publishStats(stop <-chan bool) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		var forwarded, errors, clients uint64
+		for _, fwd := range m.forwards {
+			forwarded += atomic.LoadUint64(&fwd.forwarded)
+			errors += atomic.LoadUint64(&fwd.errors)
+			clients += uint64(fwd.clients())
+		}
+		stats.Set("PacketsReceived", int64(atomic.LoadUint64(&m.packets)))
+		stats.Set("BytesReceived", int64(atomic.LoadUint64(&m.bytes)))
+		stats.Set("PacketsForwarded", int64(forwarded))
+		stats.Set("ForwardErrors", int64(errors))
+		stats.Set("Clients", int64(clients))
+	}
+}